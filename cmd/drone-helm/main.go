@@ -1,36 +1,84 @@
 package main
 
 import (
-	"fmt"
+	"errors"
+	"io"
 	"os"
+	"os/signal"
+	"syscall"
 
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/pelotech/drone-helm3/internal/env"
 	"github.com/pelotech/drone-helm3/internal/helm"
+	"github.com/pelotech/drone-helm3/internal/logging"
+	"github.com/pelotech/drone-helm3/internal/run"
+)
+
+// Exit codes, so that pipelines can tell what kind of failure stopped the plugin. See the "Exit codes" section of
+// docs/parameter_reference.md.
+const (
+	exitHelmFailure = 1 // a helm command failed, or something else went wrong while executing the plan
+	exitConfig      = 2 // the settings are missing, malformed, or contradict each other
+	exitPrepare     = 3 // the plan's steps couldn't be made ready to run
+	exitTimeout     = 4 // helm gave up waiting for the release's resources to become ready
+	exitCancelled   = 5 // drone-helm3 was told to stop while helm was running
 )
 
 func main() {
 	cfg, err := env.NewConfig(os.Stdout, os.Stderr)
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
-		return
+		// the settings couldn't be read, so there's no knowing which log format they asked for
+		fail(os.Stderr, err)
 	}
 
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		logging.Infof(cfg.Stderr, "received %s; stopping helm", sig)
+		run.Cancel(sig)
+	}()
+
 	// Make the plan
 	plan, err := helm.NewPlan(*cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%w\n", err)
-		os.Exit(1)
+		fail(cfg.Stderr, err)
 	}
 
 	// Execute the plan
-	err = plan.Execute()
+	_, err = plan.Execute()
 
 	// Expect the plan to go off the rails
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 		// Throw away the plan
-		os.Exit(1)
+		fail(cfg.Stderr, err)
+	}
+	logging.Flush(cfg.Stderr)
+}
+
+func fail(stderr io.Writer, err error) {
+	logging.Errorf(stderr, "%s", err.Error())
+	logging.Flush(stderr)
+	os.Exit(exitCode(err))
+}
+
+// exitCode chooses the exit code for the kind of error.
+func exitCode(err error) int {
+	var configErr *env.ConfigError
+	var prepareErr *helm.PrepareError
+	var helmErr *run.HelmError
+
+	switch {
+	case errors.As(err, &configErr):
+		return exitConfig
+	case errors.As(err, &prepareErr):
+		return exitPrepare
+	case errors.As(err, &helmErr) && helmErr.Cancelled():
+		return exitCancelled
+	case errors.As(err, &helmErr) && helmErr.Timeout():
+		return exitTimeout
+	default:
+		return exitHelmFailure
 	}
 }
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/helm"
+	"github.com/pelotech/drone-helm3/internal/run"
+)
+
+func TestExitCode(t *testing.T) {
+	exitStatus := errors.New("exit status 1")
+	wrap := func(err error) error {
+		return fmt.Errorf("while executing *run.Upgrade step: %w", err)
+	}
+
+	assert.Equal(t, exitConfig, exitCode(&env.ConfigError{Err: errors.New("invalid max_age")}))
+	assert.Equal(t, exitPrepare, exitCode(&helm.PrepareError{Err: errors.New("chart is required")}))
+	assert.Equal(t, exitHelmFailure, exitCode(wrap(&run.HelmError{Err: exitStatus})))
+	assert.Equal(t, exitHelmFailure, exitCode(wrap(&run.HelmError{Err: exitStatus, Category: "immutable field"})))
+	assert.Equal(t, exitTimeout, exitCode(wrap(&run.HelmError{Err: exitStatus, Category: "timed out waiting"})))
+	assert.Equal(t, exitCancelled, exitCode(wrap(&run.HelmError{Err: exitStatus, Category: "cancelled"})))
+	assert.Equal(t, exitHelmFailure, exitCode(errors.New("could not write kubeconfig file")))
+}
@@ -5,9 +5,12 @@ import (
 	"io"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/kelseyhightower/envconfig"
+	"github.com/pelotech/drone-helm3/internal/logging"
+	"gopkg.in/yaml.v2"
 )
 
 const (
@@ -27,6 +30,18 @@ type Config struct {
 	// Configuration for drone-helm itself
 	Command            string   `envconfig:"mode"`                   // Helm command to run
 	DroneEvent         string   `envconfig:"drone_build_event"`      // Drone event that invoked this plugin.
+	DroneBuildAction   string   `envconfig:"drone_build_action"`     // Action that accompanied the drone event (e.g. "closed" for a pull request)
+	DroneDeployTo      string   `envconfig:"drone_deploy_to"`        // Target environment of a drone promotion
+	DronePullRequest   string   `envconfig:"drone_pull_request"`     // Pull request number, for use in templated settings
+	DroneBranch        string   `envconfig:"drone_branch"`           // Branch name, for use in templated settings
+	DroneCommitSHA     string   `envconfig:"drone_commit_sha"`       // Commit SHA, for use in templated settings
+	DroneTag           string   `envconfig:"drone_tag"`              // Git tag, for use in templated settings
+	DroneOutput        string   `envconfig:"drone_output"`           // File for passing values to later pipeline steps
+	DroneCardPath      string   `envconfig:"drone_card_path"`        // File for the build's adaptive card
+	DroneBuildNumber   string   `envconfig:"drone_build_number"`     // Build number, for tracing
+	DroneRepo          string   `envconfig:"drone_repo"`             // Repository the build is for, as owner/name
+	DroneBuildLink     string   `envconfig:"drone_build_link"`       // Link to the build in drone's UI, for notifications
+	Environment        string   `envconfig:"environment"`            // Key into Environments for the overrides to apply (defaults to DroneDeployTo)
 	UpdateDependencies bool     `split_words:"true"`                 // [Deprecated] Call `helm dependency update` before the main command (deprecated, use dependencies_action: update instead)
 	DependenciesAction string   `split_words:"true"`                 // Call `helm dependency build` or `helm dependency update` before the main command
 	AddRepos           []string `split_words:"true"`                 // Call `helm repo add` before the main command
@@ -36,6 +51,8 @@ type Config struct {
 	Values             string   ``                                   // Argument to pass to --set in applicable helm commands
 	StringValues       string   `split_words:"true"`                 // Argument to pass to --set-string in applicable helm commands
 	ValuesFiles        []string `split_words:"true"`                 // Arguments to pass to --values in applicable helm commands
+	AgeKey             string   `split_words:"true"`                 // Age private key for decrypting sops-encrypted values files
+	AgeKeyFile         string   `split_words:"true"`                 // File containing age private keys for decrypting sops-encrypted values files
 	Namespace          string   ``                                   // Kubernetes namespace for all helm commands
 	CreateNamespace    bool     `split_words:"true"`                 // Pass --create-namespace to `helm upgrade`
 	KubeToken          string   `split_words:"true"`                 // Kubernetes authentication token to put in .kube/config
@@ -52,19 +69,78 @@ type Config struct {
 	HistoryMax         int      `split_words:"true"`                 // Pass --history-max option
 	Timeout            string   ``                                   // Argument to pass to --timeout in applicable helm commands
 	Chart              string   ``                                   // Chart argument to use in applicable helm commands
-	Release            string   ``                                   // Release argument to use in applicable helm commands
+	Release            string   ``                                   // Release argument to use in applicable helm commands (may be templated)
 	Force              bool     `envconfig:"force_upgrade"`          // Pass --force to applicable helm commands
 	AtomicUpgrade      bool     `split_words:"true"`                 // Pass --atomic to `helm upgrade`
 	CleanupOnFail      bool     `envconfig:"cleanup_failed_upgrade"` // Pass --cleanup-on-fail to `helm upgrade`
 	LintStrictly       bool     `split_words:"true"`                 // Pass --strict to `helm lint`
 	SkipCrds           bool     `split_words:"true"`                 // Pass --skip-crds to `helm upgrade`
+	Preview            bool     ``                                   // Deploy pull requests to their own release and namespace
+	PreviewCleanupOn   string   `envconfig:"preview_cleanup_event"`  // Drone event (and optionally action) that removes a preview environment
+	GCPattern          string   `envconfig:"gc_pattern"`             // Regular expression matching the names of releases to garbage-collect
+	GCSelector         string   `envconfig:"gc_selector"`            // Label selector for the releases to garbage-collect
+	GCAllNamespaces    bool     `envconfig:"gc_all_namespaces"`      // Garbage-collect releases in every namespace
+	MaxAge             string   `split_words:"true"`                 // Releases last updated longer ago than this are garbage-collected
+	ConfigFile         string   `split_words:"true"`                 // YAML file with settings, overridden by those given to drone
+	Parallelism        int      ``                                   // Number of independent steps, such as releases, to run at once
+	Retries            int      ``                                   // Number of times to retry helm commands that fail with transient errors
+	RetryBackoff       string   `split_words:"true"`                 // Time to wait before the first retry, doubled for each one after it
+	RetryableErrors    []string `split_words:"true"`                 // Patterns of helm errors that may be retried
+	DiagnoseOnFailure  bool     `split_words:"true"`                 // Report on the release's state if an upgrade fails
+	DiagnosticsFile    string   `split_words:"true"`                 // File to write the diagnostics report to
+	OutputFile         string   `split_words:"true"`                 // File to write release results to, instead of DroneOutput
+	SummaryFile        string   `split_words:"true"`                 // File to write a markdown summary to, if there's no DroneCardPath
+	LogFormat          string   `split_words:"true"`                 // Format for drone-helm3's output: "text" or "json"
+	PushgatewayURL     string   `envconfig:"pushgateway_url"`        // Prometheus Pushgateway to push the run's metrics to
+	NotifyWebhooks     Webhooks `envconfig:"notify_webhooks"`        // URLs to notify once the plan has been executed
+	NotifyStrict       bool     `split_words:"true"`                 // Fail the build if a notification can't be sent
+	GrafanaURL         string   `envconfig:"grafana_url"`            // Grafana instance to annotate deployments in
+	GrafanaToken       string   `envconfig:"grafana_token"`          // Service account token for Grafana's annotations API
+	GrafanaDashboard   string   `envconfig:"grafana_dashboard_uid"`  // Dashboard to annotate, instead of the whole organization
+	ForgeToken         string   `envconfig:"forge_token"`            // Token for GitHub deployments and pull request comments
+	ForgeAPIURL        string   `envconfig:"forge_api_url"`          // The forge's API, if it isn't github.com
+	EnvironmentURL     string   `envconfig:"environment_url"`        // Where the deployed release can be seen (may be templated)
+	DiffComment        bool     `split_words:"true"`                 // Comment on pull requests with the changes they'd make
+	SmokeTestTimeout   string   `split_words:"true"`                 // How long the smoke tests have to pass
+	SmokeTestInterval  string   `split_words:"true"`                 // Time to wait between attempts at a smoke test
+	SmokeTestRollback  bool     `split_words:"true"`                 // Roll the release back if the smoke tests fail
+
+	Environments Environments `envconfig:"environments"` // Setting overrides for each deployment target
+	Releases     Releases     `envconfig:"releases"`     // Charts to deploy, when deploying more than one
+	SmokeTests   SmokeTests   `envconfig:"smoke_tests"`  // URLs to check once a release has been deployed
+
+	// SecretValues are the values interpolated from environment variables into settings such as values, which may be
+	// secrets, so anything that shows the rendered chart should hide them.
+	SecretValues []string `ignored:"true"`
 
 	Stdout io.Writer `ignored:"true"`
 	Stderr io.Writer `ignored:"true"`
 }
 
-// NewConfig creates a Config and reads environment variables into it, accounting for several possible formats.
+// ConfigError is returned by NewConfig when the settings are missing, malformed, or contradict each other.
+type ConfigError struct {
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// NewConfig creates a Config and reads environment variables into it, accounting for several possible formats. Any
+// error it returns is a *ConfigError.
 func NewConfig(stdout, stderr io.Writer) (*Config, error) {
+	cfg, err := loadConfig(stdout, stderr)
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	return cfg, nil
+}
+
+func loadConfig(stdout, stderr io.Writer) (*Config, error) {
 	var aliases settingAliases
 	if err := envconfig.Process("plugin", &aliases); err != nil {
 		return nil, err
@@ -75,21 +151,19 @@ func NewConfig(stdout, stderr io.Writer) (*Config, error) {
 	}
 
 	cfg := Config{
-		Command:        aliases.Command,
-		AddRepos:       aliases.AddRepos,
-		APIServer:      aliases.APIServer,
-		ServiceAccount: aliases.ServiceAccount,
-		Wait:           aliases.Wait,
-		Force:          aliases.Force,
-		KubeToken:      aliases.KubeToken,
-		Certificate:    aliases.Certificate,
-
 		// set to same default as helm CLI
 		HistoryMax: defaultHistoryMax,
 
 		Stdout: stdout,
 		Stderr: stderr,
 	}
+
+	// settings from the config file are the lowest priority, so they're loaded before anything else
+	if err := cfg.loadConfigFile(); err != nil {
+		return nil, err
+	}
+	aliases.apply(&cfg)
+
 	if err := envconfig.Process("plugin", &cfg); err != nil {
 		return nil, err
 	}
@@ -98,9 +172,23 @@ func NewConfig(stdout, stderr io.Writer) (*Config, error) {
 		return nil, err
 	}
 
+	stdout, stderr, err := logging.Wrap(cfg.LogFormat, cfg.Stdout, cfg.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Stdout, cfg.Stderr = stdout, stderr
+
+	if err := cfg.applyEnvironment(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.renderTemplates(); err != nil {
+		return nil, err
+	}
+
 	if cfg.SkipKubeconfig {
 		if cfg.KubeToken != "" || cfg.Certificate != "" || cfg.APIServer != "" || cfg.ServiceAccount != "" || cfg.SkipTLSVerify {
-			fmt.Fprintf(cfg.Stderr, "Warning: skip_kubeconfig is set. The following kubeconfig-related settings will be ignored: kube_config, kube_certificate, kube_api_server, kube_service_account, skip_tls_verify.")
+			logging.Warnf(cfg.Stderr, "skip_kubeconfig is set. The following kubeconfig-related settings will be ignored: kube_config, kube_certificate, kube_api_server, kube_service_account, skip_tls_verify.")
 		}
 	}
 
@@ -119,6 +207,43 @@ func NewConfig(stdout, stderr io.Writer) (*Config, error) {
 	return &cfg, nil
 }
 
+// applyEnvironment overrides settings with those configured for the selected deployment target.
+func (cfg *Config) applyEnvironment() error {
+	target := cfg.Environment
+	if target == "" {
+		target = cfg.DroneDeployTo
+	}
+	if target == "" {
+		return nil
+	}
+
+	if len(cfg.Environments) == 0 {
+		if cfg.Environment != "" {
+			return fmt.Errorf("environment '%s' was selected, but no environments are configured", target)
+		}
+		// DRONE_DEPLOY_TO is set on every promotion; that doesn't mean the user wants per-environment settings
+		return nil
+	}
+
+	overrides, ok := cfg.Environments[target]
+	if !ok {
+		known := make([]string, 0, len(cfg.Environments))
+		for name := range cfg.Environments {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		return fmt.Errorf("unknown environment '%s' (configured environments: %s)", target, strings.Join(known, ", "))
+	}
+
+	if cfg.Debug {
+		logging.Debugf(cfg.Stderr, "applying settings for environment '%s'", target)
+	}
+	if err := cfg.applySettings(overrides, "environment", "environments"); err != nil {
+		return fmt.Errorf("in environment '%s': %w", target, err)
+	}
+	return nil
+}
+
 func (cfg *Config) loadValuesSecrets() {
 	findVar := regexp.MustCompile(`\$\{?(\w+)\}?`)
 
@@ -127,28 +252,58 @@ func (cfg *Config) loadValuesSecrets() {
 		varName = sigils.ReplaceAllString(varName, "")
 
 		if value, ok := os.LookupEnv(varName); ok {
+			// drone's own variables, such as DRONE_COMMIT_SHA, describe the build rather than being secrets
+			if value != "" && !strings.HasPrefix(varName, "DRONE_") && !strings.HasPrefix(varName, "CI_") {
+				cfg.SecretValues = append(cfg.SecretValues, value)
+			}
 			return value
 		}
 
 		if cfg.Debug {
-			fmt.Fprintf(cfg.Stderr, "$%s not present in environment, replaced with \"\"\n", varName)
+			logging.Debugf(cfg.Stderr, "$%s not present in environment, replaced with \"\"", varName)
 		}
 		return ""
 	}
 
 	cfg.Values = findVar.ReplaceAllStringFunc(cfg.Values, replacer)
 	cfg.StringValues = findVar.ReplaceAllStringFunc(cfg.StringValues, replacer)
+	for i := range cfg.Releases {
+		cfg.Releases[i].Values = findVar.ReplaceAllStringFunc(cfg.Releases[i].Values, replacer)
+		cfg.Releases[i].StringValues = findVar.ReplaceAllStringFunc(cfg.Releases[i].StringValues, replacer)
+	}
 
 	for i := 0; i < len(cfg.AddRepos); i++ {
 		cfg.AddRepos[i] = findVar.ReplaceAllStringFunc(cfg.AddRepos[i], replacer)
 	}
+	for i := range cfg.NotifyWebhooks {
+		cfg.NotifyWebhooks[i].URL = findVar.ReplaceAllStringFunc(cfg.NotifyWebhooks[i].URL, replacer)
+	}
 }
 
 func (cfg Config) logDebug() {
 	if cfg.KubeToken != "" {
 		cfg.KubeToken = "(redacted)"
 	}
-	fmt.Fprintf(cfg.Stderr, "Generated config: %+v\n", cfg)
+	if cfg.AgeKey != "" {
+		cfg.AgeKey = "(redacted)"
+	}
+	if cfg.GrafanaToken != "" {
+		cfg.GrafanaToken = "(redacted)"
+	}
+	if cfg.ForgeToken != "" {
+		cfg.ForgeToken = "(redacted)"
+	}
+	// webhook URLs, such as Slack's, are often secrets in themselves
+	webhooks := make(Webhooks, len(cfg.NotifyWebhooks))
+	for i, webhook := range cfg.NotifyWebhooks {
+		webhook.URL = "(redacted)"
+		webhooks[i] = webhook
+	}
+	cfg.NotifyWebhooks = webhooks
+	if cfg.SecretValues != nil {
+		cfg.SecretValues = []string{"(redacted)"}
+	}
+	logging.Debugf(cfg.Stderr, "Generated config: %+v", cfg)
 }
 
 func (cfg *Config) deprecationWarn() {
@@ -156,11 +311,22 @@ func (cfg *Config) deprecationWarn() {
 		_, barePresent := os.LookupEnv(varname)
 		_, prefixedPresent := os.LookupEnv("PLUGIN_" + varname)
 		if barePresent || prefixedPresent {
-			fmt.Fprintf(cfg.Stderr, "Warning: ignoring deprecated '%s' setting\n", strings.ToLower(varname))
+			logging.Warnf(cfg.Stderr, "ignoring deprecated '%s' setting", strings.ToLower(varname))
 		}
 	}
 }
 
+// Environments maps deployment targets to the settings that should be overridden when deploying to them.
+type Environments map[string]map[string]interface{}
+
+// Decode parses the environments setting, which may be YAML or JSON.
+func (e *Environments) Decode(value string) error {
+	if err := yaml.Unmarshal([]byte(value), e); err != nil {
+		return fmt.Errorf("could not parse environments: %w", err)
+	}
+	return nil
+}
+
 // settingAliases provides alternate environment variable names for certain settings, either because
 // they were renamed during drone-helm3's lifetime or for backward-compatibility with the original
 // drone-helm. Most config options don't need to be included here; adding them to the main Config
@@ -175,3 +341,31 @@ type settingAliases struct {
 	KubeToken      string   `envconfig:"kubernetes_token"`
 	Certificate    string   `envconfig:"kubernetes_certificate"`
 }
+
+// apply copies the aliased settings that were given into the Config.
+func (a settingAliases) apply(cfg *Config) {
+	if a.Command != "" {
+		cfg.Command = a.Command
+	}
+	if a.AddRepos != nil {
+		cfg.AddRepos = a.AddRepos
+	}
+	if a.APIServer != "" {
+		cfg.APIServer = a.APIServer
+	}
+	if a.ServiceAccount != "" {
+		cfg.ServiceAccount = a.ServiceAccount
+	}
+	if a.Wait {
+		cfg.Wait = true
+	}
+	if a.Force {
+		cfg.Force = true
+	}
+	if a.KubeToken != "" {
+		cfg.KubeToken = a.KubeToken
+	}
+	if a.Certificate != "" {
+		cfg.Certificate = a.Certificate
+	}
+}
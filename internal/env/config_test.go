@@ -2,6 +2,7 @@ package env
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
 	"testing"
@@ -169,6 +170,23 @@ func (suite *ConfigTestSuite) TestLogDebug() {
 	suite.Regexp(`^Generated config: \{Command:upgrade.*\}`, stderr.String())
 }
 
+func (suite *ConfigTestSuite) TestLogFormat() {
+	suite.unsetenv("UPGRADE")
+	suite.setenv("PLUGIN_LOG_FORMAT", "json")
+	suite.setenv("PLUGIN_PURGE", "true")
+
+	stderr := strings.Builder{}
+	cfg, err := NewConfig(&strings.Builder{}, &stderr)
+	suite.Require().NoError(err)
+	suite.Equal("json", cfg.LogFormat)
+	suite.Regexp(`^\{"timestamp":"[^"]+","level":"warn","type":"message","message":"ignoring deprecated 'purge' setting"\}\n$`,
+		stderr.String())
+
+	os.Setenv("PLUGIN_LOG_FORMAT", "yaml")
+	_, err = NewConfig(&strings.Builder{}, &strings.Builder{})
+	suite.EqualError(err, "unknown log_format 'yaml' (expected 'text' or 'json')")
+}
+
 func (suite *ConfigTestSuite) TestLogDebugCensorsKubeToken() {
 	stderr := &strings.Builder{}
 	kubeToken := "I'm shy! Don't put me in your build logs!"
@@ -184,6 +202,21 @@ func (suite *ConfigTestSuite) TestLogDebugCensorsKubeToken() {
 	suite.Equal(kubeToken, cfg.KubeToken) // The actual config value should be left unchanged
 }
 
+func (suite *ConfigTestSuite) TestLogDebugCensorsAgeKey() {
+	stderr := &strings.Builder{}
+	ageKey := "AGE-SECRET-KEY-1IMAGINEALLTHEPEOPLE"
+	cfg := Config{
+		Debug:  true,
+		AgeKey: ageKey,
+		Stderr: stderr,
+	}
+
+	cfg.logDebug()
+
+	suite.Contains(stderr.String(), "AgeKey:(redacted)")
+	suite.NotContains(stderr.String(), ageKey)
+}
+
 func (suite *ConfigTestSuite) TestNewConfigWithValuesSecrets() {
 	suite.unsetenv("VALUES")
 	suite.unsetenv("STRING_VALUES")
@@ -200,6 +233,29 @@ func (suite *ConfigTestSuite) TestNewConfigWithValuesSecrets() {
 	suite.Equal("fire=Eru_Ilúvatar,water=", cfg.Values)
 	suite.Equal("rings=1", cfg.StringValues)
 	suite.Equal(fmt.Sprintf("testrepo=https://user:%s@testrepo.test", os.Getenv("SECRET_FIRE")), cfg.AddRepos[0])
+	suite.Equal([]string{"Eru_Ilúvatar", "1", "Eru_Ilúvatar"}, cfg.SecretValues)
+}
+
+func (suite *ConfigTestSuite) TestValuesSecretsFromDrone() {
+	suite.unsetenv("STRING_VALUES")
+	suite.unsetenv("PLUGIN_STRING_VALUES")
+	suite.setenv("DRONE_COMMIT_SHA", "a1b2c3d")
+	suite.setenv("PLUGIN_VALUES", "image.tag=${DRONE_COMMIT_SHA}")
+
+	cfg, err := NewConfig(&strings.Builder{}, &strings.Builder{})
+	suite.Require().NoError(err)
+	suite.Equal("image.tag=a1b2c3d", cfg.Values)
+	suite.Empty(cfg.SecretValues, "drone's own variables aren't secrets")
+}
+
+func (suite *ConfigTestSuite) TestNotifyWebhooksSecrets() {
+	suite.setenv("SECRET_HOOK", "T0/B0/palantir")
+	suite.setenv("PLUGIN_NOTIFY_WEBHOOKS", `[{"url": "https://hooks.slack.com/services/$SECRET_HOOK", "preset": "slack"}]`)
+
+	cfg, err := NewConfig(&strings.Builder{}, &strings.Builder{})
+	suite.Require().NoError(err)
+	suite.Equal(Webhooks{{URL: "https://hooks.slack.com/services/T0/B0/palantir", Preset: PresetSlack}},
+		cfg.NotifyWebhooks)
 }
 
 func (suite *ConfigTestSuite) TestValuesSecretsWithDebugLogging() {
@@ -215,6 +271,7 @@ func (suite *ConfigTestSuite) TestValuesSecretsWithDebugLogging() {
 
 	suite.Contains(stderr.String(), "Values:fire=Eru_Ilúvatar,water=")
 	suite.Contains(stderr.String(), `$SECRET_WATER not present in environment, replaced with ""`)
+	suite.Contains(stderr.String(), "SecretValues:[(redacted)]")
 }
 
 func (suite *ConfigTestSuite) TestHistoryMax() {
@@ -226,6 +283,186 @@ func (suite *ConfigTestSuite) TestHistoryMax() {
 	suite.Assert().Equal(0, conf.HistoryMax)
 }
 
+func (suite *ConfigTestSuite) TestNewConfigWithEnvironments() {
+	suite.unsetenv("ENVIRONMENT")
+	suite.unsetenv("PLUGIN_ENVIRONMENT")
+	suite.unsetenv("NAMESPACE")
+	suite.unsetenv("VALUES_FILES")
+	suite.setenv("PLUGIN_NAMESPACE", "default")
+	suite.setenv("PLUGIN_RELEASE", "gondor")
+	suite.setenv("PLUGIN_TIMEOUT", "30")
+	suite.setenv("DRONE_DEPLOY_TO", "production")
+	suite.setenv("PLUGIN_ENVIRONMENTS", `{
+		"staging": {"namespace": "osgiliath"},
+		"production": {"namespace": "minas-tirith", "values_files": ["./base.yml", "./prod.yml"], "timeout": 600, "wait_for_upgrade": true}
+	}`)
+
+	cfg, err := NewConfig(&strings.Builder{}, &strings.Builder{})
+	suite.Require().NoError(err)
+	suite.Equal("minas-tirith", cfg.Namespace)
+	suite.Equal([]string{"./base.yml", "./prod.yml"}, cfg.ValuesFiles)
+	suite.Equal("600s", cfg.Timeout)
+	suite.True(cfg.Wait)
+	suite.Equal("gondor", cfg.Release, "settings that aren't overridden should be left alone")
+}
+
+func (suite *ConfigTestSuite) TestNewConfigWithExplicitEnvironment() {
+	suite.unsetenv("ENVIRONMENT")
+	suite.setenv("DRONE_DEPLOY_TO", "production")
+	suite.setenv("PLUGIN_ENVIRONMENT", "staging")
+	suite.setenv("PLUGIN_ENVIRONMENTS", "staging:\n  namespace: osgiliath\nproduction:\n  namespace: minas-tirith\n")
+
+	cfg, err := NewConfig(&strings.Builder{}, &strings.Builder{})
+	suite.Require().NoError(err)
+	suite.Equal("osgiliath", cfg.Namespace)
+}
+
+func (suite *ConfigTestSuite) TestNewConfigWithUnknownEnvironment() {
+	suite.unsetenv("ENVIRONMENT")
+	suite.unsetenv("PLUGIN_ENVIRONMENT")
+	suite.setenv("DRONE_DEPLOY_TO", "mordor")
+	suite.setenv("PLUGIN_ENVIRONMENTS", `{"staging": {}, "production": {}}`)
+
+	_, err := NewConfig(&strings.Builder{}, &strings.Builder{})
+	suite.EqualError(err, "unknown environment 'mordor' (configured environments: production, staging)")
+}
+
+func (suite *ConfigTestSuite) TestNewConfigWithUnknownEnvironmentSetting() {
+	suite.unsetenv("ENVIRONMENT")
+	suite.unsetenv("PLUGIN_ENVIRONMENT")
+	suite.setenv("DRONE_DEPLOY_TO", "production")
+	suite.setenv("PLUGIN_ENVIRONMENTS", `{"production": {"namespcae": "minas-tirith"}}`)
+
+	_, err := NewConfig(&strings.Builder{}, &strings.Builder{})
+	suite.EqualError(err, "in environment 'production': unknown setting 'namespcae'")
+}
+
+func (suite *ConfigTestSuite) TestNewConfigWithoutEnvironments() {
+	suite.unsetenv("ENVIRONMENTS")
+	suite.unsetenv("PLUGIN_ENVIRONMENTS")
+	suite.unsetenv("ENVIRONMENT")
+	suite.unsetenv("PLUGIN_ENVIRONMENT")
+	suite.setenv("DRONE_DEPLOY_TO", "production")
+
+	_, err := NewConfig(&strings.Builder{}, &strings.Builder{})
+	suite.NoError(err, "DRONE_DEPLOY_TO shouldn't require environments to be configured")
+
+	suite.setenv("PLUGIN_ENVIRONMENT", "production")
+	_, err = NewConfig(&strings.Builder{}, &strings.Builder{})
+	suite.EqualError(err, "environment 'production' was selected, but no environments are configured")
+}
+
+func (suite *ConfigTestSuite) TestNewConfigRendersTemplates() {
+	suite.unsetenv("RELEASE")
+	suite.unsetenv("NAMESPACE")
+	suite.setenv("DRONE_PULL_REQUEST", "42")
+	suite.setenv("DRONE_BRANCH", "Feature/Improbability_Drive")
+	suite.setenv("PLUGIN_RELEASE", "heart-of-gold-pr-{{ .PullRequest }}")
+	suite.setenv("PLUGIN_NAMESPACE", "{{ .Branch | sanitize }}")
+
+	cfg, err := NewConfig(&strings.Builder{}, &strings.Builder{})
+	suite.Require().NoError(err)
+	suite.Equal("heart-of-gold-pr-42", cfg.Release)
+	suite.Equal("feature-improbability-drive", cfg.Namespace)
+}
+
+func (suite *ConfigTestSuite) TestNewConfigWithReleases() {
+	suite.unsetenv("RELEASES")
+	suite.setenv("DRONE_PULL_REQUEST", "42")
+	suite.setenv("API_TOKEN", "mellon")
+	suite.setenv("PLUGIN_RELEASES", `[
+		{"release": "api-pr-{{ .PullRequest }}", "chart": "./api", "values": "token=$API_TOKEN"},
+		{"release": "web", "chart": "./web", "needs": ["api-pr-{{ .PullRequest }}"]}
+	]`)
+
+	cfg, err := NewConfig(&strings.Builder{}, &strings.Builder{})
+	suite.Require().NoError(err)
+	suite.Require().Len(cfg.Releases, 2)
+	suite.Equal("api-pr-42", cfg.Releases[0].Release)
+	suite.Equal("token=mellon", cfg.Releases[0].Values)
+	suite.Equal("web", cfg.Releases[1].Release)
+	suite.Equal([]string{"api-pr-42"}, cfg.Releases[1].Needs)
+}
+
+func (suite *ConfigTestSuite) writeConfigFile(contents string) string {
+	file, err := ioutil.TempFile("", "drone-helm")
+	suite.Require().NoError(err)
+	defer file.Close()
+	_, err = file.WriteString(contents)
+	suite.Require().NoError(err)
+	return file.Name()
+}
+
+func (suite *ConfigTestSuite) TestNewConfigWithConfigFile() {
+	for _, name := range []string{"RELEASE", "NAMESPACE", "CHART", "ADD_REPOS", "HELM_REPOS", "PLUGIN_HELM_REPOS", "WAIT_FOR_UPGRADE", "PLUGIN_WAIT_FOR_UPGRADE", "ENVIRONMENT", "PLUGIN_ENVIRONMENT"} {
+		suite.unsetenv(name)
+	}
+	configFile := suite.writeConfigFile(`
+add_repos:
+  - shire=https://charts.shire.example.com
+chart: shire/hobbiton
+release: bag-end
+namespace: hobbiton
+wait_for_upgrade: true
+timeout: 300
+releases:
+  - release: green-dragon
+    chart: shire/inn
+environments:
+  production:
+    namespace: bywater
+`)
+	defer os.Remove(configFile)
+
+	suite.setenv("PLUGIN_CONFIG_FILE", configFile)
+	suite.setenv("PLUGIN_NAMESPACE", "tuckborough")
+	suite.unsetenv("PLUGIN_ADD_REPOS")
+	suite.unsetenv("DRONE_DEPLOY_TO")
+
+	cfg, err := NewConfig(&strings.Builder{}, &strings.Builder{})
+	suite.Require().NoError(err)
+	suite.Equal([]string{"shire=https://charts.shire.example.com"}, cfg.AddRepos)
+	suite.Equal("shire/hobbiton", cfg.Chart)
+	suite.Equal("bag-end", cfg.Release)
+	suite.Equal("tuckborough", cfg.Namespace, "drone settings should override the config file")
+	suite.True(cfg.Wait)
+	suite.Equal("300s", cfg.Timeout)
+	suite.Equal(Releases{{Release: "green-dragon", Chart: "shire/inn"}}, cfg.Releases)
+
+	os.Setenv("DRONE_DEPLOY_TO", "production")
+	cfg, err = NewConfig(&strings.Builder{}, &strings.Builder{})
+	suite.Require().NoError(err)
+	suite.Equal("bywater", cfg.Namespace, "the config file's environments should be applied")
+}
+
+func (suite *ConfigTestSuite) TestNewConfigWithInvalidConfigFile() {
+	suite.unsetenv("CONFIG_FILE")
+
+	suite.setenv("PLUGIN_CONFIG_FILE", "/nonexistent/.drone-helm.yaml")
+	_, err := NewConfig(&strings.Builder{}, &strings.Builder{})
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "could not read config file")
+	suite.IsType(&ConfigError{}, err)
+
+	configFile := suite.writeConfigFile("relaese: bag-end\n")
+	defer os.Remove(configFile)
+	os.Setenv("PLUGIN_CONFIG_FILE", configFile)
+	_, err = NewConfig(&strings.Builder{}, &strings.Builder{})
+	suite.EqualError(err, "in config file "+configFile+": unknown setting 'relaese'")
+
+	badEnvironment := suite.writeConfigFile("environments:\n  staging:\n    wait_for_upgrade: sometimes\n")
+	defer os.Remove(badEnvironment)
+	os.Setenv("PLUGIN_CONFIG_FILE", badEnvironment)
+	_, err = NewConfig(&strings.Builder{}, &strings.Builder{})
+	suite.EqualError(err, "in config file "+badEnvironment+": in environment 'staging': invalid value for 'wait_for_upgrade': 'sometimes' is not a boolean")
+
+	nested := suite.writeConfigFile("config_file: ./other.yaml\n")
+	defer os.Remove(nested)
+	os.Setenv("PLUGIN_CONFIG_FILE", nested)
+	_, err = NewConfig(&strings.Builder{}, &strings.Builder{})
+	suite.EqualError(err, "in config file "+nested+": unknown setting 'config_file'")
+}
+
 func (suite *ConfigTestSuite) setenv(key, val string) {
 	orig, ok := os.LookupEnv(key)
 	if ok {
@@ -0,0 +1,64 @@
+package env
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/kelseyhightower/envconfig"
+	"gopkg.in/yaml.v2"
+)
+
+// configFileLocation finds the config_file setting before the rest of the Config is read.
+type configFileLocation struct {
+	ConfigFile string `split_words:"true"`
+}
+
+// loadConfigFile applies the settings in the file named by the config_file setting, if there is one. The file is a
+// YAML mapping of setting names to values, in the same format as drone's `settings` block.
+func (cfg *Config) loadConfigFile() error {
+	var location configFileLocation
+	if err := envconfig.Process("plugin", &location); err != nil {
+		return err
+	}
+	if err := envconfig.Process("", &location); err != nil {
+		return err
+	}
+	if location.ConfigFile == "" {
+		return nil
+	}
+
+	raw, err := ioutil.ReadFile(location.ConfigFile)
+	if err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(raw, &settings); err != nil {
+		return fmt.Errorf("could not parse config file %s: %w", location.ConfigFile, err)
+	}
+	if err := cfg.applySettings(settings, "config_file"); err != nil {
+		return fmt.Errorf("in config file %s: %w", location.ConfigFile, err)
+	}
+	if err := validateEnvironments(cfg.Environments); err != nil {
+		return fmt.Errorf("in config file %s: %w", location.ConfigFile, err)
+	}
+	return nil
+}
+
+// validateEnvironments checks that every per-environment override names a real setting with a suitable value, so that
+// mistakes are caught whichever environment is being deployed.
+func validateEnvironments(environments Environments) error {
+	names := make([]string, 0, len(environments))
+	for name := range environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var scratch Config
+		if err := scratch.applySettings(environments[name], "environment", "environments"); err != nil {
+			return fmt.Errorf("in environment '%s': %w", name, err)
+		}
+	}
+	return nil
+}
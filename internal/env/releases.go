@@ -0,0 +1,120 @@
+package env
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Releases lists the charts to deploy when a single invocation manages several releases.
+type Releases []Release
+
+// A Release holds the settings that may differ between the charts in Releases. Settings it leaves blank are
+// inherited from the top-level Config.
+type Release struct {
+	Chart        string
+	Release      string
+	Namespace    string
+	Values       string
+	StringValues string
+	ValuesFiles  []string
+	Needs        []string // releases that must be deployed before this one
+	SmokeTests   SmokeTests
+}
+
+// Decode parses the releases setting, which may be YAML or JSON.
+func (r *Releases) Decode(value string) error {
+	var releases Releases
+	if err := yaml.UnmarshalStrict([]byte(value), &releases); err != nil {
+		return fmt.Errorf("could not parse releases: %w", err)
+	}
+	for i, release := range releases {
+		if release.Release == "" {
+			return fmt.Errorf("releases[%d] has no release name", i)
+		}
+	}
+	*r = releases
+	return nil
+}
+
+// UnmarshalYAML reads a release's settings, accepting the same formats as the equivalent drone settings.
+func (r *Release) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw struct {
+		Chart        string      `yaml:"chart"`
+		Release      string      `yaml:"release"`
+		Namespace    string      `yaml:"namespace"`
+		Values       interface{} `yaml:"values"`
+		StringValues interface{} `yaml:"string_values"`
+		ValuesFiles  interface{} `yaml:"values_files"`
+		Needs        interface{} `yaml:"needs"`
+		SmokeTests   interface{} `yaml:"smoke_tests"`
+	}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	var err error
+	*r = Release{
+		Chart:     raw.Chart,
+		Release:   raw.Release,
+		Namespace: raw.Namespace,
+	}
+	if r.Values, err = scalarString(raw.Values); err != nil {
+		return fmt.Errorf("invalid value for 'values': %w", err)
+	}
+	if r.StringValues, err = scalarString(raw.StringValues); err != nil {
+		return fmt.Errorf("invalid value for 'string_values': %w", err)
+	}
+	if raw.ValuesFiles != nil {
+		if r.ValuesFiles, err = stringList(raw.ValuesFiles); err != nil {
+			return fmt.Errorf("invalid value for 'values_files': %w", err)
+		}
+	}
+	if raw.Needs != nil {
+		if r.Needs, err = stringList(raw.Needs); err != nil {
+			return fmt.Errorf("invalid value for 'needs': %w", err)
+		}
+	}
+	if raw.SmokeTests != nil {
+		tests, err := yaml.Marshal(raw.SmokeTests)
+		if err != nil {
+			return fmt.Errorf("invalid value for 'smoke_tests': %w", err)
+		}
+		if err := r.SmokeTests.Decode(string(tests)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForRelease returns a copy of the Config that deploys the given release instead of the top-level one.
+func (cfg Config) ForRelease(release Release) Config {
+	cfg.Release = release.Release
+	if release.Chart != "" {
+		cfg.Chart = release.Chart
+	}
+	if release.Namespace != "" {
+		cfg.Namespace = release.Namespace
+	}
+	if release.Values != "" {
+		cfg.Values = release.Values
+	}
+	if release.StringValues != "" {
+		cfg.StringValues = release.StringValues
+	}
+	if release.ValuesFiles != nil {
+		cfg.ValuesFiles = release.ValuesFiles
+	}
+	if release.SmokeTests != nil {
+		cfg.SmokeTests = release.SmokeTests
+	}
+	if cfg.DiagnosticsFile != "" {
+		// each release is diagnosed separately, perhaps at the same time as the others, so each needs its own file
+		ext := filepath.Ext(cfg.DiagnosticsFile)
+		cfg.DiagnosticsFile = strings.TrimSuffix(cfg.DiagnosticsFile, ext) + "-" + release.Release + ext
+	}
+	cfg.Releases = nil
+	return cfg
+}
@@ -0,0 +1,141 @@
+package env
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ReleasesTestSuite struct {
+	suite.Suite
+}
+
+func TestReleasesTestSuite(t *testing.T) {
+	suite.Run(t, new(ReleasesTestSuite))
+}
+
+func (suite *ReleasesTestSuite) TestDecode() {
+	var releases Releases
+	err := releases.Decode(`
+- release: api
+  chart: ./charts/api
+  namespace: backend
+  values: [replicas=3, image.tag=v1]
+  values_files: ./values/api.yml
+- release: web
+  chart: ./charts/web
+  string_values: build=0042
+  values_files: [./values/web.yml, ./values/web-prod.yml]
+  needs: api
+`)
+	suite.Require().NoError(err)
+	suite.Equal(Releases{
+		{
+			Release:     "api",
+			Chart:       "./charts/api",
+			Namespace:   "backend",
+			Values:      "replicas=3,image.tag=v1",
+			ValuesFiles: []string{"./values/api.yml"},
+		},
+		{
+			Release:      "web",
+			Chart:        "./charts/web",
+			StringValues: "build=0042",
+			ValuesFiles:  []string{"./values/web.yml", "./values/web-prod.yml"},
+			Needs:        []string{"api"},
+		},
+	}, releases)
+}
+
+func (suite *ReleasesTestSuite) TestDecodeJSON() {
+	var releases Releases
+	suite.Require().NoError(releases.Decode(`[{"release": "worker", "chart": "./charts/worker", "values": "queues=4", "needs": ["queue", "db"]}]`))
+	suite.Equal(Releases{{Release: "worker", Chart: "./charts/worker", Values: "queues=4", Needs: []string{"queue", "db"}}}, releases)
+}
+
+func (suite *ReleasesTestSuite) TestDecodeErrors() {
+	var releases Releases
+	err := releases.Decode(`[{"release": "worker", "chrat": "./charts/worker"}]`)
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "field chrat not found")
+
+	err = releases.Decode(`[{"release": "worker"}, {"chart": "./charts/web"}]`)
+	suite.EqualError(err, "releases[1] has no release name")
+
+	err = releases.Decode(`[{"release": "worker", "values": {"replicas": 3}}]`)
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "invalid value for 'values'")
+
+	err = releases.Decode(`[{"release": "worker", "smoke_tests": [{"status": 200}]}]`)
+	suite.EqualError(err, "could not parse releases: smoke_tests[0]: no url given")
+}
+
+func (suite *ReleasesTestSuite) TestDecodeSmokeTests() {
+	var releases Releases
+	err := releases.Decode(`
+- release: api
+  smoke_tests:
+  - url: https://api.example.com/healthz
+    status: 204
+- release: web
+  smoke_tests: https://www.example.com
+`)
+	suite.Require().NoError(err)
+	suite.Equal(SmokeTests{{URL: "https://api.example.com/healthz", Status: 204}}, releases[0].SmokeTests)
+	suite.Equal(SmokeTests{{URL: "https://www.example.com", Status: 200}}, releases[1].SmokeTests)
+
+	cfg := Config{SmokeTests: SmokeTests{{URL: "https://example.com", Status: 200}}}
+	suite.Equal(releases[0].SmokeTests, cfg.ForRelease(releases[0]).SmokeTests)
+	suite.Equal(cfg.SmokeTests, cfg.ForRelease(Release{Release: "worker"}).SmokeTests,
+		"releases without smoke tests should inherit the top-level ones")
+}
+
+func (suite *ReleasesTestSuite) TestForRelease() {
+	cfg := Config{
+		Chart:        "./charts/default",
+		Namespace:    "default",
+		Values:       "replicas=1",
+		StringValues: "build=1",
+		ValuesFiles:  []string{"./common.yml"},
+		Timeout:      "5m",
+		Releases:     Releases{{Release: "api"}, {Release: "web"}},
+	}
+
+	api := cfg.ForRelease(Release{Release: "api", Chart: "./charts/api", ValuesFiles: []string{"./api.yml"}})
+	suite.Equal("api", api.Release)
+	suite.Equal("./charts/api", api.Chart)
+	suite.Equal("default", api.Namespace, "unset settings should be inherited")
+	suite.Equal("replicas=1", api.Values)
+	suite.Equal("build=1", api.StringValues)
+	suite.Equal([]string{"./api.yml"}, api.ValuesFiles)
+	suite.Equal("5m", api.Timeout)
+	suite.Nil(api.Releases)
+
+	web := cfg.ForRelease(Release{Release: "web", Namespace: "frontend", Values: "replicas=2", StringValues: "build=2"})
+	suite.Equal("frontend", web.Namespace)
+	suite.Equal("replicas=2", web.Values)
+	suite.Equal("build=2", web.StringValues)
+	suite.Equal([]string{"./common.yml"}, web.ValuesFiles)
+	suite.Empty(web.DiagnosticsFile)
+}
+
+func (suite *ReleasesTestSuite) TestForReleaseDiagnosticsFile() {
+	cfg := Config{DiagnosticsFile: "reports/diagnostics.txt"}
+	suite.Equal("reports/diagnostics-api.txt", cfg.ForRelease(Release{Release: "api"}).DiagnosticsFile,
+		"each release should have a diagnostics file of its own")
+
+	cfg.DiagnosticsFile = "diagnostics"
+	suite.Equal("diagnostics-web", cfg.ForRelease(Release{Release: "web"}).DiagnosticsFile)
+}
+
+func (suite *ReleasesTestSuite) TestApplySettingsOverridesReleases() {
+	cfg := Config{Stderr: &strings.Builder{}}
+	err := cfg.applySettings(map[string]interface{}{
+		"releases": []interface{}{
+			map[interface{}]interface{}{"release": "api", "namespace": "backend-staging"},
+		},
+	})
+	suite.Require().NoError(err)
+	suite.Equal(Releases{{Release: "api", Namespace: "backend-staging"}}, cfg.Releases)
+}
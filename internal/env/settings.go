@@ -0,0 +1,178 @@
+package env
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kelseyhightower/envconfig"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// These mirror the expressions envconfig uses to derive a setting's name from a split_words field.
+	gatherWords  = regexp.MustCompile("([^A-Z]+|[A-Z]+[^A-Z]+|[A-Z]+)")
+	acronymWords = regexp.MustCompile("([A-Z]+)([A-Z][^A-Z]+)")
+)
+
+// settingFields maps each setting name (as it would appear in drone's `settings` block) to the index of the
+// corresponding Config field. Variables that drone itself sets are not settings, and are omitted.
+func settingFields() map[string]int {
+	fields := make(map[string]int)
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := settingName(field)
+		if field.Tag.Get("ignored") == "true" || strings.HasPrefix(name, "drone_") {
+			continue
+		}
+		fields[name] = i
+	}
+	return fields
+}
+
+func settingName(field reflect.StructField) string {
+	if name := field.Tag.Get("envconfig"); name != "" {
+		return strings.ToLower(name)
+	}
+	if field.Tag.Get("split_words") != "true" {
+		return strings.ToLower(field.Name)
+	}
+
+	var words []string
+	for _, match := range gatherWords.FindAllString(field.Name, -1) {
+		if m := acronymWords.FindStringSubmatch(match); len(m) == 3 {
+			words = append(words, m[1], m[2])
+		} else {
+			words = append(words, match)
+		}
+	}
+	return strings.ToLower(strings.Join(words, "_"))
+}
+
+// applySettings overrides Config fields with values decoded from YAML or JSON, keyed by setting name. The values are
+// interpreted the same way as the equivalent drone settings: lists may be given as sequences or comma-separated
+// strings, and booleans and numbers may be quoted.
+func (cfg *Config) applySettings(settings map[string]interface{}, protected ...string) error {
+	fields := settingFields()
+	for _, name := range protected {
+		delete(fields, name)
+	}
+
+	// apply in a consistent order, so that any error is reported consistently
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	target := reflect.ValueOf(cfg).Elem()
+	for _, name := range names {
+		index, ok := fields[name]
+		if !ok {
+			return fmt.Errorf("unknown setting '%s'", name)
+		}
+		if err := setField(target.Field(index), settings[name]); err != nil {
+			return fmt.Errorf("invalid value for '%s': %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, value interface{}) error {
+	// settings with their own format, like releases, are round-tripped through YAML
+	if decoder, ok := field.Addr().Interface().(envconfig.Decoder); ok {
+		raw, err := yaml.Marshal(value)
+		if err != nil {
+			return err
+		}
+		return decoder.Decode(string(raw))
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		s, err := scalarString(value)
+		if err != nil {
+			return err
+		}
+		field.SetString(s)
+	case reflect.Bool:
+		s, err := scalarString(value)
+		if err != nil {
+			return err
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a boolean", s)
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		s, err := scalarString(value)
+		if err != nil {
+			return err
+		}
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("'%s' is not an integer", s)
+		}
+		field.SetInt(int64(i))
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("cannot be overridden")
+		}
+		list, err := stringList(value)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("cannot be overridden")
+	}
+	return nil
+}
+
+// scalarString formats a single value, joining lists with commas the way drone does.
+func scalarString(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(value), nil
+	case []interface{}:
+		list, err := stringList(value)
+		if err != nil {
+			return "", err
+		}
+		return strings.Join(list, ","), nil
+	default:
+		return "", fmt.Errorf("expected a string, got %T", value)
+	}
+}
+
+func stringList(value interface{}) ([]string, error) {
+	switch value := value.(type) {
+	case nil:
+		return []string{}, nil
+	case []interface{}:
+		list := make([]string, 0, len(value))
+		for _, item := range value {
+			s, err := scalarString(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	default:
+		s, err := scalarString(value)
+		if err != nil {
+			return nil, err
+		}
+		return strings.Split(s, ","), nil
+	}
+}
@@ -0,0 +1,75 @@
+package env
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type SettingsTestSuite struct {
+	suite.Suite
+}
+
+func TestSettingsTestSuite(t *testing.T) {
+	suite.Run(t, new(SettingsTestSuite))
+}
+
+func (suite *SettingsTestSuite) TestSettingName() {
+	t := reflect.TypeOf(Config{})
+	names := map[string]string{
+		"Command":           "mode",
+		"AddRepos":          "add_repos",
+		"RepoCACertificate": "repo_ca_certificate",
+		"Debug":             "debug",
+		"SkipTLSVerify":     "skip_tls_verify",
+		"HistoryMax":        "history_max",
+	}
+	for fieldName, want := range names {
+		field, ok := t.FieldByName(fieldName)
+		suite.Require().True(ok, fieldName)
+		suite.Equal(want, settingName(field), fieldName)
+	}
+}
+
+func (suite *SettingsTestSuite) TestSettingFieldsOmitsNonSettings() {
+	fields := settingFields()
+	suite.Contains(fields, "namespace")
+	suite.NotContains(fields, "stdout")
+	suite.NotContains(fields, "stderr")
+	suite.NotContains(fields, "drone_build_event")
+	suite.NotContains(fields, "drone_branch")
+}
+
+func (suite *SettingsTestSuite) TestApplySettings() {
+	cfg := Config{Release: "unchanged"}
+	err := cfg.applySettings(map[string]interface{}{
+		"namespace":        "rivendell",
+		"values":           []interface{}{"elves=3", "dwarves=1"},
+		"values_files":     "./a.yml,./b.yml",
+		"dry_run":          "true",
+		"wait_for_upgrade": true,
+		"history_max":      3,
+	})
+	suite.Require().NoError(err)
+
+	suite.Equal("rivendell", cfg.Namespace)
+	suite.Equal("elves=3,dwarves=1", cfg.Values)
+	suite.Equal([]string{"./a.yml", "./b.yml"}, cfg.ValuesFiles)
+	suite.True(cfg.DryRun)
+	suite.True(cfg.Wait)
+	suite.Equal(3, cfg.HistoryMax)
+	suite.Equal("unchanged", cfg.Release)
+}
+
+func (suite *SettingsTestSuite) TestApplySettingsErrors() {
+	cfg := Config{}
+	suite.EqualError(cfg.applySettings(map[string]interface{}{"fellowship": 9}), "unknown setting 'fellowship'")
+	suite.EqualError(cfg.applySettings(map[string]interface{}{"dry_run": "perhaps"}),
+		"invalid value for 'dry_run': 'perhaps' is not a boolean")
+	suite.EqualError(cfg.applySettings(map[string]interface{}{"history_max": "many"}),
+		"invalid value for 'history_max': 'many' is not an integer")
+	suite.EqualError(cfg.applySettings(map[string]interface{}{"namespace": map[interface{}]interface{}{}}),
+		"invalid value for 'namespace': expected a string, got map[interface {}]interface {}")
+	suite.EqualError(cfg.applySettings(map[string]interface{}{"mode": "upgrade"}, "mode"), "unknown setting 'mode'")
+}
@@ -0,0 +1,94 @@
+package env
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// SmokeTests lists the URLs to check once a release has been deployed.
+type SmokeTests []SmokeTest
+
+// A SmokeTest is a URL that should respond with the given status, and optionally a body that matches a regular
+// expression or a JSON document with a value at a path. If no status is given, it's 200.
+type SmokeTest struct {
+	URL       string
+	Status    int
+	Body      string // regular expression that the body must match
+	JSONPath  string // dot-separated path to a value in the JSON body, such as "checks.0.status"
+	JSONValue string // what the value at JSONPath must be; if empty, it only has to exist
+}
+
+// Decode parses the smoke_tests setting: a YAML or JSON list whose entries are URLs or objects with a url and the
+// checks to make, or a comma-separated list of URLs.
+func (s *SmokeTests) Decode(value string) error {
+	var raw interface{}
+	if err := yaml.Unmarshal([]byte(value), &raw); err != nil {
+		return fmt.Errorf("could not parse smoke_tests: %w", err)
+	}
+	var entries []interface{}
+	switch raw := raw.(type) {
+	case nil:
+	case string:
+		for _, url := range strings.Split(raw, ",") {
+			entries = append(entries, strings.TrimSpace(url))
+		}
+	case []interface{}:
+		entries = raw
+	default:
+		return fmt.Errorf("could not parse smoke_tests: expected a list, got %T", raw)
+	}
+
+	tests := make(SmokeTests, 0, len(entries))
+	for i, entry := range entries {
+		test, err := decodeSmokeTest(entry)
+		if err != nil {
+			return fmt.Errorf("smoke_tests[%d]: %w", i, err)
+		}
+		tests = append(tests, test)
+	}
+	*s = tests
+	return nil
+}
+
+func decodeSmokeTest(entry interface{}) (SmokeTest, error) {
+	var test SmokeTest
+	if url, ok := entry.(string); ok {
+		test.URL = url
+	} else {
+		// round-trip the entry so that unknown keys are caught
+		raw, err := yaml.Marshal(entry)
+		if err != nil {
+			return test, err
+		}
+		var fields struct {
+			URL       string `yaml:"url"`
+			Status    int    `yaml:"status"`
+			Body      string `yaml:"body"`
+			JSONPath  string `yaml:"json_path"`
+			JSONValue string `yaml:"json_value"`
+		}
+		if err := yaml.UnmarshalStrict(raw, &fields); err != nil {
+			return test, err
+		}
+		test = SmokeTest{URL: fields.URL, Status: fields.Status, Body: fields.Body, JSONPath: fields.JSONPath,
+			JSONValue: fields.JSONValue}
+	}
+
+	if test.URL == "" {
+		return test, errors.New("no url given")
+	}
+	if test.JSONValue != "" && test.JSONPath == "" {
+		return test, errors.New("json_value needs a json_path")
+	}
+	switch {
+	case test.Status == 0:
+		test.Status = http.StatusOK
+	case test.Status < 100 || test.Status > 599:
+		return test, fmt.Errorf("status %d isn't an HTTP status code", test.Status)
+	}
+	return test, nil
+}
@@ -0,0 +1,61 @@
+package env
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type SmokeTestsTestSuite struct {
+	suite.Suite
+}
+
+func TestSmokeTestsTestSuite(t *testing.T) {
+	suite.Run(t, new(SmokeTestsTestSuite))
+}
+
+func (suite *SmokeTestsTestSuite) TestDecode() {
+	var tests SmokeTests
+	err := tests.Decode(`
+- https://edoras.example.com/
+- url: https://edoras.example.com/healthz
+  status: 204
+- url: https://edoras.example.com/version
+  body: 'v2\.\d+'
+  json_path: build.commit
+  json_value: a1b2c3d
+`)
+	suite.Require().NoError(err)
+	suite.Equal(SmokeTests{
+		{URL: "https://edoras.example.com/", Status: 200},
+		{URL: "https://edoras.example.com/healthz", Status: 204},
+		{URL: "https://edoras.example.com/version", Status: 200, Body: `v2\.\d+`, JSONPath: "build.commit",
+			JSONValue: "a1b2c3d"},
+	}, tests)
+}
+
+func (suite *SmokeTestsTestSuite) TestDecodeJSON() {
+	var tests SmokeTests
+	suite.Require().NoError(tests.Decode(`[{"url": "https://edoras.example.com/ready", "json_path": "ready"}]`))
+	suite.Equal(SmokeTests{{URL: "https://edoras.example.com/ready", Status: 200, JSONPath: "ready"}}, tests)
+}
+
+func (suite *SmokeTestsTestSuite) TestDecodeCommaSeparated() {
+	var tests SmokeTests
+	suite.Require().NoError(tests.Decode("https://edoras.example.com, https://hornburg.example.com"))
+	suite.Equal(SmokeTests{
+		{URL: "https://edoras.example.com", Status: 200},
+		{URL: "https://hornburg.example.com", Status: 200},
+	}, tests)
+}
+
+func (suite *SmokeTestsTestSuite) TestDecodeErrors() {
+	var tests SmokeTests
+	suite.EqualError(tests.Decode(`[{status: 200}]`), "smoke_tests[0]: no url given")
+	suite.EqualError(tests.Decode(`[https://a.example.com, {url: https://b.example.com, json_value: ok}]`),
+		"smoke_tests[1]: json_value needs a json_path")
+	suite.EqualError(tests.Decode(`[{url: https://a.example.com, status: 2000}]`),
+		"smoke_tests[0]: status 2000 isn't an HTTP status code")
+	suite.Error(tests.Decode(`[{url: https://a.example.com, method: POST}]`), "unknown keys should be rejected")
+	suite.Error(tests.Decode(`url: https://a.example.com`), "a single object isn't a list")
+}
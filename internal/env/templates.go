@@ -0,0 +1,122 @@
+package env
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/pelotech/drone-helm3/internal/logging"
+)
+
+var (
+	nonDNSCharacters = regexp.MustCompile(`[^a-z0-9]+`)
+
+	templateFuncs = template.FuncMap{
+		"sanitize": SanitizeName,
+		"trunc":    TruncateName,
+	}
+)
+
+// templateData holds the drone build variables that are available to templated settings.
+type templateData struct {
+	PullRequest string
+	Branch      string
+	CommitSHA   string
+	Tag         string
+}
+
+type templatedSetting struct {
+	name  string
+	value *string
+}
+
+// renderTemplates expands text/template expressions in the settings that support them.
+func (cfg *Config) renderTemplates() error {
+	data := templateData{
+		PullRequest: cfg.DronePullRequest,
+		Branch:      cfg.DroneBranch,
+		CommitSHA:   cfg.DroneCommitSHA,
+		Tag:         cfg.DroneTag,
+	}
+
+	settings := []templatedSetting{
+		{"release", &cfg.Release},
+		{"namespace", &cfg.Namespace},
+		{"values", &cfg.Values},
+		{"string_values", &cfg.StringValues},
+		{"environment_url", &cfg.EnvironmentURL},
+	}
+	for i := range cfg.Releases {
+		release := &cfg.Releases[i]
+		prefix := fmt.Sprintf("releases[%d].", i)
+		settings = append(settings,
+			templatedSetting{prefix + "release", &release.Release},
+			templatedSetting{prefix + "namespace", &release.Namespace},
+			templatedSetting{prefix + "values", &release.Values},
+			templatedSetting{prefix + "string_values", &release.StringValues},
+		)
+		for j := range release.Needs {
+			settings = append(settings, templatedSetting{prefix + "needs", &release.Needs[j]})
+		}
+		settings = append(settings, smokeTestSettings(prefix, release.SmokeTests)...)
+	}
+	settings = append(settings, smokeTestSettings("", cfg.SmokeTests)...)
+	for _, setting := range settings {
+		rendered, err := render(setting.name, *setting.value, data)
+		if err != nil {
+			return err
+		}
+		if cfg.Debug && rendered != *setting.value {
+			logging.Debugf(cfg.Stderr, "rendered %s template as \"%s\"", setting.name, rendered)
+		}
+		*setting.value = rendered
+	}
+	return nil
+}
+
+// smokeTestSettings lists the templated parts of smoke tests: their URLs, which may be a preview environment's, and
+// expected JSON values, which may be the deployed commit.
+func smokeTestSettings(prefix string, tests SmokeTests) []templatedSetting {
+	var settings []templatedSetting
+	for i := range tests {
+		name := fmt.Sprintf("%ssmoke_tests[%d].", prefix, i)
+		settings = append(settings,
+			templatedSetting{name + "url", &tests[i].URL},
+			templatedSetting{name + "json_value", &tests[i].JSONValue},
+		)
+	}
+	return settings
+}
+
+func render(name, text string, data templateData) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("could not parse %s template: %w", name, err)
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("could not render %s template: %w", name, err)
+	}
+	return out.String(), nil
+}
+
+// SanitizeName converts a string to a valid DNS-1123 label by lowercasing it and replacing runs of any other
+// characters with hyphens.
+func SanitizeName(name string) string {
+	name = nonDNSCharacters.ReplaceAllString(strings.ToLower(name), "-")
+	return strings.Trim(name, "-")
+}
+
+// TruncateName shortens a name to at most length characters without leaving a trailing hyphen. Helm release names
+// are limited to 53 characters.
+func TruncateName(length int, name string) string {
+	if length >= 0 && len(name) > length {
+		name = name[:length]
+	}
+	return strings.TrimRight(name, "-")
+}
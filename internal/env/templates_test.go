@@ -0,0 +1,86 @@
+package env
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type TemplatesTestSuite struct {
+	suite.Suite
+}
+
+func TestTemplatesTestSuite(t *testing.T) {
+	suite.Run(t, new(TemplatesTestSuite))
+}
+
+func (suite *TemplatesTestSuite) TestRenderTemplates() {
+	cfg := Config{
+		Release:          "myapp-pr-{{ .PullRequest }}",
+		Namespace:        "{{ .Branch | sanitize | trunc 20 }}",
+		Values:           "image.tag={{ .CommitSHA }},version={{ .Tag }}",
+		StringValues:     "plain=old",
+		EnvironmentURL:   "https://pr-{{ .PullRequest }}.preview.example.com",
+		DronePullRequest: "123",
+		DroneBranch:      "Feature/JIRA-42_Make-It-So",
+		DroneCommitSHA:   "8badf00d",
+		DroneTag:         "v1.2.3",
+	}
+
+	suite.Require().NoError(cfg.renderTemplates())
+	suite.Equal("myapp-pr-123", cfg.Release)
+	suite.Equal("feature-jira-42-make", cfg.Namespace)
+	suite.Equal("image.tag=8badf00d,version=v1.2.3", cfg.Values)
+	suite.Equal("plain=old", cfg.StringValues)
+	suite.Equal("https://pr-123.preview.example.com", cfg.EnvironmentURL)
+}
+
+func (suite *TemplatesTestSuite) TestRenderSmokeTestTemplates() {
+	cfg := Config{
+		SmokeTests: SmokeTests{{URL: "https://pr-{{ .PullRequest }}.example.com/version", JSONPath: "commit",
+			JSONValue: "{{ .CommitSHA }}"}},
+		Releases: Releases{{Release: "api", SmokeTests: SmokeTests{
+			{URL: "https://api-pr-{{ .PullRequest }}.example.com"},
+		}}},
+		DronePullRequest: "123",
+		DroneCommitSHA:   "8badf00d",
+	}
+
+	suite.Require().NoError(cfg.renderTemplates())
+	suite.Equal(SmokeTest{URL: "https://pr-123.example.com/version", JSONPath: "commit", JSONValue: "8badf00d"},
+		cfg.SmokeTests[0])
+	suite.Equal("https://api-pr-123.example.com", cfg.Releases[0].SmokeTests[0].URL)
+}
+
+func (suite *TemplatesTestSuite) TestRenderTemplatesErrors() {
+	cfg := Config{Release: "{{ .PullRequest "}
+	err := cfg.renderTemplates()
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "could not parse release template")
+
+	cfg = Config{Namespace: "{{ .Nonexistent }}"}
+	err = cfg.renderTemplates()
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "could not render namespace template")
+}
+
+func (suite *TemplatesTestSuite) TestRenderTemplatesDebugOutput() {
+	stderr := strings.Builder{}
+	cfg := Config{Release: "myapp-{{ .Tag }}", DroneTag: "v2", Debug: true, Stderr: &stderr}
+
+	suite.Require().NoError(cfg.renderTemplates())
+	suite.Equal("rendered release template as \"myapp-v2\"\n", stderr.String())
+}
+
+func (suite *TemplatesTestSuite) TestSanitizeName() {
+	suite.Equal("feature-add-thing", SanitizeName("feature/add_thing"))
+	suite.Equal("release-1-2", SanitizeName("--Release 1.2--"))
+	suite.Equal("", SanitizeName("!!!"))
+}
+
+func (suite *TemplatesTestSuite) TestTruncateName() {
+	suite.Equal("abc", TruncateName(53, "abc"))
+	suite.Equal("abcd", TruncateName(5, "abcd-efgh"), "trailing hyphens should be removed")
+	suite.Equal(strings.Repeat("a", 53), TruncateName(53, strings.Repeat("a", 60)))
+}
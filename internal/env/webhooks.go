@@ -0,0 +1,96 @@
+package env
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Payload presets for webhook notifications.
+const (
+	PresetJSON  = "json"
+	PresetSlack = "slack"
+	PresetTeams = "teams"
+)
+
+// Webhooks lists the URLs to notify once the plan has been executed.
+type Webhooks []Webhook
+
+// A Webhook is a URL to notify, and the body to send it. The body is either one of the presets or rendered from a Go
+// template; if neither is given, it's the JSON preset.
+type Webhook struct {
+	URL      string
+	Preset   string
+	Template string
+}
+
+// Decode parses the notify_webhooks setting: a YAML or JSON list whose entries are URLs or objects with a url and
+// either a preset or a template, or a comma-separated list of URLs.
+func (w *Webhooks) Decode(value string) error {
+	var raw interface{}
+	if err := yaml.Unmarshal([]byte(value), &raw); err != nil {
+		return fmt.Errorf("could not parse notify_webhooks: %w", err)
+	}
+	var entries []interface{}
+	switch raw := raw.(type) {
+	case nil:
+	case string:
+		for _, url := range strings.Split(raw, ",") {
+			entries = append(entries, strings.TrimSpace(url))
+		}
+	case []interface{}:
+		entries = raw
+	default:
+		return fmt.Errorf("could not parse notify_webhooks: expected a list, got %T", raw)
+	}
+
+	webhooks := make(Webhooks, 0, len(entries))
+	for i, entry := range entries {
+		webhook, err := decodeWebhook(entry)
+		if err != nil {
+			return fmt.Errorf("notify_webhooks[%d]: %w", i, err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	*w = webhooks
+	return nil
+}
+
+func decodeWebhook(entry interface{}) (Webhook, error) {
+	var webhook Webhook
+	if url, ok := entry.(string); ok {
+		webhook.URL = url
+	} else {
+		// round-trip the entry so that unknown keys are caught
+		raw, err := yaml.Marshal(entry)
+		if err != nil {
+			return webhook, err
+		}
+		var fields struct {
+			URL      string `yaml:"url"`
+			Preset   string `yaml:"preset"`
+			Template string `yaml:"template"`
+		}
+		if err := yaml.UnmarshalStrict(raw, &fields); err != nil {
+			return webhook, err
+		}
+		webhook = Webhook{URL: fields.URL, Preset: fields.Preset, Template: fields.Template}
+	}
+
+	if webhook.URL == "" {
+		return webhook, errors.New("no url given")
+	}
+	switch {
+	case webhook.Preset != "" && webhook.Template != "":
+		return webhook, errors.New("give a preset or a template, not both")
+	case webhook.Template != "":
+	case webhook.Preset == "":
+		webhook.Preset = PresetJSON
+	case webhook.Preset != PresetJSON && webhook.Preset != PresetSlack && webhook.Preset != PresetTeams:
+		return webhook, fmt.Errorf("unknown preset '%s' (expected '%s', '%s' or '%s')", webhook.Preset, PresetJSON,
+			PresetSlack, PresetTeams)
+	}
+	return webhook, nil
+}
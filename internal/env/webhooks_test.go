@@ -0,0 +1,70 @@
+package env
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type WebhooksTestSuite struct {
+	suite.Suite
+}
+
+func TestWebhooksTestSuite(t *testing.T) {
+	suite.Run(t, new(WebhooksTestSuite))
+}
+
+func (suite *WebhooksTestSuite) TestDecode() {
+	var webhooks Webhooks
+	err := webhooks.Decode(`
+- https://hooks.example.com/deploys
+- url: https://hooks.slack.com/services/T0/B0/x
+  preset: slack
+- url: https://chat.example.com/hook
+  template: '{"message": {{ json .Status }}}'
+`)
+	suite.Require().NoError(err)
+	suite.Equal(Webhooks{
+		{URL: "https://hooks.example.com/deploys", Preset: PresetJSON},
+		{URL: "https://hooks.slack.com/services/T0/B0/x", Preset: PresetSlack},
+		{URL: "https://chat.example.com/hook", Template: `{"message": {{ json .Status }}}`},
+	}, webhooks)
+}
+
+func (suite *WebhooksTestSuite) TestDecodeJSON() {
+	var webhooks Webhooks
+	err := webhooks.Decode(`[{"url": "https://outlook.example.com/webhook", "preset": "teams"}]`)
+	suite.Require().NoError(err)
+	suite.Equal(Webhooks{{URL: "https://outlook.example.com/webhook", Preset: PresetTeams}}, webhooks)
+}
+
+func (suite *WebhooksTestSuite) TestDecodeCommaSeparated() {
+	var webhooks Webhooks
+	suite.Require().NoError(webhooks.Decode("https://one.example.com, https://two.example.com"))
+	suite.Equal(Webhooks{
+		{URL: "https://one.example.com", Preset: PresetJSON},
+		{URL: "https://two.example.com", Preset: PresetJSON},
+	}, webhooks)
+}
+
+func (suite *WebhooksTestSuite) TestDecodeErrors() {
+	var webhooks Webhooks
+	suite.EqualError(webhooks.Decode(`[{preset: slack}]`), "notify_webhooks[0]: no url given")
+	suite.EqualError(webhooks.Decode(`[https://a.example.com, {url: https://b.example.com, preset: discord}]`),
+		"notify_webhooks[1]: unknown preset 'discord' (expected 'json', 'slack' or 'teams')")
+	suite.EqualError(webhooks.Decode(`[{url: https://a.example.com, preset: slack, template: "{{ .Status }}"}]`),
+		"notify_webhooks[0]: give a preset or a template, not both")
+	suite.Error(webhooks.Decode(`[{url: https://a.example.com, method: PUT}]`), "unknown keys should be rejected")
+	suite.Error(webhooks.Decode(`url: https://a.example.com`), "a single object isn't a list")
+}
+
+func (suite *WebhooksTestSuite) TestRedactedInDebugOutput() {
+	cfg := Config{NotifyWebhooks: Webhooks{{URL: "https://hooks.slack.com/services/T0/B0/secret", Preset: PresetSlack}}}
+	stderr := &strings.Builder{}
+	cfg.Stderr = stderr
+	cfg.logDebug()
+	suite.NotContains(stderr.String(), "secret")
+	suite.Equal("https://hooks.slack.com/services/T0/B0/secret", cfg.NotifyWebhooks[0].URL,
+		"the Config itself should be left alone")
+}
@@ -0,0 +1,67 @@
+package forge
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// commentPageSize is as many comments as GitHub will list at once. Gitea's limit may be lower, so pages are read
+// until one comes back empty, rather than until one comes back short.
+const commentPageSize = 100
+
+// maxCommentPages stops a pull request with an enormous discussion from being read forever.
+const maxCommentPages = 50
+
+type comment struct {
+	ID   int64  `json:"id"`
+	Body string `json:"body"`
+}
+
+// UpsertComment makes sure that a pull request has a single comment, identified by the marker, with the given body:
+// the first comment containing the marker is updated if there is one, or a new comment is created. The body should
+// contain the marker, so that the comment can be found again.
+func (c *Client) UpsertComment(pullRequest int, marker, body string) error {
+	existing, err := c.findComment(pullRequest, marker)
+	if err != nil {
+		return fmt.Errorf("could not list comments: %w", err)
+	}
+
+	payload := struct {
+		Body string `json:"body"`
+	}{body}
+	if existing != 0 {
+		err = c.do(http.MethodPatch, fmt.Sprintf("/repos/%s/issues/comments/%d", c.repo, existing), payload, nil)
+		if err != nil {
+			return fmt.Errorf("could not update comment: %w", err)
+		}
+		return nil
+	}
+	if err := c.post(fmt.Sprintf("/repos/%s/issues/%d/comments", c.repo, pullRequest), payload, nil); err != nil {
+		return fmt.Errorf("could not create comment: %w", err)
+	}
+	return nil
+}
+
+// findComment returns the ID of the first comment on the pull request that contains the marker, or 0 if there isn't
+// one.
+func (c *Client) findComment(pullRequest int, marker string) (int64, error) {
+	for page := 1; page <= maxCommentPages; page++ {
+		var comments []comment
+		// per_page is GitHub's name for the page size, and limit is Gitea's
+		path := fmt.Sprintf("/repos/%s/issues/%d/comments?per_page=%d&limit=%d&page=%d", c.repo, pullRequest,
+			commentPageSize, commentPageSize, page)
+		if err := c.do(http.MethodGet, path, nil, &comments); err != nil {
+			return 0, err
+		}
+		if len(comments) == 0 {
+			return 0, nil
+		}
+		for _, comment := range comments {
+			if strings.Contains(comment.Body, marker) {
+				return comment.ID, nil
+			}
+		}
+	}
+	return 0, nil
+}
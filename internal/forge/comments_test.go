@@ -0,0 +1,96 @@
+package forge
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CommentsTestSuite struct {
+	suite.Suite
+	server   *httptest.Server
+	pages    [][]comment
+	requests []string
+	bodies   []string
+	status   int
+}
+
+func (suite *CommentsTestSuite) BeforeTest(_, _ string) {
+	suite.pages, suite.requests, suite.bodies, suite.status = nil, nil, nil, http.StatusOK
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.requests = append(suite.requests, r.Method+" "+r.URL.RequestURI())
+		suite.Equal("Bearer ghp_mellon", r.Header.Get("Authorization"))
+		if r.Method == http.MethodGet {
+			var page int
+			fmt.Sscan(r.URL.Query().Get("page"), &page)
+			var comments []comment
+			if page <= len(suite.pages) {
+				comments = suite.pages[page-1]
+			}
+			if comments == nil {
+				comments = []comment{}
+			}
+			json.NewEncoder(w).Encode(comments)
+			return
+		}
+
+		var body struct{ Body string }
+		suite.Require().NoError(json.NewDecoder(r.Body).Decode(&body))
+		suite.bodies = append(suite.bodies, body.Body)
+		w.WriteHeader(suite.status)
+		w.Write([]byte(`{"id": 1}`))
+	}))
+}
+
+func (suite *CommentsTestSuite) AfterTest(_, _ string) {
+	suite.server.Close()
+}
+
+func TestCommentsTestSuite(t *testing.T) {
+	suite.Run(t, new(CommentsTestSuite))
+}
+
+func (suite *CommentsTestSuite) TestCreatesComment() {
+	suite.pages = [][]comment{{{ID: 3, Body: "looks good to me"}}}
+	client := NewClient(suite.server.URL, "elrond/council", "ghp_mellon")
+
+	suite.Require().NoError(client.UpsertComment(7, "<!-- ring -->", "<!-- ring -->\nOne does not simply"))
+	suite.Equal([]string{
+		"GET /repos/elrond/council/issues/7/comments?per_page=100&limit=100&page=1",
+		"GET /repos/elrond/council/issues/7/comments?per_page=100&limit=100&page=2",
+		"POST /repos/elrond/council/issues/7/comments",
+	}, suite.requests)
+	suite.Equal([]string{"<!-- ring -->\nOne does not simply"}, suite.bodies)
+}
+
+func (suite *CommentsTestSuite) TestUpdatesComment() {
+	suite.pages = [][]comment{
+		{{ID: 3, Body: "looks good to me"}},
+		{{ID: 5, Body: "<!-- ring -->\nThe old diff"}, {ID: 8, Body: "<!-- ring -->\nA copy"}},
+	}
+	client := NewClient(suite.server.URL, "elrond/council", "ghp_mellon")
+
+	suite.Require().NoError(client.UpsertComment(7, "<!-- ring -->", "<!-- ring -->\nThe new diff"))
+	suite.Equal("PATCH /repos/elrond/council/issues/comments/5", suite.requests[len(suite.requests)-1])
+	suite.Equal([]string{"<!-- ring -->\nThe new diff"}, suite.bodies)
+}
+
+func (suite *CommentsTestSuite) TestErrors() {
+	suite.status = http.StatusForbidden
+	client := NewClient(suite.server.URL, "elrond/council", "ghp_mellon")
+	err := client.UpsertComment(7, "<!-- ring -->", "<!-- ring -->")
+	suite.EqualError(err, `could not create comment: forge responded 403 Forbidden: {"id": 1}`)
+
+	suite.pages = [][]comment{{{ID: 5, Body: "<!-- ring -->"}}}
+	err = client.UpsertComment(7, "<!-- ring -->", "<!-- ring -->")
+	suite.EqualError(err, `could not update comment: forge responded 403 Forbidden: {"id": 1}`)
+
+	suite.server.Close()
+	err = client.UpsertComment(7, "<!-- ring -->", "<!-- ring -->")
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "could not list comments: ")
+}
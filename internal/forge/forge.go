@@ -0,0 +1,170 @@
+// Package forge reports deployments to a Git forge through the GitHub deployments API, so that pull requests and
+// commits show what has been deployed where, and comments on pull requests through the GitHub or Gitea issues API.
+package forge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultAPIURL is GitHub's API. GitHub Enterprise Server's is at /api/v3 on the server.
+const DefaultAPIURL = "https://api.github.com"
+
+// IsGitea tells whether the API at the given base URL is Gitea's, which is served from /api/v1 rather than GitHub's
+// root or /api/v3.
+func IsGitea(apiURL string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(apiURL, "/"), "/api/v1")
+}
+
+// Deployment states.
+const (
+	StateInProgress = "in_progress"
+	StateSuccess    = "success"
+	StateFailure    = "failure"
+	StateInactive   = "inactive"
+)
+
+var requestTimeout = 10 * time.Second
+
+// A Client creates deployments and deployment statuses in a repository.
+type Client struct {
+	url   string
+	repo  string
+	token string
+	http  *http.Client
+}
+
+// NewClient creates a Client for the repository, given as owner/name, using the API at the given base URL.
+func NewClient(apiURL, repo, token string) *Client {
+	if apiURL == "" {
+		apiURL = DefaultAPIURL
+	}
+	return &Client{
+		url:   strings.TrimSuffix(apiURL, "/"),
+		repo:  repo,
+		token: token,
+		http:  &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// A Deployment is a request to deploy a ref to an environment.
+type Deployment struct {
+	Ref         string
+	Environment string
+	Description string
+	Transient   bool // the environment will go away, as a pull request's preview environment does
+}
+
+// A Status is the state of a deployment at some point.
+type Status struct {
+	State          string
+	EnvironmentURL string
+	LogURL         string
+	Description    string
+}
+
+// CreateDeployment records a deployment, returning its ID.
+func (c *Client) CreateDeployment(d Deployment) (int64, error) {
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	err := c.post(fmt.Sprintf("/repos/%s/deployments", c.repo), struct {
+		Ref                  string   `json:"ref"`
+		Environment          string   `json:"environment"`
+		Description          string   `json:"description,omitempty"`
+		AutoMerge            bool     `json:"auto_merge"`
+		RequiredContexts     []string `json:"required_contexts"`
+		TransientEnvironment bool     `json:"transient_environment"`
+	}{d.Ref, d.Environment, d.Description, false, []string{}, d.Transient}, &created)
+	if err != nil {
+		return 0, fmt.Errorf("could not create deployment: %w", err)
+	}
+	return created.ID, nil
+}
+
+// LatestDeployment returns the ID of the most recent deployment to the environment, or 0 if there hasn't been one.
+func (c *Client) LatestDeployment(environment string) (int64, error) {
+	var deployments []struct {
+		ID int64 `json:"id"`
+	}
+	// deployments are listed newest first
+	path := fmt.Sprintf("/repos/%s/deployments?environment=%s&per_page=1", c.repo, url.QueryEscape(environment))
+	if err := c.do(http.MethodGet, path, nil, &deployments); err != nil {
+		return 0, fmt.Errorf("could not list deployments: %w", err)
+	}
+	if len(deployments) == 0 {
+		return 0, nil
+	}
+	return deployments[0].ID, nil
+}
+
+// CreateStatus adds a status to a deployment.
+func (c *Client) CreateStatus(deployment int64, s Status) error {
+	err := c.post(fmt.Sprintf("/repos/%s/deployments/%d/statuses", c.repo, deployment), struct {
+		State          string `json:"state"`
+		EnvironmentURL string `json:"environment_url,omitempty"`
+		LogURL         string `json:"log_url,omitempty"`
+		Description    string `json:"description,omitempty"`
+	}{s.State, s.EnvironmentURL, s.LogURL, truncate(s.Description, 140)}, nil)
+	if err != nil {
+		return fmt.Errorf("could not set deployment status to %s: %w", s.State, err)
+	}
+	return nil
+}
+
+func (c *Client) post(path string, body, result interface{}) error {
+	return c.do(http.MethodPost, path, body, result)
+}
+
+// do sends a request to the API, with the body encoded as JSON unless it's nil, and decodes the response into result
+// unless that's nil.
+func (c *Client) do(method, path string, body, result interface{}) error {
+	var encoded io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		encoded = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.url+path, encoded)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("forge responded %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("could not read the forge's response: %w", err)
+	}
+	return nil
+}
+
+// truncate shortens a description to GitHub's limit, which is counted in characters, without splitting any of them.
+func truncate(s string, length int) string {
+	if utf8.RuneCountInString(s) <= length {
+		return s
+	}
+	return string([]rune(s)[:length-3]) + "..."
+}
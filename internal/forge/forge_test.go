@@ -0,0 +1,143 @@
+package forge
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ForgeTestSuite struct {
+	suite.Suite
+	server   *httptest.Server
+	requests []*http.Request
+	bodies   []map[string]interface{}
+	status   int
+	response string
+}
+
+func (suite *ForgeTestSuite) BeforeTest(_, _ string) {
+	suite.requests, suite.bodies = nil, nil
+	suite.status, suite.response = http.StatusCreated, `{"id": 1701}`
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		if r.Method != http.MethodGet {
+			suite.Require().NoError(json.NewDecoder(r.Body).Decode(&body))
+		}
+		suite.requests = append(suite.requests, r)
+		suite.bodies = append(suite.bodies, body)
+		w.WriteHeader(suite.status)
+		w.Write([]byte(suite.response))
+	}))
+}
+
+func (suite *ForgeTestSuite) AfterTest(_, _ string) {
+	suite.server.Close()
+}
+
+func TestForgeTestSuite(t *testing.T) {
+	suite.Run(t, new(ForgeTestSuite))
+}
+
+func (suite *ForgeTestSuite) TestCreateDeployment() {
+	client := NewClient(suite.server.URL+"/", "elrond/council", "ghp_mellon")
+	id, err := client.CreateDeployment(Deployment{
+		Ref:         "a1b2c3d",
+		Environment: "rivendell",
+		Description: "Deploying imladris",
+		Transient:   true,
+	})
+	suite.Require().NoError(err)
+	suite.Equal(int64(1701), id)
+
+	req := suite.requests[0]
+	suite.Equal(http.MethodPost, req.Method)
+	suite.Equal("/repos/elrond/council/deployments", req.URL.Path)
+	suite.Equal("Bearer ghp_mellon", req.Header.Get("Authorization"))
+	suite.Equal("application/vnd.github+json", req.Header.Get("Accept"))
+	suite.Equal(map[string]interface{}{
+		"ref":                   "a1b2c3d",
+		"environment":           "rivendell",
+		"description":           "Deploying imladris",
+		"auto_merge":            false,
+		"required_contexts":     []interface{}{},
+		"transient_environment": true,
+	}, suite.bodies[0])
+}
+
+func (suite *ForgeTestSuite) TestCreateStatus() {
+	client := NewClient(suite.server.URL, "elrond/council", "ghp_mellon")
+	err := client.CreateStatus(1701, Status{
+		State:          StateFailure,
+		EnvironmentURL: "https://rivendell.example.com",
+		LogURL:         "https://drone.example.com/elrond/council/7",
+		Description:    strings.Repeat("the ring must be destroyed ", 10),
+	})
+	suite.Require().NoError(err)
+
+	suite.Equal("/repos/elrond/council/deployments/1701/statuses", suite.requests[0].URL.Path)
+	suite.Equal("failure", suite.bodies[0]["state"])
+	suite.Equal("https://rivendell.example.com", suite.bodies[0]["environment_url"])
+	suite.Equal("https://drone.example.com/elrond/council/7", suite.bodies[0]["log_url"])
+	suite.Len(suite.bodies[0]["description"], 140, "descriptions should be cut to GitHub's limit")
+	suite.True(strings.HasSuffix(suite.bodies[0]["description"].(string), "..."))
+}
+
+func (suite *ForgeTestSuite) TestTruncate() {
+	suite.Equal("short", truncate("short", 140))
+	long := strings.Repeat("ring ", 20) + strings.Repeat("💍", 50)
+	truncated := truncate(long, 140)
+	suite.True(utf8.ValidString(truncated), "characters shouldn't be split")
+	suite.Equal(140, utf8.RuneCountInString(truncated))
+	suite.True(strings.HasSuffix(truncated, "💍..."))
+}
+
+func (suite *ForgeTestSuite) TestLatestDeployment() {
+	suite.status, suite.response = http.StatusOK, `[{"id": 1701}]`
+	client := NewClient(suite.server.URL, "elrond/council", "ghp_mellon")
+	id, err := client.LatestDeployment("rivendell-pr-7")
+	suite.Require().NoError(err)
+	suite.Equal(int64(1701), id)
+
+	req := suite.requests[0]
+	suite.Equal(http.MethodGet, req.Method)
+	suite.Equal("/repos/elrond/council/deployments", req.URL.Path)
+	suite.Equal("rivendell-pr-7", req.URL.Query().Get("environment"))
+	suite.Equal("1", req.URL.Query().Get("per_page"))
+
+	suite.response = `[]`
+	id, err = client.LatestDeployment("mordor")
+	suite.Require().NoError(err)
+	suite.Equal(int64(0), id, "an environment that was never deployed to has no deployment")
+}
+
+func (suite *ForgeTestSuite) TestErrors() {
+	suite.status, suite.response = http.StatusNotFound, `{"message": "Not Found"}`
+	client := NewClient(suite.server.URL, "elrond/council", "ghp_mellon")
+
+	_, err := client.CreateDeployment(Deployment{Ref: "a1b2c3d"})
+	suite.EqualError(err, `could not create deployment: forge responded 404 Not Found: {"message": "Not Found"}`)
+
+	_, err = client.LatestDeployment("rivendell")
+	suite.EqualError(err, `could not list deployments: forge responded 404 Not Found: {"message": "Not Found"}`)
+
+	err = client.CreateStatus(1701, Status{State: StateSuccess})
+	suite.EqualError(err,
+		`could not set deployment status to success: forge responded 404 Not Found: {"message": "Not Found"}`)
+}
+
+func (suite *ForgeTestSuite) TestIsGitea() {
+	suite.False(IsGitea(""))
+	suite.False(IsGitea("https://api.github.com"))
+	suite.False(IsGitea("https://github.example.com/api/v3"))
+	suite.True(IsGitea("https://gitea.example.com/api/v1"))
+	suite.True(IsGitea("https://gitea.example.com/api/v1/"))
+}
+
+func (suite *ForgeTestSuite) TestDefaultAPIURL() {
+	suite.Equal("https://api.github.com", NewClient("", "elrond/council", "").url)
+}
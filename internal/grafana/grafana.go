@@ -0,0 +1,89 @@
+// Package grafana marks deployments on Grafana dashboards, using Grafana's HTTP annotations API.
+package grafana
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var requestTimeout = 10 * time.Second
+
+// A Client creates annotations in a Grafana instance.
+type Client struct {
+	url   string
+	token string
+	http  *http.Client
+}
+
+// NewClient creates a Client for the Grafana instance at the given base URL, authenticating with a service account
+// token or API key.
+func NewClient(url, token string) *Client {
+	return &Client{
+		url:   strings.TrimSuffix(url, "/"),
+		token: token,
+		http:  &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// An Annotation marks a span of time on Grafana's graphs. Without a DashboardUID, it's an organization-wide
+// annotation, which dashboards can show by querying for its tags.
+type Annotation struct {
+	DashboardUID string
+	Start        time.Time
+	End          time.Time
+	Tags         []string
+	Text         string
+}
+
+// Create adds the annotation to Grafana, returning its ID.
+func (c *Client) Create(a Annotation) (int64, error) {
+	body, err := json.Marshal(struct {
+		DashboardUID string   `json:"dashboardUID,omitempty"`
+		Time         int64    `json:"time"`
+		TimeEnd      int64    `json:"timeEnd"`
+		Tags         []string `json:"tags"`
+		Text         string   `json:"text"`
+	}{a.DashboardUID, milliseconds(a.Start), milliseconds(a.End), a.Tags, a.Text})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.url+"/api/annotations", bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("could not create annotation: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("could not create annotation: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return 0, fmt.Errorf("could not create annotation: grafana responded %s: %s", resp.Status,
+			strings.TrimSpace(string(message)))
+	}
+
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return 0, fmt.Errorf("could not read grafana's response: %w", err)
+	}
+	return created.ID, nil
+}
+
+func milliseconds(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
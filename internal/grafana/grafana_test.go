@@ -0,0 +1,87 @@
+package grafana
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type GrafanaTestSuite struct {
+	suite.Suite
+	server   *httptest.Server
+	requests []*http.Request
+	bodies   []map[string]interface{}
+	status   int
+	response string
+}
+
+func (suite *GrafanaTestSuite) BeforeTest(_, _ string) {
+	suite.requests, suite.bodies = nil, nil
+	suite.status, suite.response = http.StatusOK, `{"message":"Annotation added","id":42}`
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		suite.Require().NoError(json.NewDecoder(r.Body).Decode(&body))
+		suite.requests = append(suite.requests, r)
+		suite.bodies = append(suite.bodies, body)
+		w.WriteHeader(suite.status)
+		w.Write([]byte(suite.response))
+	}))
+}
+
+func (suite *GrafanaTestSuite) AfterTest(_, _ string) {
+	suite.server.Close()
+}
+
+func TestGrafanaTestSuite(t *testing.T) {
+	suite.Run(t, new(GrafanaTestSuite))
+}
+
+func (suite *GrafanaTestSuite) TestCreate() {
+	client := NewClient(suite.server.URL+"/", "glsa_mellon")
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	id, err := client.Create(Annotation{
+		DashboardUID: "barad-dur",
+		Start:        start,
+		End:          start.Add(41500 * time.Millisecond),
+		Tags:         []string{"release:minas-morgul", "namespace:mordor"},
+		Text:         "Deployed minas-morgul to mordor",
+	})
+	suite.Require().NoError(err)
+	suite.Equal(int64(42), id)
+
+	suite.Require().Len(suite.requests, 1)
+	req := suite.requests[0]
+	suite.Equal(http.MethodPost, req.Method)
+	suite.Equal("/api/annotations", req.URL.Path)
+	suite.Equal("Bearer glsa_mellon", req.Header.Get("Authorization"))
+	suite.Equal("application/json", req.Header.Get("Content-Type"))
+	suite.Equal(map[string]interface{}{
+		"dashboardUID": "barad-dur",
+		"time":         float64(1792404000000),
+		"timeEnd":      float64(1792404041500),
+		"tags":         []interface{}{"release:minas-morgul", "namespace:mordor"},
+		"text":         "Deployed minas-morgul to mordor",
+	}, suite.bodies[0])
+}
+
+func (suite *GrafanaTestSuite) TestOrganizationAnnotation() {
+	_, err := NewClient(suite.server.URL, "").Create(Annotation{Tags: []string{"drone-helm3"}})
+	suite.Require().NoError(err)
+	suite.NotContains(suite.bodies[0], "dashboardUID")
+	suite.Equal("", suite.requests[0].Header.Get("Authorization"))
+}
+
+func (suite *GrafanaTestSuite) TestErrors() {
+	suite.status, suite.response = http.StatusUnauthorized, `{"message":"invalid API key"}`
+	_, err := NewClient(suite.server.URL, "glsa_wrong").Create(Annotation{})
+	suite.EqualError(err, `could not create annotation: grafana responded 401 Unauthorized: {"message":"invalid API key"}`)
+
+	suite.status, suite.response = http.StatusOK, "<html>"
+	_, err = NewClient(suite.server.URL, "glsa_mellon").Create(Annotation{})
+	suite.Error(err)
+	suite.Contains(err.Error(), "could not read grafana's response")
+}
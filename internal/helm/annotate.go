@@ -0,0 +1,83 @@
+package helm
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/grafana"
+	"github.com/pelotech/drone-helm3/internal/logging"
+)
+
+// annotatedStep marks the upgrade it wraps on Grafana's dashboards, with an annotation covering the time it took.
+type annotatedStep struct {
+	Step
+	grafana    *grafana.Client
+	annotation grafana.Annotation
+	subject    string
+	build      string
+	stderr     io.Writer
+}
+
+// withAnnotation wraps an upgrade step so that it's recorded in Grafana, if the Config asks for that.
+func withAnnotation(cfg env.Config, step Step) Step {
+	if cfg.GrafanaURL == "" || cfg.DryRun {
+		return step
+	}
+
+	tags := []string{"drone-helm3", "release:" + cfg.Release}
+	subject := cfg.Release
+	if cfg.Namespace != "" {
+		tags = append(tags, "namespace:"+cfg.Namespace)
+		subject += " to " + cfg.Namespace
+	}
+	if cfg.ChartVersion != "" {
+		tags = append(tags, "chart_version:"+cfg.ChartVersion)
+	}
+	if cfg.DroneCommitSHA != "" {
+		tags = append(tags, "commit:"+cfg.DroneCommitSHA)
+	}
+
+	return &annotatedStep{
+		Step:       step,
+		grafana:    grafana.NewClient(cfg.GrafanaURL, cfg.GrafanaToken),
+		annotation: grafana.Annotation{DashboardUID: cfg.GrafanaDashboard, Tags: tags},
+		subject:    subject,
+		build:      cfg.DroneBuildNumber,
+		stderr:     cfg.Stderr,
+	}
+}
+
+// Execute executes the wrapped step, then annotates the time it took, whether or not it succeeded. The step's error
+// is returned either way; an annotation that can't be created is only reported.
+func (a *annotatedStep) Execute() error {
+	start := timeNow()
+	err := a.Step.Execute()
+
+	annotation := a.annotation
+	annotation.Start, annotation.End = start, timeNow()
+	status, text := outcomeSucceeded, "Deployed "+a.subject
+	if err != nil {
+		status, text = outcomeFailed, fmt.Sprintf("Failed to deploy %s: %s", a.subject, err)
+	}
+	annotation.Tags = append(append([]string{}, a.annotation.Tags...), "status:"+status)
+	annotation.Text = text
+	if a.build != "" {
+		annotation.Text += fmt.Sprintf(" (build %s)", a.build)
+	}
+
+	if _, annotateErr := a.grafana.Create(annotation); annotateErr != nil {
+		logging.Warnf(a.stderr, "%s", annotateErr)
+	}
+	return err
+}
+
+func (a *annotatedStep) unwrap() Step {
+	return a.Step
+}
+
+func (a *annotatedStep) Cleanup() {
+	if c, ok := a.Step.(cleaner); ok {
+		c.Cleanup()
+	}
+}
@@ -0,0 +1,156 @@
+package helm
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/run"
+)
+
+type AnnotateTestSuite struct {
+	suite.Suite
+	grafana         *httptest.Server
+	annotations     []map[string]interface{}
+	status          int
+	originalTimeNow func() time.Time
+}
+
+func (suite *AnnotateTestSuite) BeforeTest(_, _ string) {
+	suite.annotations, suite.status = nil, http.StatusOK
+	suite.grafana = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.Equal("/api/annotations", r.URL.Path)
+		suite.Equal("Bearer glsa_mellon", r.Header.Get("Authorization"))
+		annotation := map[string]interface{}{}
+		suite.Require().NoError(json.NewDecoder(r.Body).Decode(&annotation))
+		suite.annotations = append(suite.annotations, annotation)
+		w.WriteHeader(suite.status)
+		w.Write([]byte(`{"id": 1}`))
+	}))
+
+	// each reading of the clock is 30s after the last
+	suite.originalTimeNow = timeNow
+	clock := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		clock = clock.Add(30 * time.Second)
+		return clock
+	}
+}
+
+func (suite *AnnotateTestSuite) AfterTest(_, _ string) {
+	suite.grafana.Close()
+	timeNow = suite.originalTimeNow
+}
+
+func TestAnnotateTestSuite(t *testing.T) {
+	suite.Run(t, new(AnnotateTestSuite))
+}
+
+func (suite *AnnotateTestSuite) config() env.Config {
+	return env.Config{
+		GrafanaURL:       suite.grafana.URL,
+		GrafanaToken:     "glsa_mellon",
+		GrafanaDashboard: "barad-dur",
+		Release:          "minas-morgul",
+		Namespace:        "mordor",
+		ChartVersion:     "9.0.0",
+		DroneCommitSHA:   "a1b2c3d",
+		DroneBuildNumber: "3019",
+		Stderr:           &strings.Builder{},
+	}
+}
+
+func (suite *AnnotateTestSuite) TestWithAnnotation() {
+	step := &funcStep{}
+	suite.Same(step, withAnnotation(env.Config{}, step), "steps shouldn't be annotated unless it's asked for")
+
+	cfg := suite.config()
+	cfg.DryRun = true
+	suite.Same(step, withAnnotation(cfg, step), "dry runs shouldn't be annotated")
+
+	wrapped := withAnnotation(suite.config(), step)
+	suite.Require().IsType(&annotatedStep{}, wrapped)
+	suite.Same(step, wrapped.(*annotatedStep).Step)
+}
+
+func (suite *AnnotateTestSuite) TestUpgradeIsAnnotated() {
+	cfg := suite.config()
+	cfg.Command = "upgrade"
+	steps := upgrade(cfg)
+	suite.IsType(&annotatedStep{}, steps[len(steps)-2])
+	suite.Equal("Upgrade", stepName(steps[len(steps)-2]))
+
+	cfg.Releases = env.Releases{{Release: "cirith-ungol"}}
+	steps = upgradeReleases(cfg)
+	suite.IsType(&annotatedStep{}, steps[len(steps)-1].(*releaseSteps).steps[0])
+}
+
+func (suite *AnnotateTestSuite) TestExecute() {
+	ctrl := gomock.NewController(suite.T())
+	defer ctrl.Finish()
+	step := NewMockStep(ctrl)
+	wrapped := withAnnotation(suite.config(), step)
+
+	step.EXPECT().Prepare().Return(nil)
+	step.EXPECT().Execute().Return(nil)
+	suite.Require().NoError(wrapped.Prepare())
+	suite.NoError(wrapped.Execute())
+
+	suite.Require().Len(suite.annotations, 1)
+	suite.Equal(map[string]interface{}{
+		"dashboardUID": "barad-dur",
+		"time":         float64(time.Date(2026, 10, 19, 10, 0, 30, 0, time.UTC).Unix() * 1000),
+		"timeEnd":      float64(time.Date(2026, 10, 19, 10, 1, 0, 0, time.UTC).Unix() * 1000),
+		"tags": []interface{}{"drone-helm3", "release:minas-morgul", "namespace:mordor", "chart_version:9.0.0",
+			"commit:a1b2c3d", "status:succeeded"},
+		"text": "Deployed minas-morgul to mordor (build 3019)",
+	}, suite.annotations[0])
+
+	step.EXPECT().Execute().Return(errors.New("the gates are shut"))
+	suite.EqualError(wrapped.Execute(), "the gates are shut")
+	suite.Require().Len(suite.annotations, 2)
+	suite.Equal("Failed to deploy minas-morgul to mordor: the gates are shut (build 3019)", suite.annotations[1]["text"])
+	suite.Equal("status:failed", suite.annotations[1]["tags"].([]interface{})[5])
+}
+
+func (suite *AnnotateTestSuite) TestFailedSmokeTestIsAnnotated() {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer site.Close()
+	cfg := smokeTestConfig(site)
+	cfg.GrafanaURL, cfg.GrafanaToken = suite.grafana.URL, "glsa_mellon"
+	wrapped := withAnnotation(cfg, withSmokeTest(cfg, &funcStep{execute: func() error { return nil }}))
+
+	suite.Require().NoError(wrapped.Prepare())
+	suite.Error(wrapped.Execute())
+	suite.Require().Len(suite.annotations, 1)
+	suite.Contains(suite.annotations[0]["tags"], "status:failed")
+	suite.Contains(suite.annotations[0]["text"], "Failed to deploy hobbiton: smoke test of "+site.URL+" failed")
+}
+
+func (suite *AnnotateTestSuite) TestAnnotationFailureIsAWarning() {
+	suite.status = http.StatusForbidden
+	cfg := suite.config()
+	stderr := cfg.Stderr.(*strings.Builder)
+	wrapped := withAnnotation(cfg, &funcStep{execute: func() error { return nil }})
+
+	suite.NoError(wrapped.Execute())
+	suite.Contains(stderr.String(), "Warning: could not create annotation: grafana responded 403 Forbidden")
+}
+
+func (suite *AnnotateTestSuite) TestReportsWrappedStep() {
+	upgrade := run.NewUpgrade(env.Config{Chart: "mordor/tower", Release: "minas-morgul"})
+	suite.Require().NoError(upgrade.Prepare())
+	wrapped := withAnnotation(suite.config(), upgrade)
+	suite.Equal(upgrade.Command(), commandOf(wrapped))
+	suite.Equal("*run.Upgrade step", describe(wrapped))
+}
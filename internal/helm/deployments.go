@@ -0,0 +1,126 @@
+package helm
+
+import (
+	"io"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/forge"
+	"github.com/pelotech/drone-helm3/internal/logging"
+)
+
+// trackedStep reports the step it wraps, an upgrade or uninstall, to the Git forge as a deployment, so that the
+// forge can show what's deployed where.
+type trackedStep struct {
+	Step
+	forge          *forge.Client
+	deployment     forge.Deployment
+	environmentURL string
+	logURL         string
+	done           string // the description once the step succeeds
+	finalState     string // the deployment's state once the step succeeds
+	stderr         io.Writer
+}
+
+// withDeploymentStatus wraps a step so that it's reported to the forge, if the Config asks for that. Once the step
+// has succeeded, the deployment's state is finalState. An uninstall, whose final state is inactive, doesn't create a
+// deployment; the environment's latest one is marked inactive instead. Gitea has no deployments API, so nothing is
+// reported to it.
+func withDeploymentStatus(cfg env.Config, step Step, finalState string) Step {
+	if cfg.ForgeToken == "" || cfg.DroneRepo == "" || cfg.DroneCommitSHA == "" || cfg.DryRun {
+		return step
+	}
+	if forge.IsGitea(cfg.ForgeAPIURL) {
+		return step
+	}
+
+	done := "Deployed"
+	if finalState == forge.StateInactive {
+		done = "Uninstalled"
+	}
+	return &trackedStep{
+		Step:  step,
+		forge: forge.NewClient(cfg.ForgeAPIURL, cfg.DroneRepo, cfg.ForgeToken),
+		deployment: forge.Deployment{
+			Ref:         cfg.DroneCommitSHA,
+			Environment: deploymentEnvironment(cfg),
+			Description: "Deploying " + cfg.Release,
+			Transient:   cfg.Preview,
+		},
+		environmentURL: cfg.EnvironmentURL,
+		logURL:         cfg.DroneBuildLink,
+		done:           done + " " + cfg.Release,
+		finalState:     finalState,
+		stderr:         cfg.Stderr,
+	}
+}
+
+// deploymentEnvironment names the environment that the release is deployed to: the deployment target, if there is
+// one, or the namespace.
+func deploymentEnvironment(cfg env.Config) string {
+	switch {
+	case cfg.Environment != "":
+		return cfg.Environment
+	case cfg.DroneDeployTo != "":
+		return cfg.DroneDeployTo
+	default:
+		return cfg.Namespace
+	}
+}
+
+// Execute creates a deployment, then executes the wrapped step, keeping the deployment's status up to date; an
+// uninstall is handled by executeUninstall. The step's error is returned either way; problems with the forge are only
+// reported.
+func (t *trackedStep) Execute() error {
+	if t.finalState == forge.StateInactive {
+		return t.executeUninstall()
+	}
+
+	id, err := t.forge.CreateDeployment(t.deployment)
+	if err != nil {
+		logging.Warnf(t.stderr, "%s", err)
+		return t.Step.Execute()
+	}
+
+	t.setStatus(id, forge.Status{State: forge.StateInProgress, Description: t.deployment.Description})
+	err = t.Step.Execute()
+	if err != nil {
+		t.setStatus(id, forge.Status{State: forge.StateFailure, Description: err.Error()})
+	} else {
+		t.setStatus(id, forge.Status{State: t.finalState, EnvironmentURL: t.environmentURL, Description: t.done})
+	}
+	return err
+}
+
+// executeUninstall executes the wrapped uninstall, then marks the environment's latest deployment inactive, so that
+// the forge no longer links to it. Nothing new is deployed, so no deployment is created.
+func (t *trackedStep) executeUninstall() error {
+	if err := t.Step.Execute(); err != nil {
+		return err
+	}
+
+	id, err := t.forge.LatestDeployment(t.deployment.Environment)
+	switch {
+	case err != nil:
+		logging.Warnf(t.stderr, "%s", err)
+	case id != 0:
+		t.setStatus(id, forge.Status{State: forge.StateInactive, Description: t.done})
+	}
+	return nil
+}
+
+func (t *trackedStep) setStatus(id int64, status forge.Status) {
+	status.LogURL = t.logURL
+	if err := t.forge.CreateStatus(id, status); err != nil {
+		logging.Warnf(t.stderr, "%s", err)
+	}
+}
+
+func (t *trackedStep) unwrap() Step {
+	return t.Step
+}
+
+func (t *trackedStep) Cleanup() {
+	if c, ok := t.Step.(cleaner); ok {
+		c.Cleanup()
+	}
+}
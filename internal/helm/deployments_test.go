@@ -0,0 +1,238 @@
+package helm
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/forge"
+	"github.com/pelotech/drone-helm3/internal/run"
+)
+
+type DeploymentsTestSuite struct {
+	suite.Suite
+	forge       *httptest.Server
+	paths       []string
+	bodies      []map[string]interface{}
+	status      int
+	deployments string // the response to listing deployments
+}
+
+func (suite *DeploymentsTestSuite) BeforeTest(_, _ string) {
+	suite.paths, suite.bodies, suite.status = nil, nil, http.StatusCreated
+	suite.deployments = `[{"id": 1893}]`
+	suite.forge = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.Equal("Bearer ghp_mellon", r.Header.Get("Authorization"))
+		if r.Method == http.MethodGet {
+			suite.paths = append(suite.paths, r.URL.Path+"?"+r.URL.RawQuery)
+			w.Write([]byte(suite.deployments))
+			return
+		}
+		body := map[string]interface{}{}
+		suite.Require().NoError(json.NewDecoder(r.Body).Decode(&body))
+		suite.paths = append(suite.paths, r.URL.Path)
+		suite.bodies = append(suite.bodies, body)
+		w.WriteHeader(suite.status)
+		w.Write([]byte(`{"id": 1954}`))
+	}))
+}
+
+func (suite *DeploymentsTestSuite) AfterTest(_, _ string) {
+	suite.forge.Close()
+}
+
+func TestDeploymentsTestSuite(t *testing.T) {
+	suite.Run(t, new(DeploymentsTestSuite))
+}
+
+func (suite *DeploymentsTestSuite) config() env.Config {
+	return env.Config{
+		ForgeToken:     "ghp_mellon",
+		ForgeAPIURL:    suite.forge.URL,
+		EnvironmentURL: "https://edoras.example.com",
+		DroneRepo:      "eorl/rohan",
+		DroneCommitSHA: "a1b2c3d",
+		DroneBuildLink: "https://drone.example.com/eorl/rohan/7",
+		Release:        "meduseld",
+		Namespace:      "edoras",
+		Stderr:         &strings.Builder{},
+	}
+}
+
+func (suite *DeploymentsTestSuite) TestWithDeploymentStatus() {
+	step := &funcStep{}
+	suite.Same(step, withDeploymentStatus(env.Config{}, step, forge.StateSuccess),
+		"steps shouldn't be reported unless it's asked for")
+
+	cfg := suite.config()
+	cfg.DryRun = true
+	suite.Same(step, withDeploymentStatus(cfg, step, forge.StateSuccess), "dry runs shouldn't be reported")
+
+	cfg = suite.config()
+	cfg.DroneCommitSHA = ""
+	suite.Same(step, withDeploymentStatus(cfg, step, forge.StateSuccess), "deployments need a commit to refer to")
+
+	cfg = suite.config()
+	cfg.ForgeAPIURL = "https://gitea.example.com/api/v1"
+	suite.Same(step, withDeploymentStatus(cfg, step, forge.StateSuccess), "Gitea has no deployments to report")
+
+	wrapped := withDeploymentStatus(suite.config(), step, forge.StateSuccess)
+	suite.Require().IsType(&trackedStep{}, wrapped)
+	suite.Same(step, wrapped.(*trackedStep).Step)
+}
+
+func (suite *DeploymentsTestSuite) TestUpgradeAndUninstallAreReported() {
+	cfg := suite.config()
+	steps := upgrade(cfg)
+	suite.Equal("Upgrade", stepName(steps[len(steps)-2]))
+	suite.IsType(&trackedStep{}, steps[len(steps)-2])
+
+	cfg.Releases = env.Releases{{Release: "hornburg"}}
+	steps = upgradeReleases(cfg)
+	suite.IsType(&trackedStep{}, steps[len(steps)-1].(*releaseSteps).steps[0])
+
+	steps = uninstall(suite.config())
+	suite.IsType(&trackedStep{}, steps[len(steps)-1])
+	suite.Equal("Uninstall", stepName(steps[len(steps)-1]))
+}
+
+func (suite *DeploymentsTestSuite) TestEnvironment() {
+	cfg := suite.config()
+	suite.Equal("edoras", deploymentEnvironment(cfg))
+	cfg.DroneDeployTo = "production"
+	suite.Equal("production", deploymentEnvironment(cfg))
+	cfg.Environment = "rohan"
+	suite.Equal("rohan", deploymentEnvironment(cfg))
+}
+
+func (suite *DeploymentsTestSuite) TestExecute() {
+	ctrl := gomock.NewController(suite.T())
+	defer ctrl.Finish()
+	step := NewMockStep(ctrl)
+	cfg := suite.config()
+	cfg.Preview = true
+	wrapped := withDeploymentStatus(cfg, step, forge.StateSuccess)
+
+	step.EXPECT().Execute().DoAndReturn(func() error {
+		suite.Len(suite.paths, 2, "the deployment should be in progress while the step executes")
+		return nil
+	})
+	suite.NoError(wrapped.Execute())
+
+	suite.Equal([]string{
+		"/repos/eorl/rohan/deployments",
+		"/repos/eorl/rohan/deployments/1954/statuses",
+		"/repos/eorl/rohan/deployments/1954/statuses",
+	}, suite.paths)
+	suite.Equal(map[string]interface{}{
+		"ref":                   "a1b2c3d",
+		"environment":           "edoras",
+		"description":           "Deploying meduseld",
+		"auto_merge":            false,
+		"required_contexts":     []interface{}{},
+		"transient_environment": true,
+	}, suite.bodies[0])
+	suite.Equal(map[string]interface{}{
+		"state":       "in_progress",
+		"log_url":     "https://drone.example.com/eorl/rohan/7",
+		"description": "Deploying meduseld",
+	}, suite.bodies[1])
+	suite.Equal(map[string]interface{}{
+		"state":           "success",
+		"environment_url": "https://edoras.example.com",
+		"log_url":         "https://drone.example.com/eorl/rohan/7",
+		"description":     "Deployed meduseld",
+	}, suite.bodies[2])
+}
+
+func (suite *DeploymentsTestSuite) TestExecuteFailure() {
+	wrapped := withDeploymentStatus(suite.config(), &funcStep{execute: func() error {
+		return errors.New("the beacons are lit")
+	}}, forge.StateSuccess)
+
+	suite.EqualError(wrapped.Execute(), "the beacons are lit")
+	suite.Require().Len(suite.bodies, 3)
+	suite.Equal("failure", suite.bodies[2]["state"])
+	suite.Equal("the beacons are lit", suite.bodies[2]["description"])
+	suite.Nil(suite.bodies[2]["environment_url"])
+}
+
+func (suite *DeploymentsTestSuite) TestFailedSmokeTestFailsTheDeployment() {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer site.Close()
+	cfg := smokeTestConfig(site)
+	cfg.ForgeToken, cfg.ForgeAPIURL = "ghp_mellon", suite.forge.URL
+	cfg.DroneRepo, cfg.DroneCommitSHA = "eorl/rohan", "a1b2c3d"
+	upgrade := withSmokeTest(cfg, &funcStep{execute: func() error { return nil }})
+	wrapped := withDeploymentStatus(cfg, upgrade, forge.StateSuccess)
+
+	suite.Require().NoError(wrapped.Prepare())
+	suite.Error(wrapped.Execute())
+	suite.Require().Len(suite.bodies, 3)
+	suite.Equal("failure", suite.bodies[2]["state"], "a release that fails its smoke test wasn't deployed")
+	suite.Contains(suite.bodies[2]["description"], "smoke test of "+site.URL+" failed")
+}
+
+func (suite *DeploymentsTestSuite) TestExecuteUninstall() {
+	uninstall := &funcStep{execute: func() error { return nil }}
+	wrapped := withDeploymentStatus(suite.config(), uninstall, forge.StateInactive)
+
+	suite.NoError(wrapped.Execute())
+	suite.Equal([]string{
+		"/repos/eorl/rohan/deployments?environment=edoras&per_page=1",
+		"/repos/eorl/rohan/deployments/1893/statuses",
+	}, suite.paths, "the existing deployment should be marked inactive, rather than a new one being created")
+	suite.Equal(map[string]interface{}{
+		"state":       "inactive",
+		"log_url":     "https://drone.example.com/eorl/rohan/7",
+		"description": "Uninstalled meduseld",
+	}, suite.bodies[0], "an uninstalled release has nowhere to visit")
+}
+
+func (suite *DeploymentsTestSuite) TestExecuteUninstallWithoutDeployments() {
+	suite.deployments = `[]`
+	uninstall := &funcStep{execute: func() error { return nil }}
+	wrapped := withDeploymentStatus(suite.config(), uninstall, forge.StateInactive)
+	suite.NoError(wrapped.Execute())
+	suite.Len(suite.paths, 1, "there's no deployment to mark inactive")
+
+	suite.paths = nil
+	wrapped = withDeploymentStatus(suite.config(), &funcStep{execute: func() error {
+		return errors.New("the king is not dead")
+	}}, forge.StateInactive)
+	suite.EqualError(wrapped.Execute(), "the king is not dead")
+	suite.Empty(suite.paths, "the deployment is still there if the uninstall failed")
+}
+
+func (suite *DeploymentsTestSuite) TestForgeFailureIsAWarning() {
+	suite.status = http.StatusForbidden
+	cfg := suite.config()
+	stderr := cfg.Stderr.(*strings.Builder)
+	executed := false
+	wrapped := withDeploymentStatus(cfg, &funcStep{execute: func() error {
+		executed = true
+		return nil
+	}}, forge.StateSuccess)
+
+	suite.NoError(wrapped.Execute())
+	suite.True(executed, "the step should execute even if the forge can't be told")
+	suite.Len(suite.paths, 1, "there's no deployment to set the status of")
+	suite.Contains(stderr.String(), "Warning: could not create deployment: forge responded 403 Forbidden")
+}
+
+func (suite *DeploymentsTestSuite) TestReportsWrappedStep() {
+	upgrade := run.NewUpgrade(env.Config{Chart: "rohan/hall", Release: "meduseld"})
+	suite.Require().NoError(upgrade.Prepare())
+	wrapped := withDeploymentStatus(suite.config(), upgrade, forge.StateSuccess)
+	suite.Equal(upgrade.Command(), commandOf(wrapped))
+	suite.Equal("*run.Upgrade step", describe(wrapped))
+}
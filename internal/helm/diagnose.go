@@ -0,0 +1,56 @@
+package helm
+
+import (
+	"io"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
+	"github.com/pelotech/drone-helm3/internal/run"
+)
+
+// diagnosedStep reports on the state of the release if the step it wraps fails.
+type diagnosedStep struct {
+	Step
+	diagnose *run.Diagnose
+	stderr   io.Writer
+}
+
+// withDiagnostics wraps an upgrade step so that it's diagnosed on failure, if the Config asks for that.
+func withDiagnostics(cfg env.Config, step Step) Step {
+	if !cfg.DiagnoseOnFailure {
+		return step
+	}
+	return &diagnosedStep{
+		Step:     step,
+		diagnose: run.NewDiagnose(cfg, kubeConfigFile),
+		stderr:   cfg.Stderr,
+	}
+}
+
+func (d *diagnosedStep) Prepare() error {
+	if err := d.Step.Prepare(); err != nil {
+		return err
+	}
+	return d.diagnose.Prepare()
+}
+
+// Execute executes the wrapped step, then runs the diagnostics if it failed. The step's error is returned either way.
+func (d *diagnosedStep) Execute() error {
+	err := d.Step.Execute()
+	if err != nil {
+		if diagErr := d.diagnose.Execute(); diagErr != nil {
+			logging.Errorf(d.stderr, "%s", diagErr)
+		}
+	}
+	return err
+}
+
+func (d *diagnosedStep) unwrap() Step {
+	return d.Step
+}
+
+func (d *diagnosedStep) Cleanup() {
+	if c, ok := d.Step.(cleaner); ok {
+		c.Cleanup()
+	}
+}
@@ -0,0 +1,60 @@
+package helm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/run"
+)
+
+type DiagnoseTestSuite struct {
+	suite.Suite
+}
+
+func TestDiagnoseTestSuite(t *testing.T) {
+	suite.Run(t, new(DiagnoseTestSuite))
+}
+
+func (suite *DiagnoseTestSuite) TestWithDiagnostics() {
+	step := &funcStep{}
+	suite.Same(step, withDiagnostics(env.Config{}, step), "steps shouldn't be diagnosed unless it's asked for")
+
+	wrapped := withDiagnostics(env.Config{DiagnoseOnFailure: true, Release: "orthanc"}, step)
+	suite.Require().IsType(&diagnosedStep{}, wrapped)
+	suite.Same(step, wrapped.(*diagnosedStep).Step)
+}
+
+func (suite *DiagnoseTestSuite) TestUpgradeIsDiagnosed() {
+	steps := upgrade(env.Config{SkipKubeconfig: true, DiagnoseOnFailure: true})
+	suite.Require().Len(steps, 2)
+	suite.Require().IsType(&diagnosedStep{}, steps[0])
+	suite.IsType(&run.Upgrade{}, steps[0].(*diagnosedStep).Step)
+	suite.Equal("*run.Upgrade step", describe(steps[0]))
+
+	steps = upgrade(env.Config{SkipKubeconfig: true, DiagnoseOnFailure: true, Releases: env.Releases{{Release: "orthanc"}}})
+	suite.Require().Len(steps, 1)
+	suite.IsType(&diagnosedStep{}, steps[0].(*releaseSteps).steps[0])
+}
+
+func (suite *DiagnoseTestSuite) TestExecute() {
+	ctrl := gomock.NewController(suite.T())
+	defer ctrl.Finish()
+	step := NewMockStep(ctrl)
+	stderr := &strings.Builder{}
+	wrapped := withDiagnostics(env.Config{DiagnoseOnFailure: true, Release: "orthanc", Stderr: stderr}, step)
+
+	step.EXPECT().Prepare().Return(nil)
+	step.EXPECT().Execute().Return(nil)
+	suite.Require().NoError(wrapped.Prepare())
+	suite.NoError(wrapped.Execute())
+	suite.Empty(stderr.String(), "diagnostics should only run when the step fails")
+
+	step.EXPECT().Execute().Return(errors.New("timed out waiting for the condition"))
+	suite.EqualError(wrapped.Execute(), "timed out waiting for the condition")
+	suite.Contains(stderr.String(), "==== diagnostics for release orthanc")
+}
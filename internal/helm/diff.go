@@ -0,0 +1,212 @@
+package helm
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/forge"
+	"github.com/pelotech/drone-helm3/internal/logging"
+	"github.com/pelotech/drone-helm3/internal/run"
+)
+
+// maxCommentDiff is how much of the diffs a comment can hold. GitHub's limit on a comment is 65536 characters, which
+// leaves room for the rest of the comment.
+var maxCommentDiff = 60000
+
+var backticks = regexp.MustCompile("`{3,}")
+
+// differ is a step that finds a release's changes, such as a run.Diff.
+type differ interface {
+	Result() *run.ReleaseDiff
+}
+
+var diff = func(cfg env.Config) []Step {
+	var steps []Step
+	var diffs []differ
+	if len(cfg.Releases) > 0 {
+		steps, diffs = diffReleases(cfg)
+	} else {
+		if !cfg.SkipKubeconfig {
+			steps = append(steps, run.NewInitKube(cfg, kubeConfigTemplate, kubeConfigFile))
+		}
+		steps = append(steps, addRepos(cfg)...)
+		if cfg.DependenciesAction != "" {
+			steps = append(steps, run.NewDepAction(cfg))
+		}
+		if cfg.UpdateDependencies {
+			steps = append(steps, run.NewDepUpdate(cfg))
+		}
+		d := run.NewDiff(cfg)
+		steps = append(steps, d)
+		diffs = append(diffs, d)
+	}
+
+	if cfg.ForgeToken != "" && cfg.DroneRepo != "" && cfg.DronePullRequest != "" {
+		steps = append(steps, newDiffComment(cfg, diffs))
+	}
+	return steps
+}
+
+// withDiffComment puts the diff of a pull request's chart, and the comment with it, ahead of the steps that deploy it,
+// if the Config asks for that. The diff has already set up the kubeconfig, repositories and dependencies by the time
+// the deployment starts, so that isn't done twice.
+func withDiffComment(cfg env.Config, deploy func(env.Config) []Step) []Step {
+	if !cfg.DiffComment || cfg.DroneEvent != "pull_request" {
+		return deploy(cfg)
+	}
+
+	steps := diff(cfg)
+	for _, step := range steps {
+		if group, ok := step.(*releaseSteps); ok {
+			// the diffs don't wait for each other, and mustn't be mistaken for the releases they're diffs of
+			group.name, group.needs = "diff of "+group.name, nil
+		}
+	}
+	cfg.SkipKubeconfig, cfg.AddRepos = true, nil
+	cfg.DependenciesAction, cfg.UpdateDependencies = "", false
+	return append(steps, deploy(cfg)...)
+}
+
+// diffComment posts the diffs' results on the build's pull request, replacing the comment from any earlier build.
+type diffComment struct {
+	forge       *forge.Client
+	pullRequest string
+	number      int
+	diffs       []differ
+	marker      string
+	commit      string
+	buildNumber string
+	buildLink   string
+	stderr      io.Writer
+}
+
+func newDiffComment(cfg env.Config, diffs []differ) *diffComment {
+	releases := []string{cfg.Release}
+	if len(cfg.Releases) > 0 {
+		releases = releases[:0]
+		for _, release := range cfg.Releases {
+			releases = append(releases, release.Release)
+		}
+	}
+	return &diffComment{
+		forge:       forge.NewClient(cfg.ForgeAPIURL, cfg.DroneRepo, cfg.ForgeToken),
+		pullRequest: cfg.DronePullRequest,
+		diffs:       diffs,
+		marker:      fmt.Sprintf("<!-- drone-helm3 diff: %s -->", strings.Join(releases, ", ")),
+		commit:      cfg.DroneCommitSHA,
+		buildNumber: cfg.DroneBuildNumber,
+		buildLink:   cfg.DroneBuildLink,
+		stderr:      cfg.Stderr,
+	}
+}
+
+// Prepare checks that there's a pull request to comment on.
+func (c *diffComment) Prepare() error {
+	number, err := strconv.Atoi(c.pullRequest)
+	if err != nil || number < 1 {
+		return fmt.Errorf("%q is not a pull request number", c.pullRequest)
+	}
+	c.number = number
+	return nil
+}
+
+// Execute creates or updates the pull request's comment. A comment that can't be posted is only reported, since the
+// diffs are in the build log as well.
+func (c *diffComment) Execute() error {
+	if err := c.forge.UpsertComment(c.number, c.marker, c.body()); err != nil {
+		logging.Warnf(c.stderr, "%s", err)
+	}
+	return nil
+}
+
+func (c *diffComment) body() string {
+	body := &strings.Builder{}
+	fmt.Fprintf(body, "%s\n### Helm diff\n", c.marker)
+
+	remaining := maxCommentDiff
+	truncated := false
+	for _, d := range c.diffs {
+		result := d.Result()
+		if result == nil {
+			continue
+		}
+		fmt.Fprintf(body, "\n**%s**", result.Release)
+		if result.Namespace != "" {
+			fmt.Fprintf(body, " in `%s`", result.Namespace)
+		}
+		fmt.Fprintf(body, ": %s\n", result.Summary())
+		if result.Text == "" {
+			continue
+		}
+
+		text, cut := truncateDiff(result.Text, remaining)
+		remaining -= len(text)
+		truncated = truncated || cut
+		if text == "" {
+			body.WriteString("\nThe diff is too long to show here.\n")
+			continue
+		}
+		fence := "```"
+		for _, ticks := range backticks.FindAllString(text, -1) {
+			if len(ticks) >= len(fence) {
+				fence = ticks + "`"
+			}
+		}
+		fmt.Fprintf(body, "\n<details><summary>Changes to %s</summary>\n\n%sdiff\n%s%s\n\n</details>\n",
+			result.Release, fence, text, fence)
+	}
+
+	var footer []string
+	if truncated {
+		footer = append(footer, "Some of the diff was left out, since it's too long for a comment; "+
+			"the whole diff is in the build log.")
+	}
+	if c.commit != "" {
+		commit := c.commit
+		if len(commit) > 7 {
+			commit = commit[:7]
+		}
+		footer = append(footer, fmt.Sprintf("Rendered from %s%s.", commit, c.build()))
+	}
+	if len(footer) > 0 {
+		fmt.Fprintf(body, "\n_%s_\n", strings.Join(footer, " "))
+	}
+	return body.String()
+}
+
+// build describes the build that rendered the diffs, such as " by build 7", linking to it if possible.
+func (c *diffComment) build() string {
+	switch {
+	case c.buildNumber == "":
+		return ""
+	case c.buildLink == "":
+		return " by build " + c.buildNumber
+	default:
+		return fmt.Sprintf(" by [build %s](%s)", c.buildNumber, c.buildLink)
+	}
+}
+
+// truncateDiff cuts a diff down to at most limit bytes, at the end of a line, followed by a note of how many lines
+// were left out. It reports whether the diff was cut.
+func truncateDiff(text string, limit int) (string, bool) {
+	if len(text) <= limit {
+		return text, false
+	}
+	if limit <= 0 {
+		return "", true
+	}
+	end := strings.LastIndex(text[:limit], "\n")
+	if end < 0 {
+		return "", true
+	}
+	left := strings.Count(text[end+1:], "\n")
+	lines := "lines"
+	if left == 1 {
+		lines = "line"
+	}
+	return text[:end+1] + fmt.Sprintf("... %d more %s\n", left, lines), true
+}
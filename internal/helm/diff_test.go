@@ -0,0 +1,261 @@
+package helm
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/run"
+)
+
+// fakeDiff is a differ with a canned result.
+type fakeDiff struct {
+	result *run.ReleaseDiff
+}
+
+func (f *fakeDiff) Result() *run.ReleaseDiff {
+	return f.result
+}
+
+type DiffTestSuite struct {
+	suite.Suite
+	forge       *httptest.Server
+	requests    []string
+	comments    []string
+	originalMax int
+}
+
+func (suite *DiffTestSuite) BeforeTest(_, _ string) {
+	suite.requests, suite.comments = nil, nil
+	suite.forge = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.Equal("Bearer ghp_mellon", r.Header.Get("Authorization"))
+		suite.requests = append(suite.requests, r.Method+" "+r.URL.Path)
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`[]`))
+			return
+		}
+		var body struct{ Body string }
+		suite.Require().NoError(json.NewDecoder(r.Body).Decode(&body))
+		suite.comments = append(suite.comments, body.Body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": 1}`))
+	}))
+	suite.originalMax = maxCommentDiff
+}
+
+func (suite *DiffTestSuite) AfterTest(_, _ string) {
+	suite.forge.Close()
+	maxCommentDiff = suite.originalMax
+}
+
+func TestDiffTestSuite(t *testing.T) {
+	suite.Run(t, new(DiffTestSuite))
+}
+
+func (suite *DiffTestSuite) config() env.Config {
+	return env.Config{
+		Chart:            "gondor/citadel",
+		Release:          "minas-tirith",
+		Namespace:        "gondor",
+		ForgeToken:       "ghp_mellon",
+		ForgeAPIURL:      suite.forge.URL,
+		DroneRepo:        "elessar/gondor",
+		DronePullRequest: "7",
+		DroneCommitSHA:   "a1b2c3d4e5f6",
+		DroneBuildNumber: "3019",
+		DroneBuildLink:   "https://drone.example.com/elessar/gondor/3019",
+		Stderr:           &strings.Builder{},
+	}
+}
+
+func (suite *DiffTestSuite) TestDeterminePlan() {
+	cfg := env.Config{Command: "diff"}
+	suite.Same(&diff, determineSteps(cfg))
+
+	cfg = env.Config{DiffComment: true, DroneEvent: "pull_request"}
+	suite.Same(&upgrade, determineSteps(cfg), "pull requests should still be deployed when they're diffed")
+
+	cfg = env.Config{DiffComment: true, Preview: true, DroneEvent: "pull_request"}
+	suite.Same(&previewUpgrade, determineSteps(cfg))
+}
+
+func (suite *DiffTestSuite) TestUpgradeWithDiffComment() {
+	cfg := suite.config()
+	cfg.DiffComment = true
+	cfg.DroneEvent = "pull_request"
+	cfg.AddRepos = []string{"gondor=https://charts.gondor.example.com"}
+	cfg.DependenciesAction = "build"
+	steps := upgrade(cfg)
+	suite.Require().Len(steps, 7)
+	suite.IsType(&run.InitKube{}, steps[0])
+	suite.IsType(&run.AddRepo{}, steps[1])
+	suite.IsType(&run.DepAction{}, steps[2])
+	suite.IsType(&run.Diff{}, steps[3])
+	suite.IsType(&diffComment{}, steps[4])
+	suite.IsType(&run.Upgrade{}, unwrap(steps[5]),
+		"the kubeconfig, repositories and dependencies should only be set up once")
+	suite.IsType(&run.ExportStatus{}, steps[6])
+
+	cfg.DroneEvent = "push"
+	suite.Len(upgrade(cfg), 5, "only pull requests should be diffed")
+	cfg.DroneEvent, cfg.DiffComment = "pull_request", false
+	suite.Len(upgrade(cfg), 5, "pull requests should only be diffed when diff_comment is set")
+}
+
+func (suite *DiffTestSuite) TestUpgradeReleasesWithDiffComment() {
+	cfg := suite.config()
+	cfg.DiffComment = true
+	cfg.DroneEvent = "pull_request"
+	cfg.Releases = env.Releases{{Release: "osgiliath", Needs: []string{"minas-tirith"}}, {Release: "minas-tirith"}}
+	steps := upgrade(cfg)
+	suite.Require().Len(steps, 6)
+	suite.IsType(&run.InitKube{}, steps[0])
+	suite.Equal("diff of osgiliath", steps[1].(*releaseSteps).name)
+	suite.Empty(steps[1].(*releaseSteps).needs)
+	suite.Equal("diff of minas-tirith", steps[2].(*releaseSteps).name)
+	suite.IsType(&diffComment{}, steps[3])
+	suite.Equal("osgiliath", steps[4].(*releaseSteps).name)
+	suite.Equal("minas-tirith", steps[5].(*releaseSteps).name)
+	suite.NoError(orderReleases(steps), "the diffs shouldn't be confused with the releases")
+	suite.Equal("minas-tirith", steps[4].(*releaseSteps).name)
+}
+
+func (suite *DiffTestSuite) TestPreviewUpgradeWithDiffComment() {
+	cfg := suite.config()
+	cfg.DiffComment = true
+	cfg.Preview = true
+	cfg.DroneEvent = "pull_request"
+	steps := previewUpgrade(cfg)
+	suite.Require().IsType(&diffComment{}, steps[2])
+	suite.Equal("<!-- drone-helm3 diff: minas-tirith-pr-7 -->", steps[2].(*diffComment).marker,
+		"the diff should be of the preview release")
+	suite.IsType(&run.Upgrade{}, unwrap(steps[3]))
+}
+
+func (suite *DiffTestSuite) TestDiff() {
+	steps := diff(env.Config{})
+	suite.Require().Len(steps, 2)
+	suite.IsType(&run.InitKube{}, steps[0])
+	suite.IsType(&run.Diff{}, steps[1])
+
+	steps = diff(suite.config())
+	suite.Require().Len(steps, 3)
+	suite.IsType(&diffComment{}, steps[2])
+	suite.Equal([]differ{steps[1].(*run.Diff)}, steps[2].(*diffComment).diffs)
+	suite.Equal("<!-- drone-helm3 diff: minas-tirith -->", steps[2].(*diffComment).marker)
+
+	cfg := suite.config()
+	cfg.DronePullRequest = ""
+	suite.Len(diff(cfg), 2, "there's nothing to comment on outside a pull request")
+}
+
+func (suite *DiffTestSuite) TestDiffReleases() {
+	cfg := suite.config()
+	cfg.Releases = env.Releases{{Release: "minas-tirith"}, {Release: "osgiliath"}}
+	steps := diff(cfg)
+	suite.Require().Len(steps, 4)
+	suite.IsType(&run.InitKube{}, steps[0])
+	first := steps[1].(*releaseSteps)
+	second := steps[2].(*releaseSteps)
+	suite.Equal("osgiliath", second.name)
+
+	comment := steps[3].(*diffComment)
+	suite.Equal([]differ{first.steps[0].(*run.Diff), second.steps[0].(*run.Diff)}, comment.diffs)
+	suite.Equal("<!-- drone-helm3 diff: minas-tirith, osgiliath -->", comment.marker)
+}
+
+func (suite *DiffTestSuite) TestPrepare() {
+	comment := newDiffComment(suite.config(), nil)
+	suite.Require().NoError(comment.Prepare())
+	suite.Equal(7, comment.number)
+
+	cfg := suite.config()
+	cfg.DronePullRequest = "seven"
+	suite.EqualError(newDiffComment(cfg, nil).Prepare(), `"seven" is not a pull request number`)
+}
+
+func (suite *DiffTestSuite) TestExecute() {
+	comment := newDiffComment(suite.config(), []differ{
+		&fakeDiff{&run.ReleaseDiff{
+			Release:   "minas-tirith",
+			Namespace: "gondor",
+			Changes:   []run.ResourceChange{{Resource: "Deployment/citadel", Change: "changed"}},
+			Text:      "--- Deployment/citadel (live)\n+++ Deployment/citadel (chart)\n-  replicas: 1\n+  replicas: 3\n",
+		}},
+		&fakeDiff{&run.ReleaseDiff{Release: "osgiliath", Namespace: "gondor"}},
+		&fakeDiff{},
+	})
+	suite.Require().NoError(comment.Prepare())
+	suite.Require().NoError(comment.Execute())
+
+	suite.Equal([]string{
+		"GET /repos/elessar/gondor/issues/7/comments",
+		"POST /repos/elessar/gondor/issues/7/comments",
+	}, suite.requests)
+	suite.Equal([]string{"<!-- drone-helm3 diff: minas-tirith -->\n" +
+		"### Helm diff\n" +
+		"\n" +
+		"**minas-tirith** in `gondor`: 1 to change\n" +
+		"\n" +
+		"<details><summary>Changes to minas-tirith</summary>\n" +
+		"\n" +
+		"```diff\n" +
+		"--- Deployment/citadel (live)\n" +
+		"+++ Deployment/citadel (chart)\n" +
+		"-  replicas: 1\n" +
+		"+  replicas: 3\n" +
+		"```\n" +
+		"\n" +
+		"</details>\n" +
+		"\n" +
+		"**osgiliath** in `gondor`: no changes\n" +
+		"\n" +
+		"_Rendered from a1b2c3d by [build 3019](https://drone.example.com/elessar/gondor/3019)._\n",
+	}, suite.comments)
+}
+
+func (suite *DiffTestSuite) TestLongDiffs() {
+	maxCommentDiff = 60
+	comment := newDiffComment(suite.config(), []differ{
+		&fakeDiff{&run.ReleaseDiff{
+			Release: "minas-tirith",
+			Changes: []run.ResourceChange{{Resource: "ConfigMap/white-tree", Change: "created"}},
+			Text:    "+line one is here\n+line two is here\n+line three is here\n+line four is here\n",
+		}},
+		&fakeDiff{&run.ReleaseDiff{
+			Release: "osgiliath",
+			Changes: []run.ResourceChange{{Resource: "ConfigMap/ruins", Change: "created"}},
+			Text:    "+````\n",
+		}},
+	})
+	body := comment.body()
+	suite.Contains(body, "+line three is here\n... 1 more line\n```\n")
+	suite.NotContains(body, "line four")
+	suite.Contains(body, "**osgiliath**: 1 to create\n\nThe diff is too long to show here.\n")
+	suite.Contains(body, "_Some of the diff was left out, since it's too long for a comment; "+
+		"the whole diff is in the build log. Rendered from")
+}
+
+func (suite *DiffTestSuite) TestFences() {
+	comment := newDiffComment(suite.config(), []differ{&fakeDiff{&run.ReleaseDiff{
+		Release: "minas-tirith",
+		Changes: []run.ResourceChange{{Resource: "ConfigMap/docs", Change: "created"}},
+		Text:    "+```yaml\n+````\n",
+	}}})
+	suite.Contains(comment.body(), "\n`````diff\n+```yaml\n+````\n`````\n",
+		"backticks in the diff shouldn't close its code block")
+}
+
+func (suite *DiffTestSuite) TestCommentFailureIsAWarning() {
+	suite.forge.Close()
+	cfg := suite.config()
+	comment := newDiffComment(cfg, nil)
+	suite.Require().NoError(comment.Prepare())
+	suite.NoError(comment.Execute())
+	suite.Contains(cfg.Stderr.(*strings.Builder).String(), "Warning: could not list comments: ")
+}
@@ -0,0 +1,166 @@
+package helm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelotech/drone-helm3/internal/logging"
+	"github.com/pelotech/drone-helm3/internal/run"
+)
+
+// pushgatewayJob is the job that metrics are grouped under on the Pushgateway.
+const pushgatewayJob = "drone-helm3"
+
+var (
+	pushTimeout = 10 * time.Second
+
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// labels are a metric's Prometheus labels.
+type labels map[string]string
+
+// String writes the labels in the Prometheus text format, sorted by name so that the output is stable.
+func (l labels) String() string {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(l[name]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// with returns a copy of the labels with more added.
+func (l labels) with(more labels) labels {
+	all := make(labels, len(l)+len(more))
+	for name, value := range l {
+		all[name] = value
+	}
+	for name, value := range more {
+		all[name] = value
+	}
+	return all
+}
+
+// metricsWriter writes metrics in the Prometheus text format, with each metric's TYPE given once.
+type metricsWriter struct {
+	buf   bytes.Buffer
+	typed map[string]bool
+}
+
+func (m *metricsWriter) gauge(name, help string, l labels, value float64) {
+	if m.typed == nil {
+		m.typed = make(map[string]bool)
+	}
+	if !m.typed[name] {
+		fmt.Fprintf(&m.buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		m.typed[name] = true
+	}
+	fmt.Fprintf(&m.buf, "%s%s %s\n", name, l, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+// metrics describes the run, and the releases it deployed, in the Prometheus text format.
+func (p *Plan) metrics(report *Report, releases []*run.ReleaseInfo, start time.Time, duration time.Duration,
+	planErr error) string {
+	chartVersion := p.cfg.ChartVersion
+	if len(releases) == 1 && releases[0].ChartVersion != "" {
+		chartVersion = releases[0].ChartVersion
+	}
+	common := labels{
+		"release":       p.cfg.Release,
+		"namespace":     p.cfg.Namespace,
+		"chart_version": chartVersion,
+		"repo":          p.cfg.DroneRepo,
+	}
+
+	success := 1.0
+	if planErr != nil {
+		success = 0
+	}
+
+	m := &metricsWriter{}
+	m.gauge("drone_helm3_deploy_timestamp_seconds", "When the deployment started, as a Unix timestamp.",
+		common, float64(start.Unix()))
+	m.gauge("drone_helm3_deploy_duration_seconds", "How long the deployment took.", common, duration.Seconds())
+	m.gauge("drone_helm3_deploy_success", "Whether the deployment succeeded (1) or failed (0).", common, success)
+	for i, step := range report.Steps {
+		if step.Outcome != outcomeSucceeded && step.Outcome != outcomeFailed {
+			continue
+		}
+		m.gauge("drone_helm3_step_duration_seconds", "How long each step took to execute.",
+			common.with(labels{"step": step.Type, "index": fmt.Sprint(i)}), step.Execute.Seconds())
+	}
+	for _, release := range releases {
+		m.gauge("drone_helm3_release_revision", "The revision that each release was deployed as.",
+			common.with(labels{
+				"release":       release.Name,
+				"namespace":     release.Namespace,
+				"chart_version": release.ChartVersion,
+			}), float64(release.Revision))
+	}
+	return m.buf.String()
+}
+
+// pushMetrics sends the run's metrics to the Pushgateway, if the Config names one. Metrics that can't be pushed are
+// reported, but don't fail the build.
+func (p *Plan) pushMetrics(report *Report, start time.Time, duration time.Duration, planErr error) {
+	if p.cfg.PushgatewayURL == "" {
+		return
+	}
+	if err := p.push(p.metrics(report, p.deployedReleases(), start, duration, planErr)); err != nil {
+		logging.Warnf(p.cfg.Stderr, "could not push metrics: %s", err)
+	}
+}
+
+// push replaces the metrics in the run's group on the Pushgateway. The group is named by the repo, release, namespace
+// and environment, so that deploying the same release to several places doesn't overwrite its metrics.
+func (p *Plan) push(metrics string) error {
+	url := strings.TrimSuffix(p.cfg.PushgatewayURL, "/") + "/metrics/job/" + pushgatewayJob +
+		groupingKey("repo", p.cfg.DroneRepo) + groupingKey("release", p.cfg.Release) +
+		groupingKey("namespace", p.cfg.Namespace) + groupingKey("environment", p.cfg.Environment)
+	req, err := http.NewRequest(http.MethodPut, url, strings.NewReader(metrics))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := (&http.Client{Timeout: pushTimeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("pushgateway responded %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// groupingKey is a label for the Pushgateway URL's path. Values are base64-encoded, since they may contain slashes.
+func groupingKey(name, value string) string {
+	if value == "" {
+		return ""
+	}
+	return fmt.Sprintf("/%s@base64/%s", name, base64.RawURLEncoding.EncodeToString([]byte(value)))
+}
+
+// deployedReleases finds the releases that the plan deployed and exported the status of.
+func (p *Plan) deployedReleases() []*run.ReleaseInfo {
+	var releases []*run.ReleaseInfo
+	for _, step := range p.steps {
+		releases = append(releases, deployedReleases(step)...)
+	}
+	return releases
+}
@@ -0,0 +1,150 @@
+package helm
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/run"
+)
+
+type MetricsTestSuite struct {
+	suite.Suite
+	pushgateway *httptest.Server
+	method      string
+	path        string
+	contentType string
+	body        string
+	status      int
+}
+
+func (suite *MetricsTestSuite) BeforeTest(_, _ string) {
+	suite.method, suite.path, suite.contentType, suite.body = "", "", "", ""
+	suite.status = http.StatusOK
+	suite.pushgateway = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		suite.Require().NoError(err)
+		suite.method, suite.path, suite.contentType, suite.body = r.Method, r.URL.Path, r.Header.Get("Content-Type"),
+			string(body)
+		w.WriteHeader(suite.status)
+	}))
+}
+
+func (suite *MetricsTestSuite) AfterTest(_, _ string) {
+	suite.pushgateway.Close()
+}
+
+func TestMetricsTestSuite(t *testing.T) {
+	suite.Run(t, new(MetricsTestSuite))
+}
+
+func (suite *MetricsTestSuite) TestMetrics() {
+	p := &Plan{cfg: env.Config{
+		Release:      "minas-tirith",
+		Namespace:    "gondor",
+		ChartVersion: "3.0.0",
+		DroneRepo:    "denethor/\"white-city\"",
+	}}
+	report := &Report{Steps: []StepReport{
+		{Type: "InitKube", Execute: 250 * time.Millisecond, Outcome: outcomeSucceeded},
+		{Type: "Upgrade", Execute: 41500 * time.Millisecond, Outcome: outcomeFailed},
+		{Type: "ExportStatus", Outcome: outcomeNotRun},
+	}}
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+
+	metrics := p.metrics(report, nil, start, 42*time.Second, errors.New("the beacons are lit"))
+	common := `chart_version="3.0.0",namespace="gondor",release="minas-tirith",repo="denethor/\"white-city\""`
+	suite.Equal(""+
+		"# HELP drone_helm3_deploy_timestamp_seconds When the deployment started, as a Unix timestamp.\n"+
+		"# TYPE drone_helm3_deploy_timestamp_seconds gauge\n"+
+		"drone_helm3_deploy_timestamp_seconds{"+common+"} 1792404000\n"+
+		"# HELP drone_helm3_deploy_duration_seconds How long the deployment took.\n"+
+		"# TYPE drone_helm3_deploy_duration_seconds gauge\n"+
+		"drone_helm3_deploy_duration_seconds{"+common+"} 42\n"+
+		"# HELP drone_helm3_deploy_success Whether the deployment succeeded (1) or failed (0).\n"+
+		"# TYPE drone_helm3_deploy_success gauge\n"+
+		"drone_helm3_deploy_success{"+common+"} 0\n"+
+		"# HELP drone_helm3_step_duration_seconds How long each step took to execute.\n"+
+		"# TYPE drone_helm3_step_duration_seconds gauge\n"+
+		`drone_helm3_step_duration_seconds{chart_version="3.0.0",index="0",namespace="gondor",release="minas-tirith",`+
+		`repo="denethor/\"white-city\"",step="InitKube"} 0.25`+"\n"+
+		`drone_helm3_step_duration_seconds{chart_version="3.0.0",index="1",namespace="gondor",release="minas-tirith",`+
+		`repo="denethor/\"white-city\"",step="Upgrade"} 41.5`+"\n", metrics)
+}
+
+func (suite *MetricsTestSuite) TestReleaseRevisions() {
+	p := &Plan{cfg: env.Config{DroneRepo: "elessar/reunited-kingdom"}}
+	releases := []*run.ReleaseInfo{
+		{Name: "annuminas", Namespace: "arnor", Revision: 7, ChartVersion: "1.2.3"},
+		{Name: "minas-tirith", Namespace: "gondor", Revision: 12, ChartVersion: "4.5.6"},
+	}
+
+	metrics := p.metrics(&Report{}, releases, time.Now(), time.Second, nil)
+	suite.Contains(metrics, `drone_helm3_deploy_success{chart_version="",namespace="",release="",`+
+		`repo="elessar/reunited-kingdom"} 1`)
+	suite.Contains(metrics, ""+
+		"# TYPE drone_helm3_release_revision gauge\n"+
+		`drone_helm3_release_revision{chart_version="1.2.3",namespace="arnor",release="annuminas",`+
+		`repo="elessar/reunited-kingdom"} 7`+"\n"+
+		`drone_helm3_release_revision{chart_version="4.5.6",namespace="gondor",release="minas-tirith",`+
+		`repo="elessar/reunited-kingdom"} 12`+"\n")
+
+	p.cfg.ChartVersion = "0.1.0"
+	metrics = p.metrics(&Report{}, releases[:1], time.Now(), time.Second, nil)
+	suite.Contains(metrics, `drone_helm3_deploy_success{chart_version="1.2.3"`,
+		"a single release's deployed chart version should be used")
+}
+
+func (suite *MetricsTestSuite) TestExecutePushesMetrics() {
+	p := &Plan{
+		cfg: env.Config{
+			PushgatewayURL: suite.pushgateway.URL + "/",
+			Release:        "edoras",
+			DroneRepo:      "theoden/rohan",
+			Stderr:         &strings.Builder{},
+		},
+		steps: []Step{&funcStep{execute: func() error { return nil }}},
+	}
+	_, err := p.Execute()
+	suite.Require().NoError(err)
+
+	suite.Equal(http.MethodPut, suite.method)
+	suite.Equal("/metrics/job/drone-helm3/repo@base64/dGhlb2Rlbi9yb2hhbg/release@base64/ZWRvcmFz", suite.path)
+	suite.Equal("text/plain; version=0.0.4", suite.contentType)
+	suite.Contains(suite.body, `drone_helm3_deploy_success{chart_version="",namespace="",release="edoras",`+
+		`repo="theoden/rohan"} 1`)
+	suite.Contains(suite.body, `step="funcStep"`)
+
+	p.cfg.Namespace, p.cfg.Environment = "rohan", "production"
+	_, err = p.Execute()
+	suite.Require().NoError(err)
+	suite.Equal("/metrics/job/drone-helm3/repo@base64/dGhlb2Rlbi9yb2hhbg/release@base64/ZWRvcmFz"+
+		"/namespace@base64/cm9oYW4/environment@base64/cHJvZHVjdGlvbg", suite.path,
+		"deploying the release to another namespace or environment shouldn't replace its metrics")
+}
+
+func (suite *MetricsTestSuite) TestPushFailureIsAWarning() {
+	suite.status = http.StatusBadRequest
+	stderr := &strings.Builder{}
+	p := &Plan{
+		cfg:   env.Config{PushgatewayURL: suite.pushgateway.URL, Stderr: stderr},
+		steps: []Step{&funcStep{execute: func() error { return nil }}},
+	}
+	_, err := p.Execute()
+	suite.NoError(err)
+	suite.Contains(stderr.String(), "Warning: could not push metrics: pushgateway responded 400 Bad Request")
+}
+
+func (suite *MetricsTestSuite) TestNoPushgateway() {
+	p := &Plan{steps: []Step{&funcStep{execute: func() error { return nil }}}}
+	_, err := p.Execute()
+	suite.NoError(err)
+	suite.Equal("", suite.method)
+}
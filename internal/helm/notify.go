@@ -0,0 +1,256 @@
+package helm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
+	"github.com/pelotech/drone-helm3/internal/run"
+)
+
+var (
+	notifyAttempts = 3
+	notifyBackoff  = time.Second
+	notifyTimeout  = 10 * time.Second
+
+	notifyFuncs = template.FuncMap{
+		"json": func(value interface{}) (string, error) {
+			encoded, err := json.Marshal(value)
+			return string(encoded), err
+		},
+	}
+)
+
+// A notification is the payload sent to webhooks, and the data given to their templates.
+type notification struct {
+	Status        string             `json:"status"`
+	Release       string             `json:"release"`
+	Namespace     string             `json:"namespace"`
+	Chart         string             `json:"chart"`
+	Revision      int                `json:"revision,omitempty"`
+	Error         string             `json:"error,omitempty"`
+	ErrorCategory string             `json:"errorCategory,omitempty"`
+	Releases      []*run.ReleaseInfo `json:"releases,omitempty"`
+	Build         buildInfo          `json:"build"`
+
+	releaseCount int // the number of releases that were to be deployed, if there were several
+}
+
+type buildInfo struct {
+	Number string `json:"number"`
+	Link   string `json:"link"`
+	Repo   string `json:"repo"`
+	Event  string `json:"event"`
+}
+
+// A webhook is a URL to notify, and the function that writes its body.
+type webhook struct {
+	url  string
+	body func(notification) ([]byte, error)
+}
+
+// newWebhooks prepares the webhooks that the Config asks for, parsing their templates.
+func newWebhooks(settings env.Webhooks) ([]webhook, error) {
+	webhooks := make([]webhook, 0, len(settings))
+	for i, setting := range settings {
+		hook := webhook{url: setting.URL}
+		switch setting.Preset {
+		case env.PresetSlack:
+			hook.body = slackBody
+		case env.PresetTeams:
+			hook.body = teamsBody
+		case env.PresetJSON:
+			hook.body = jsonBody
+		default:
+			tmpl, err := template.New(fmt.Sprintf("notify_webhooks[%d]", i)).Funcs(notifyFuncs).Parse(setting.Template)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse notify_webhooks[%d] template: %w", i, err)
+			}
+			hook.body = templateBody(tmpl)
+		}
+		webhooks = append(webhooks, hook)
+	}
+	return webhooks, nil
+}
+
+// notify tells each of the webhooks how the plan went. Webhooks that can't be notified are reported; the error is
+// only returned if the Config says notifications must succeed.
+func (p *Plan) notify(planErr error) error {
+	if len(p.webhooks) == 0 {
+		return nil
+	}
+	n := p.notification(planErr)
+	var failed []string
+	for _, hook := range p.webhooks {
+		if err := hook.send(n); err != nil {
+			logging.Warnf(p.cfg.Stderr, "could not notify %s: %s", hook.host(), err)
+			failed = append(failed, hook.host())
+		}
+	}
+	if len(failed) > 0 && p.cfg.NotifyStrict {
+		return fmt.Errorf("could not notify %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (p *Plan) notification(planErr error) notification {
+	n := notification{
+		Status:    outcomeSucceeded,
+		Release:   p.cfg.Release,
+		Namespace: p.cfg.Namespace,
+		Chart:     p.cfg.Chart,
+		Releases:  p.deployedReleases(),
+		Build: buildInfo{
+			Number: p.cfg.DroneBuildNumber,
+			Link:   p.cfg.DroneBuildLink,
+			Repo:   p.cfg.DroneRepo,
+			Event:  p.cfg.DroneEvent,
+		},
+		releaseCount: len(p.cfg.Releases),
+	}
+	if len(n.Releases) == 1 {
+		n.Revision = n.Releases[0].Revision
+	}
+	if planErr != nil {
+		n.Status = outcomeFailed
+		n.Error = planErr.Error()
+		n.ErrorCategory = errorCategory(planErr)
+	}
+	return n
+}
+
+// errorCategory says what kind of problem stopped the plan, if helm's output showed what it was.
+func errorCategory(err error) string {
+	var helmErr *run.HelmError
+	if errors.As(err, &helmErr) && helmErr.Category != "" {
+		return helmErr.Category
+	}
+	return "unrecognized"
+}
+
+// subject names what was deployed, for the presets' messages.
+func (n notification) subject() string {
+	if n.Release == "" {
+		return fmt.Sprintf("%s in %s", countReleases(n.releaseCount), n.Build.Repo)
+	}
+	if n.Namespace == "" {
+		return n.Release
+	}
+	return fmt.Sprintf("%s to %s", n.Release, n.Namespace)
+}
+
+// summary describes the deployment in a sentence.
+func (n notification) summary() string {
+	if n.Status == outcomeFailed {
+		return fmt.Sprintf("Deploying %s failed (%s)", n.subject(), n.ErrorCategory)
+	}
+	if n.Revision > 0 {
+		return fmt.Sprintf("Deployed %s as revision %d", n.subject(), n.Revision)
+	}
+	return fmt.Sprintf("Deployed %s", n.subject())
+}
+
+func jsonBody(n notification) ([]byte, error) {
+	return json.Marshal(n)
+}
+
+func slackBody(n notification) ([]byte, error) {
+	icon := ":white_check_mark:"
+	if n.Status == outcomeFailed {
+		icon = ":x:"
+	}
+	text := fmt.Sprintf("%s %s", icon, n.summary())
+	if n.Build.Link != "" {
+		text += fmt.Sprintf(" <%s|build %s>", n.Build.Link, n.Build.Number)
+	}
+	if n.Error != "" {
+		text += fmt.Sprintf("\n```%s```", n.Error)
+	}
+	return json.Marshal(map[string]string{"text": text})
+}
+
+func teamsBody(n notification) ([]byte, error) {
+	card := map[string]interface{}{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    n.summary(),
+		"title":      n.summary(),
+		"themeColor": "2EB67D",
+	}
+	if n.Status == outcomeFailed {
+		card["themeColor"] = "E01E5A"
+		card["text"] = n.Error
+	}
+	if n.Build.Link != "" {
+		card["potentialAction"] = []interface{}{map[string]interface{}{
+			"@type":   "OpenUri",
+			"name":    "View build " + n.Build.Number,
+			"targets": []interface{}{map[string]string{"os": "default", "uri": n.Build.Link}},
+		}}
+	}
+	return json.Marshal(card)
+}
+
+func templateBody(tmpl *template.Template) func(notification) ([]byte, error) {
+	return func(n notification) ([]byte, error) {
+		var body bytes.Buffer
+		if err := tmpl.Execute(&body, n); err != nil {
+			return nil, err
+		}
+		return body.Bytes(), nil
+	}
+}
+
+// send posts the notification, retrying if the webhook can't be reached or has a problem of its own.
+func (w webhook) send(n notification) error {
+	body, err := w.body(n)
+	if err != nil {
+		return fmt.Errorf("could not write the notification: %w", err)
+	}
+
+	client := &http.Client{Timeout: notifyTimeout}
+	backoff := notifyBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(client, body)
+		if err == nil || !retry || attempt >= notifyAttempts {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends the body once, and says whether it's worth trying again if that fails.
+func (w webhook) post(client *http.Client, body []byte) (bool, error) {
+	resp, err := client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// the error includes the URL, which may well be a secret
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook responded %s", resp.Status)
+}
+
+// host names the webhook without giving away the rest of its URL.
+func (w webhook) host() string {
+	if u, err := url.Parse(w.url); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return "webhook"
+}
@@ -0,0 +1,243 @@
+package helm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/run"
+)
+
+type NotifyTestSuite struct {
+	suite.Suite
+	server          *httptest.Server
+	bodies          []string
+	statuses        []int
+	originalBackoff time.Duration
+}
+
+func (suite *NotifyTestSuite) BeforeTest(_, _ string) {
+	suite.bodies, suite.statuses = nil, nil
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.Equal(http.MethodPost, r.Method)
+		suite.Equal("application/json", r.Header.Get("Content-Type"))
+		body, err := ioutil.ReadAll(r.Body)
+		suite.Require().NoError(err)
+		suite.bodies = append(suite.bodies, string(body))
+
+		// respond with each of the statuses in turn, then with 200s
+		status := http.StatusOK
+		if len(suite.statuses) > 0 {
+			status, suite.statuses = suite.statuses[0], suite.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	suite.originalBackoff = notifyBackoff
+	notifyBackoff = 0
+}
+
+func (suite *NotifyTestSuite) AfterTest(_, _ string) {
+	suite.server.Close()
+	notifyBackoff = suite.originalBackoff
+}
+
+func TestNotifyTestSuite(t *testing.T) {
+	suite.Run(t, new(NotifyTestSuite))
+}
+
+// plan makes a plan with a single step, which fails with the given error if it isn't nil.
+func (suite *NotifyTestSuite) plan(cfg env.Config, stepErr error) *Plan {
+	webhooks, err := newWebhooks(cfg.NotifyWebhooks)
+	suite.Require().NoError(err)
+	if cfg.Stderr == nil {
+		cfg.Stderr = &strings.Builder{}
+	}
+	return &Plan{
+		cfg:      cfg,
+		steps:    []Step{&funcStep{execute: func() error { return stepErr }}},
+		webhooks: webhooks,
+	}
+}
+
+func (suite *NotifyTestSuite) TestJSONPayload() {
+	p := suite.plan(env.Config{
+		Release:          "helms-deep",
+		Namespace:        "rohan",
+		Chart:            "westfold/hornburg",
+		DroneBuildNumber: "3019",
+		DroneBuildLink:   "https://drone.example.com/theoden/rohan/3019",
+		DroneRepo:        "theoden/rohan",
+		DroneEvent:       "promote",
+		NotifyWebhooks:   env.Webhooks{{URL: suite.server.URL, Preset: env.PresetJSON}},
+	}, &run.HelmError{Category: "timed out waiting", Err: errors.New("exit status 1")})
+
+	_, err := p.Execute()
+	suite.Error(err)
+	suite.Require().Len(suite.bodies, 1)
+	suite.JSONEq(`{
+		"status": "failed",
+		"release": "helms-deep",
+		"namespace": "rohan",
+		"chart": "westfold/hornburg",
+		"error": "while executing *helm.funcStep step: timed out waiting: exit status 1",
+		"errorCategory": "timed out waiting",
+		"build": {
+			"number": "3019",
+			"link": "https://drone.example.com/theoden/rohan/3019",
+			"repo": "theoden/rohan",
+			"event": "promote"
+		}
+	}`, suite.bodies[0])
+}
+
+func (suite *NotifyTestSuite) TestNotification() {
+	p := suite.plan(env.Config{Release: "helms-deep"}, nil)
+	n := p.notification(nil)
+	suite.Equal(outcomeSucceeded, n.Status)
+	suite.Equal("", n.ErrorCategory)
+
+	n = p.notification(errors.New("the deeping wall is breached"))
+	suite.Equal(outcomeFailed, n.Status)
+	suite.Equal("unrecognized", n.ErrorCategory)
+}
+
+func (suite *NotifyTestSuite) TestSlackPreset() {
+	n := notification{
+		Status:    outcomeSucceeded,
+		Release:   "helms-deep",
+		Namespace: "rohan",
+		Revision:  12,
+		Build:     buildInfo{Number: "3019", Link: "https://drone.example.com/3019"},
+	}
+	body, err := slackBody(n)
+	suite.Require().NoError(err)
+	suite.JSONEq(`{"text": ":white_check_mark: Deployed helms-deep to rohan as revision 12 `+
+		`<https://drone.example.com/3019|build 3019>"}`, string(body))
+
+	n = notification{
+		Status:        outcomeFailed,
+		Error:         "immutable field: spec.selector",
+		ErrorCategory: "immutable field",
+		Build:         buildInfo{Repo: "theoden/rohan"},
+		releaseCount:  3,
+	}
+	body, err = slackBody(n)
+	suite.Require().NoError(err)
+	suite.JSONEq(`{"text": ":x: Deploying 3 releases in theoden/rohan failed (immutable field)\n`+
+		"```immutable field: spec.selector```\"}", string(body))
+}
+
+func (suite *NotifyTestSuite) TestTeamsPreset() {
+	n := notification{
+		Status:        outcomeFailed,
+		Release:       "helms-deep",
+		Error:         "forbidden by RBAC: cannot patch deployments",
+		ErrorCategory: "forbidden by RBAC",
+		Build:         buildInfo{Number: "3019", Link: "https://drone.example.com/3019"},
+	}
+	body, err := teamsBody(n)
+	suite.Require().NoError(err)
+	suite.JSONEq(`{
+		"@type": "MessageCard",
+		"@context": "https://schema.org/extensions",
+		"summary": "Deploying helms-deep failed (forbidden by RBAC)",
+		"title": "Deploying helms-deep failed (forbidden by RBAC)",
+		"themeColor": "E01E5A",
+		"text": "forbidden by RBAC: cannot patch deployments",
+		"potentialAction": [{
+			"@type": "OpenUri",
+			"name": "View build 3019",
+			"targets": [{"os": "default", "uri": "https://drone.example.com/3019"}]
+		}]
+	}`, string(body))
+}
+
+func (suite *NotifyTestSuite) TestTemplate() {
+	template := `{"msg": {{ json .Release }}, "ok": {{ eq .Status "succeeded" }}}`
+	p := suite.plan(env.Config{
+		Release:        "helms-deep",
+		NotifyWebhooks: env.Webhooks{{URL: suite.server.URL, Template: template}},
+	}, nil)
+	_, err := p.Execute()
+	suite.Require().NoError(err)
+	suite.Equal(`{"msg": "helms-deep", "ok": true}`, suite.bodies[0])
+}
+
+func (suite *NotifyTestSuite) TestTemplateErrors() {
+	_, err := newWebhooks(env.Webhooks{{URL: suite.server.URL, Template: "{{ .Release "}})
+	suite.Error(err)
+	suite.Contains(err.Error(), "could not parse notify_webhooks[0] template")
+}
+
+func (suite *NotifyTestSuite) TestRetries() {
+	suite.statuses = []int{http.StatusBadGateway, http.StatusTooManyRequests}
+	p := suite.plan(env.Config{NotifyWebhooks: env.Webhooks{{URL: suite.server.URL, Preset: env.PresetJSON}}}, nil)
+	_, err := p.Execute()
+	suite.NoError(err)
+	suite.Len(suite.bodies, 3)
+}
+
+func (suite *NotifyTestSuite) TestClientErrorsAreNotRetried() {
+	suite.statuses = []int{http.StatusNotFound}
+	stderr := &strings.Builder{}
+	p := suite.plan(env.Config{
+		NotifyWebhooks: env.Webhooks{{URL: suite.server.URL + "/secret-token", Preset: env.PresetJSON}},
+		Stderr:         stderr,
+	}, nil)
+	_, err := p.Execute()
+	suite.NoError(err, "notifications shouldn't fail the build unless notify_strict is set")
+	suite.Len(suite.bodies, 1)
+	suite.Contains(stderr.String(), fmt.Sprintf("Warning: could not notify %s: webhook responded 404 Not Found",
+		strings.TrimPrefix(suite.server.URL, "http://")))
+	suite.NotContains(stderr.String(), "secret-token")
+}
+
+func (suite *NotifyTestSuite) TestStrict() {
+	suite.statuses = []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable}
+	p := suite.plan(env.Config{
+		NotifyWebhooks: env.Webhooks{{URL: suite.server.URL, Preset: env.PresetJSON}},
+		NotifyStrict:   true,
+	}, nil)
+	_, err := p.Execute()
+	suite.Require().Error(err)
+	suite.Equal("could not notify "+strings.TrimPrefix(suite.server.URL, "http://"), err.Error())
+	suite.Len(suite.bodies, notifyAttempts)
+
+	stepErr := errors.New("the beacons are lit")
+	suite.statuses = []int{http.StatusNotFound}
+	p = suite.plan(env.Config{
+		NotifyWebhooks: env.Webhooks{{URL: suite.server.URL, Preset: env.PresetJSON}},
+		NotifyStrict:   true,
+	}, stepErr)
+	_, err = p.Execute()
+	suite.True(errors.Is(err, stepErr), "the plan's own error should take precedence")
+}
+
+func (suite *NotifyTestSuite) TestUnreachable() {
+	p := suite.plan(env.Config{
+		NotifyWebhooks: env.Webhooks{{URL: "http://127.0.0.1:1/hook?token=secret", Preset: env.PresetJSON}},
+	}, nil)
+	stderr := p.cfg.Stderr.(*strings.Builder)
+	_, err := p.Execute()
+	suite.NoError(err)
+	suite.Contains(stderr.String(), "Warning: could not notify 127.0.0.1:1: ")
+	suite.NotContains(stderr.String(), "secret")
+}
+
+func (suite *NotifyTestSuite) TestPayloadDecodes() {
+	body, err := jsonBody(notification{Status: outcomeSucceeded, Revision: 4, releaseCount: 2})
+	suite.Require().NoError(err)
+	var decoded map[string]interface{}
+	suite.Require().NoError(json.Unmarshal(body, &decoded))
+	suite.Equal(float64(4), decoded["revision"])
+	suite.NotContains(decoded, "releaseCount")
+}
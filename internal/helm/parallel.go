@@ -0,0 +1,195 @@
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
+	"github.com/pelotech/drone-helm3/internal/run"
+)
+
+// outputLock keeps buffered output from concurrent steps from being interleaved as it's written.
+var outputLock sync.Mutex
+
+// A logBuffer holds a step's output until the step has finished, then writes it out with each line labelled.
+type logBuffer struct {
+	mu     sync.Mutex
+	prefix string
+	dest   io.Writer
+	buf    bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.buf.Len() == 0 || b.dest == nil {
+		b.buf.Reset()
+		return
+	}
+
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	for _, line := range strings.Split(strings.TrimSuffix(b.buf.String(), "\n"), "\n") {
+		fmt.Fprintf(b.dest, "%s%s\n", b.prefix, line)
+	}
+	b.buf.Reset()
+}
+
+// bufferOutput returns a copy of the Config whose output is held in buffers labelled with the given name. JSON output
+// isn't buffered, since each record is written whole; its records are labelled with a field instead.
+func bufferOutput(cfg env.Config, label string) (env.Config, []*logBuffer) {
+	if logging.Structured(cfg.Stderr) {
+		fields := logging.Fields{"label": label}
+		cfg.Stdout, cfg.Stderr = logging.WithFields(cfg.Stdout, fields), logging.WithFields(cfg.Stderr, fields)
+		return cfg, nil
+	}
+	prefix := fmt.Sprintf("[%s] ", label)
+	stdout := &logBuffer{prefix: prefix, dest: cfg.Stdout}
+	stderr := &logBuffer{prefix: prefix, dest: cfg.Stderr}
+	cfg.Stdout, cfg.Stderr = stdout, stderr
+	return cfg, []*logBuffer{stdout, stderr}
+}
+
+func flushAll(buffers []*logBuffer) {
+	for _, b := range buffers {
+		b.flush()
+	}
+}
+
+// A bufferedStep is a step whose output is held in buffers, which are written out once each phase is over.
+type bufferedStep struct {
+	Step
+	buffers []*logBuffer
+}
+
+// Prepare prepares the underlying step.
+func (b *bufferedStep) Prepare() error {
+	defer flushAll(b.buffers)
+	return b.Step.Prepare()
+}
+
+// Execute executes the underlying step.
+func (b *bufferedStep) Execute() error {
+	defer flushAll(b.buffers)
+	return b.Step.Execute()
+}
+
+func (b *bufferedStep) unwrap() Step {
+	return b.Step
+}
+
+// Cleanup cleans up after the underlying step, if it needs it.
+func (b *bufferedStep) Cleanup() {
+	if c, ok := b.Step.(cleaner); ok {
+		c.Cleanup()
+	}
+}
+
+// parallelSteps runs steps that don't depend on each other, up to limit of them at a time.
+type parallelSteps struct {
+	steps []Step
+	limit int
+}
+
+// Prepare prepares each step in turn.
+func (p *parallelSteps) Prepare() error {
+	for _, step := range p.steps {
+		if err := step.Prepare(); err != nil {
+			return fmt.Errorf("while preparing %s: %w", describe(step), err)
+		}
+	}
+	return nil
+}
+
+// Execute executes all the steps, and reports every one that fails.
+func (p *parallelSteps) Execute() error {
+	errs := make([]error, len(p.steps))
+	slots := make(chan struct{}, p.limit)
+	var wg sync.WaitGroup
+	for i, step := range p.steps {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, step Step) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if err := step.Execute(); err != nil {
+				errs[i] = fmt.Errorf("while executing %s: %w", describe(step), err)
+			}
+		}(i, step)
+	}
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	switch len(failed) {
+	case 0:
+		return nil
+	case 1:
+		return failed[0]
+	default:
+		return &aggregateError{summary: fmt.Sprintf("%d steps failed", len(failed)), errs: failed}
+	}
+}
+
+// Cleanup cleans up after any of the steps that need it.
+func (p *parallelSteps) Cleanup() {
+	for _, step := range p.steps {
+		if c, ok := step.(cleaner); ok {
+			c.Cleanup()
+		}
+	}
+}
+
+// An aggregateError reports several failures at once. It unwraps to the first of them.
+type aggregateError struct {
+	summary string
+	errs    []error
+}
+
+func (e *aggregateError) Error() string {
+	messages := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.summary, strings.Join(messages, "; "))
+}
+
+func (e *aggregateError) Unwrap() error {
+	return e.errs[0]
+}
+
+// addRepos creates the steps that add the Config's chart repositories, which can be run concurrently.
+func addRepos(cfg env.Config) []Step {
+	concurrent := cfg.Parallelism > 1 && len(cfg.AddRepos) > 1
+
+	var steps []Step
+	for _, repo := range cfg.AddRepos {
+		if !concurrent {
+			steps = append(steps, run.NewAddRepo(cfg, repo))
+			continue
+		}
+		repoCfg, buffers := bufferOutput(cfg, strings.SplitN(repo, "=", 2)[0])
+		steps = append(steps, &bufferedStep{Step: run.NewAddRepo(repoCfg, repo), buffers: buffers})
+	}
+
+	if concurrent {
+		return []Step{&parallelSteps{steps: steps, limit: cfg.Parallelism}}
+	}
+	return steps
+}
@@ -0,0 +1,198 @@
+package helm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
+	"github.com/pelotech/drone-helm3/internal/run"
+)
+
+// funcStep is a Step whose behaviour is given by functions, for tests where steps must run concurrently.
+type funcStep struct {
+	execute func() error
+}
+
+func (f *funcStep) Prepare() error { return nil }
+func (f *funcStep) Execute() error { return f.execute() }
+
+type ParallelTestSuite struct {
+	suite.Suite
+}
+
+func TestParallelTestSuite(t *testing.T) {
+	suite.Run(t, new(ParallelTestSuite))
+}
+
+// rendezvous returns a function that blocks until it's been called n times, failing if that takes too long.
+func (suite *ParallelTestSuite) rendezvous(n int) func() error {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	return func() error {
+		wg.Done()
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-time.After(5 * time.Second):
+			return errors.New("steps didn't run concurrently")
+		}
+	}
+}
+
+func (suite *ParallelTestSuite) TestLogBuffer() {
+	dest := strings.Builder{}
+	buf := &logBuffer{prefix: "[rivendell] ", dest: &dest}
+	fmt.Fprint(buf, "Release \"rivendell\" has been upgraded.\nSTATUS: deployed")
+	suite.Empty(dest.String(), "output should be held until it's flushed")
+
+	buf.flush()
+	suite.Equal("[rivendell] Release \"rivendell\" has been upgraded.\n[rivendell] STATUS: deployed\n", dest.String())
+
+	buf.flush()
+	suite.Equal("[rivendell] Release \"rivendell\" has been upgraded.\n[rivendell] STATUS: deployed\n", dest.String(),
+		"flushing should empty the buffer")
+}
+
+func (suite *ParallelTestSuite) TestBufferOutput() {
+	stdout, stderr := strings.Builder{}, strings.Builder{}
+	cfg, buffers := bufferOutput(env.Config{Stdout: &stdout, Stderr: &stderr}, "lorien")
+	fmt.Fprintln(cfg.Stdout, "out")
+	fmt.Fprintln(cfg.Stderr, "err")
+	flushAll(buffers)
+	suite.Equal("[lorien] out\n", stdout.String())
+	suite.Equal("[lorien] err\n", stderr.String())
+}
+
+func (suite *ParallelTestSuite) TestBufferOutputJSON() {
+	stdout, stderr := strings.Builder{}, strings.Builder{}
+	cfg := env.Config{Stdout: &stdout, Stderr: &stderr, LogFormat: "json"}
+	var err error
+	cfg.Stdout, cfg.Stderr, err = logging.Wrap(cfg.LogFormat, cfg.Stdout, cfg.Stderr)
+	suite.Require().NoError(err)
+
+	cfg, buffers := bufferOutput(cfg, "lorien")
+	suite.Empty(buffers, "JSON records don't need buffering to keep them apart")
+	fmt.Fprintln(cfg.Stderr, "err")
+	suite.Contains(stderr.String(), `"message":"err","fields":{"label":"lorien"}}`)
+}
+
+func (suite *ParallelTestSuite) TestParallelStepsRunConcurrently() {
+	meet := suite.rendezvous(3)
+	steps := &parallelSteps{
+		steps: []Step{&funcStep{execute: meet}, &funcStep{execute: meet}, &funcStep{execute: meet}},
+		limit: 3,
+	}
+	suite.NoError(steps.Execute())
+}
+
+func (suite *ParallelTestSuite) TestParallelStepsAggregatesErrors() {
+	succeed := func() error { return nil }
+	steps := &parallelSteps{
+		steps: []Step{
+			&funcStep{execute: func() error { return errors.New("the bridge is broken") }},
+			&funcStep{execute: succeed},
+			&funcStep{execute: func() error { return errors.New("the balrog is awake") }},
+		},
+		limit: 2,
+	}
+	err := steps.Execute()
+	suite.EqualError(err, "2 steps failed: while executing *helm.funcStep step: the bridge is broken; "+
+		"while executing *helm.funcStep step: the balrog is awake")
+	suite.EqualError(errors.Unwrap(err), "while executing *helm.funcStep step: the bridge is broken")
+
+	steps.steps = steps.steps[1:]
+	suite.EqualError(steps.Execute(), "while executing *helm.funcStep step: the balrog is awake")
+}
+
+func (suite *ParallelTestSuite) TestAddRepos() {
+	cfg := env.Config{AddRepos: []string{"elves=https://elves.example.com", "dwarves=https://dwarves.example.com"}}
+	steps := addRepos(cfg)
+	suite.Require().Len(steps, 2)
+	suite.IsType(&run.AddRepo{}, steps[0])
+	suite.IsType(&run.AddRepo{}, steps[1])
+
+	cfg.Parallelism = 4
+	steps = addRepos(cfg)
+	suite.Require().Len(steps, 1)
+	suite.Require().IsType(&parallelSteps{}, steps[0])
+	parallel := steps[0].(*parallelSteps)
+	suite.Equal(4, parallel.limit)
+	suite.Require().Len(parallel.steps, 2)
+	suite.Require().IsType(&bufferedStep{}, parallel.steps[0])
+	suite.IsType(&run.AddRepo{}, parallel.steps[0].(*bufferedStep).Step)
+	suite.Equal("[elves] ", parallel.steps[0].(*bufferedStep).buffers[0].prefix)
+
+	cfg.AddRepos = cfg.AddRepos[:1]
+	steps = addRepos(cfg)
+	suite.Require().Len(steps, 1)
+	suite.IsType(&run.AddRepo{}, steps[0], "a single repo shouldn't need to be run in parallel")
+}
+
+func (suite *ParallelTestSuite) TestUpgradeReleasesBuffersOutput() {
+	cfg := env.Config{
+		SkipKubeconfig: true,
+		Parallelism:    2,
+		Releases:       env.Releases{{Release: "elrond"}, {Release: "galadriel"}},
+	}
+	steps := upgrade(cfg)
+	suite.Require().Len(steps, 2)
+	for i, name := range []string{"elrond", "galadriel"} {
+		release := steps[i].(*releaseSteps)
+		suite.Require().Len(release.buffers, 2)
+		suite.Equal(fmt.Sprintf("[%s] ", name), release.buffers[0].prefix)
+	}
+
+	cfg.Parallelism = 1
+	steps = upgrade(cfg)
+	suite.Empty(steps[0].(*releaseSteps).buffers, "output shouldn't be buffered when releases run one at a time")
+}
+
+func (suite *ParallelTestSuite) TestExecuteReleasesConcurrently() {
+	meet := suite.rendezvous(2)
+	var mu sync.Mutex
+	var finished []string
+	finish := func(name string, before func() error) func() error {
+		return func() error {
+			err := before()
+			mu.Lock()
+			defer mu.Unlock()
+			finished = append(finished, name)
+			return err
+		}
+	}
+	var sawNeeds bool
+	plan := Plan{
+		cfg: env.Config{Parallelism: 2, Stderr: &strings.Builder{}},
+		steps: []Step{
+			&releaseSteps{name: "crds", steps: []Step{&funcStep{execute: finish("crds", meet)}}},
+			&releaseSteps{name: "database", steps: []Step{&funcStep{execute: finish("database", meet)}}},
+			&releaseSteps{name: "operator", needs: []string{"crds"}, steps: []Step{&funcStep{execute: func() error {
+				mu.Lock()
+				defer mu.Unlock()
+				sawNeeds = contains(finished, "crds")
+				return nil
+			}}}},
+		},
+	}
+
+	_, err := plan.Execute()
+	suite.NoError(err)
+	suite.True(sawNeeds, "a release shouldn't start until the releases it needs have finished")
+}
+
+func (suite *ParallelTestSuite) TestNewPlanRejectsNegativeParallelism() {
+	_, err := NewPlan(env.Config{Command: "help", Parallelism: -1})
+	suite.EqualError(err, "parallelism can't be negative")
+}
@@ -4,8 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/forge"
+	"github.com/pelotech/drone-helm3/internal/logging"
 	"github.com/pelotech/drone-helm3/internal/run"
-	"os"
+	"github.com/pelotech/drone-helm3/internal/tracing"
 )
 
 const (
@@ -19,46 +21,132 @@ type Step interface {
 	Execute() error
 }
 
+// A cleaner is a Step that leaves temporary files behind, which must be removed once the plan is finished.
+type cleaner interface {
+	Cleanup()
+}
+
+// A wrapper is a Step that adds to another step's behaviour, such as buffering its output or reporting it somewhere.
+// Steps are named and described by the step they wrap.
+type wrapper interface {
+	unwrap() Step
+}
+
+// unwrap returns the step inside any wrappers around the given one.
+func unwrap(step Step) Step {
+	for {
+		w, ok := step.(wrapper)
+		if !ok {
+			return step
+		}
+		step = w.unwrap()
+	}
+}
+
 // A Plan is a series of steps to perform.
 type Plan struct {
-	steps []Step
-	cfg   env.Config
+	steps    []Step
+	cfg      env.Config
+	outcomes stepOutcomes
+	tracer   *tracing.Tracer
+	span     *tracing.Span
+	webhooks []webhook
 }
 
-// NewPlan makes a plan for running a helm operation.
+// PrepareError is returned by NewPlan when the plan's steps can't be made ready to run.
+type PrepareError struct {
+	Err error
+}
+
+func (e *PrepareError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PrepareError) Unwrap() error {
+	return e.Err
+}
+
+// NewPlan makes a plan for running a helm operation. Any error it returns is a *PrepareError.
 func NewPlan(cfg env.Config) (*Plan, error) {
-	p := Plan{
-		cfg: cfg,
+	p := &Plan{
+		cfg:    cfg,
+		tracer: tracing.New(),
 	}
+	p.span = p.tracer.Start("drone-helm3", nil, traceAttributes(cfg))
 
+	if err := p.prepare(); err != nil {
+		p.span.End(err)
+		p.exportTraces()
+		return nil, &PrepareError{Err: err}
+	}
+	return p, nil
+}
+
+func (p *Plan) prepare() error {
+	cfg := p.cfg
 	if cfg.UpdateDependencies && cfg.DependenciesAction != "" {
-		return nil, errors.New("update_dependencies is deprecated and cannot be provided together with dependencies_action")
+		return errors.New("update_dependencies is deprecated and cannot be provided together with dependencies_action")
+	}
+
+	if cfg.Parallelism < 0 {
+		return errors.New("parallelism can't be negative")
+	}
+
+	stepsMaker := determineSteps(cfg)
+	if (stepsMaker == &previewUpgrade || stepsMaker == &previewCleanup) && cfg.DronePullRequest == "" {
+		return errors.New("preview environments can only be used in pull request builds")
+	}
+
+	webhooks, err := newWebhooks(cfg.NotifyWebhooks)
+	if err != nil {
+		return err
 	}
+	p.webhooks = webhooks
 
-	p.steps = (*determineSteps(cfg))(cfg)
+	p.steps = (*stepsMaker)(cfg)
+	if err := orderReleases(p.steps); err != nil {
+		return err
+	}
 
+	defer logging.ClearStep(cfg.Stderr)
 	for i, step := range p.steps {
+		logging.SetStep(cfg.Stderr, stepName(step), i)
 		if cfg.Debug {
-			fmt.Fprintf(os.Stderr, "calling %T.Prepare (step %d)\n", step, i)
+			logging.Debugf(cfg.Stderr, "calling %T.Prepare (step %d)", step, i)
 		}
 
-		if err := step.Prepare(); err != nil {
-			err = fmt.Errorf("while preparing %T step: %w", step, err)
-			return nil, err
+		if err := traced(p.tracer, p.span, "Prepare", step, p.outcomes.prepare); err != nil {
+			p.cleanup()
+			return fmt.Errorf("while preparing %s: %w", describe(step), err)
 		}
 	}
 
-	return &p, nil
+	return nil
 }
 
 // determineSteps is primarily for the tests' convenience: it allows testing the "which stuff should
 // we do" logic without building a config that meets all the steps' requirements.
 func determineSteps(cfg env.Config) *func(env.Config) []Step {
+	if cfg.Preview && (cfg.Command == "" || cfg.Command == "upgrade") {
+		if isPreviewCleanupEvent(cfg) {
+			return &previewCleanup
+		}
+		if cfg.DroneEvent == "pull_request" {
+			return &previewUpgrade
+		}
+	}
+
 	switch cfg.Command {
 	case "upgrade":
 		return &upgrade
 	case "uninstall", "delete":
 		return &uninstall
+	case "preview-cleanup":
+		return &previewCleanup
+	case "diff":
+		return &diff
+	case "gc":
+		return &gc
 	case "lint":
 		return &lint
 	case "help":
@@ -75,29 +163,93 @@ func determineSteps(cfg env.Config) *func(env.Config) []Step {
 	}
 }
 
-// Execute runs each step in the plan, aborting and reporting on error
-func (p *Plan) Execute() error {
-	for i, step := range p.steps {
+// Execute runs each step in the plan, aborting and reporting on error. Whether or not it succeeds, it prints and
+// returns a Report on how each step went, and notifies any webhooks.
+func (p *Plan) Execute() (*Report, error) {
+	defer p.cleanup()
+
+	start := timeNow()
+	err := p.execute()
+	duration := timeNow().Sub(start)
+	p.writeSummary(err)
+	report := p.report()
+	if p.cfg.Stderr != nil {
+		report.Print(p.cfg.Stderr)
+	}
+	p.pushMetrics(report, start, duration, err)
+	if notifyErr := p.notify(err); err == nil {
+		err = notifyErr
+	}
+	p.span.End(err)
+	p.exportTraces()
+	return report, err
+}
+
+func (p *Plan) execute() error {
+	defer logging.ClearStep(p.cfg.Stderr)
+	for i := 0; i < len(p.steps); i++ {
+		// releases are run as a group, so that they can be deployed alongside each other
+		if _, ok := p.steps[i].(*releaseSteps); ok {
+			logging.SetStep(p.cfg.Stderr, "releases", i)
+			end := i
+			for end < len(p.steps) && isRelease(p.steps[end]) {
+				end++
+			}
+			if err := p.executeReleases(p.steps[i:end]); err != nil {
+				return err
+			}
+			i = end - 1
+			continue
+		}
+
+		step := p.steps[i]
+		logging.SetStep(p.cfg.Stderr, stepName(step), i)
 		if p.cfg.Debug {
-			fmt.Fprintf(p.cfg.Stderr, "calling %T.Execute (step %d)\n", step, i)
+			logging.Debugf(p.cfg.Stderr, "calling %T.Execute (step %d)", step, i)
 		}
 
-		if err := step.Execute(); err != nil {
-			return fmt.Errorf("while executing %T step: %w", step, err)
+		if err := traced(p.tracer, p.span, "Execute", step, p.outcomes.execute); err != nil {
+			return fmt.Errorf("while executing %s: %w", describe(step), err)
 		}
 	}
 
 	return nil
 }
 
+// describe names a step for error messages.
+func describe(step Step) string {
+	switch step := unwrap(step).(type) {
+	case *releaseSteps:
+		return fmt.Sprintf("release %s", step.name)
+	default:
+		return fmt.Sprintf("%T step", step)
+	}
+}
+
+// cleanup removes temporary files left behind by any of the plan's steps.
+func (p *Plan) cleanup() {
+	for _, step := range p.steps {
+		if c, ok := step.(cleaner); ok {
+			c.Cleanup()
+		}
+	}
+}
+
 var upgrade = func(cfg env.Config) []Step {
+	return withDiffComment(cfg, upgradeSteps)
+}
+
+// upgradeSteps deploys the Config's release, or each of its releases.
+func upgradeSteps(cfg env.Config) []Step {
+	if len(cfg.Releases) > 0 {
+		return upgradeReleases(cfg)
+	}
+
 	var steps []Step
 	if !cfg.SkipKubeconfig {
 		steps = append(steps, run.NewInitKube(cfg, kubeConfigTemplate, kubeConfigFile))
 	}
-	for _, repo := range cfg.AddRepos {
-		steps = append(steps, run.NewAddRepo(cfg, repo))
-	}
+	steps = append(steps, addRepos(cfg)...)
 
 	if cfg.DependenciesAction != "" {
 		steps = append(steps, run.NewDepAction(cfg))
@@ -107,7 +259,11 @@ var upgrade = func(cfg env.Config) []Step {
 		steps = append(steps, run.NewDepUpdate(cfg))
 	}
 
-	steps = append(steps, run.NewUpgrade(cfg))
+	upgrade := withDeploymentStatus(cfg, withSmokeTest(cfg, run.NewUpgrade(cfg)), forge.StateSuccess)
+	steps = append(steps, withDiagnostics(cfg, withAnnotation(cfg, upgrade)))
+	if !cfg.DryRun {
+		steps = append(steps, run.NewExportStatus(cfg, false))
+	}
 
 	return steps
 }
@@ -120,16 +276,27 @@ var uninstall = func(cfg env.Config) []Step {
 	if cfg.UpdateDependencies {
 		steps = append(steps, run.NewDepUpdate(cfg))
 	}
-	steps = append(steps, run.NewUninstall(cfg))
+	steps = append(steps, withDeploymentStatus(cfg, run.NewUninstall(cfg), forge.StateInactive))
 
 	return steps
 }
 
-var lint = func(cfg env.Config) []Step {
+var gc = func(cfg env.Config) []Step {
 	var steps []Step
-	for _, repo := range cfg.AddRepos {
-		steps = append(steps, run.NewAddRepo(cfg, repo))
+	if !cfg.SkipKubeconfig {
+		steps = append(steps, run.NewInitKube(cfg, kubeConfigTemplate, kubeConfigFile))
 	}
+	steps = append(steps, run.NewGC(cfg))
+	return steps
+}
+
+var lint = func(cfg env.Config) []Step {
+	if len(cfg.Releases) > 0 {
+		return lintReleases(cfg)
+	}
+
+	var steps []Step
+	steps = append(steps, addRepos(cfg)...)
 	if cfg.UpdateDependencies {
 		steps = append(steps, run.NewDepUpdate(cfg))
 	}
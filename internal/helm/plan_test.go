@@ -74,6 +74,7 @@ func (suite *PlanTestSuite) TestNewPlanAbortsOnError() {
 	_, err := NewPlan(cfg)
 	suite.Require().NotNil(err)
 	suite.EqualError(err, "while preparing *helm.MockStep step: I'm starry Dave, aye, cat blew that")
+	suite.IsType(&PrepareError{}, err)
 }
 
 func (suite *PlanTestSuite) TestExecute() {
@@ -93,7 +94,8 @@ func (suite *PlanTestSuite) TestExecute() {
 		Execute().
 		Times(1)
 
-	suite.NoError(plan.Execute())
+	_, err := plan.Execute()
+	suite.NoError(err)
 }
 
 func (suite *PlanTestSuite) TestExecuteAbortsOnError() {
@@ -111,20 +113,93 @@ func (suite *PlanTestSuite) TestExecuteAbortsOnError() {
 		Times(1).
 		Return(fmt.Errorf("oh, he'll gnaw"))
 
-	err := plan.Execute()
+	_, err := plan.Execute()
 	suite.EqualError(err, "while executing *helm.MockStep step: oh, he'll gnaw")
 }
 
+func (suite *PlanTestSuite) TestExecuteCleansUp() {
+	ctrl := gomock.NewController(suite.T())
+	defer ctrl.Finish()
+	stepOne := NewMockStep(ctrl)
+	stepTwo := &cleanableStep{MockStep: NewMockStep(ctrl)}
+
+	plan := Plan{
+		steps: []Step{stepOne, stepTwo},
+	}
+
+	stepOne.EXPECT().
+		Execute().
+		Return(fmt.Errorf("ohm mani padme hum"))
+
+	_, err := plan.Execute()
+	suite.Error(err)
+	suite.True(stepTwo.cleaned, "steps should be cleaned up even if they weren't executed")
+}
+
+func (suite *PlanTestSuite) TestNewPlanCleansUpOnError() {
+	ctrl := gomock.NewController(suite.T())
+	defer ctrl.Finish()
+	stepOne := &cleanableStep{MockStep: NewMockStep(ctrl)}
+	stepTwo := NewMockStep(ctrl)
+
+	origHelp := help
+	help = func(cfg env.Config) []Step {
+		return []Step{stepOne, stepTwo}
+	}
+	defer func() { help = origHelp }()
+
+	stepOne.EXPECT().Prepare()
+	stepTwo.EXPECT().
+		Prepare().
+		Return(fmt.Errorf("nobody expects the spanish inquisition"))
+
+	_, err := NewPlan(env.Config{Command: "help"})
+	suite.Error(err)
+	suite.True(stepOne.cleaned)
+}
+
+func (suite *PlanTestSuite) TestWrappedStepsAreDescribedByTheirStep() {
+	upgrade := run.NewUpgrade(env.Config{Chart: "at40", Release: "only-human"})
+	suite.Require().NoError(upgrade.Prepare())
+	var wrapped Step = &diagnosedStep{Step: &annotatedStep{Step: &trackedStep{Step: &bufferedStep{Step: upgrade}}}}
+
+	suite.Same(upgrade, unwrap(wrapped))
+	suite.Equal("*run.Upgrade step", describe(wrapped))
+	suite.Equal("Upgrade", stepName(wrapped))
+	suite.Equal(upgrade.Command(), commandOf(wrapped))
+}
+
 func (suite *PlanTestSuite) TestUpgrade() {
 	steps := upgrade(env.Config{})
-	suite.Require().Equal(2, len(steps), "upgrade should return 2 steps")
+	suite.Require().Equal(3, len(steps), "upgrade should return 3 steps")
 	suite.IsType(&run.InitKube{}, steps[0])
 	suite.IsType(&run.Upgrade{}, steps[1])
+	suite.IsType(&run.ExportStatus{}, steps[2])
+}
+
+func (suite *PlanTestSuite) TestUpgradeWithDryRun() {
+	steps := upgrade(env.Config{DryRun: true})
+	suite.Require().Equal(2, len(steps), "a dry run's status shouldn't be exported")
+	suite.IsType(&run.Upgrade{}, steps[1])
+}
+
+func (suite *PlanTestSuite) TestUpgradeWithSmokeTests() {
+	cfg := env.Config{SmokeTests: env.SmokeTests{{URL: "https://shire.example.com", Status: 200}}}
+	steps := upgrade(cfg)
+	suite.Require().Equal(3, len(steps), "the smoke test should be part of the upgrade step")
+	suite.Require().IsType(&smokeTestedStep{}, steps[1])
+	suite.IsType(&run.Upgrade{}, steps[1].(*smokeTestedStep).Step)
+	suite.IsType(&run.ExportStatus{}, steps[2])
+
+	cfg.DryRun = true
+	steps = upgrade(cfg)
+	suite.Require().Equal(2, len(steps))
+	suite.IsType(&run.Upgrade{}, steps[1], "a dry run has nothing to smoke test")
 }
 
 func (suite *PlanTestSuite) TestUpgradeWithSkipKubeconfig() {
 	steps := upgrade(env.Config{SkipKubeconfig: true})
-	suite.Require().Equal(1, len(steps), "upgrade should return 1 step")
+	suite.Require().Equal(2, len(steps), "upgrade should return 2 steps")
 	suite.IsType(&run.Upgrade{}, steps[0])
 }
 
@@ -133,7 +208,7 @@ func (suite *PlanTestSuite) TestUpgradeWithUpdateDependencies() {
 		UpdateDependencies: true,
 	}
 	steps := upgrade(cfg)
-	suite.Require().Equal(3, len(steps), "upgrade should have a third step when DepUpdate is true")
+	suite.Require().Equal(4, len(steps), "upgrade should have an extra step when DepUpdate is true")
 	suite.IsType(&run.InitKube{}, steps[0])
 	suite.IsType(&run.DepUpdate{}, steps[1])
 }
@@ -235,6 +310,30 @@ func (suite *PlanTestSuite) TestDeterminePlanDeleteFromDroneEvent() {
 	suite.Same(&uninstall, stepsMaker)
 }
 
+func (suite *PlanTestSuite) TestDeterminePlanGCCommand() {
+	cfg := env.Config{
+		Command: "gc",
+	}
+	stepsMaker := determineSteps(cfg)
+	suite.Same(&gc, stepsMaker)
+}
+
+func (suite *PlanTestSuite) TestGC() {
+	cfg := env.Config{
+		GCPattern: "-pr-[0-9]+$",
+		MaxAge:    "7d",
+	}
+	steps := gc(cfg)
+	suite.Require().Equal(2, len(steps), "gc should return 2 steps")
+	suite.IsType(&run.InitKube{}, steps[0])
+	suite.IsType(&run.GC{}, steps[1])
+
+	cfg.SkipKubeconfig = true
+	steps = gc(cfg)
+	suite.Require().Equal(1, len(steps), "gc shouldn't create a kubeconfig when skip_kubeconfig is set")
+	suite.IsType(&run.GC{}, steps[0])
+}
+
 func (suite *PlanTestSuite) TestDeterminePlanLintCommand() {
 	cfg := env.Config{
 		Command: "lint",
@@ -252,3 +351,12 @@ func (suite *PlanTestSuite) TestDeterminePlanHelpCommand() {
 	stepsMaker := determineSteps(cfg)
 	suite.Same(&help, stepsMaker)
 }
+
+type cleanableStep struct {
+	*MockStep
+	cleaned bool
+}
+
+func (c *cleanableStep) Cleanup() {
+	c.cleaned = true
+}
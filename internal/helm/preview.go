@@ -0,0 +1,111 @@
+package helm
+
+import (
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/forge"
+	"github.com/pelotech/drone-helm3/internal/run"
+)
+
+const (
+	defaultPreviewCleanupEvent = "pull_request:closed"
+	maxReleaseNameLength       = 53
+	maxNamespaceLength         = 63
+)
+
+var previewUpgrade = func(cfg env.Config) []Step {
+	cfg = previewConfig(cfg)
+	cfg.CreateNamespace = true
+	return upgrade(cfg)
+}
+
+var previewCleanup = func(cfg env.Config) []Step {
+	cfg = previewConfig(cfg)
+	if len(cfg.Releases) > 0 {
+		return cleanupPreviewReleases(cfg)
+	}
+	steps := uninstall(cfg)
+	return append(steps, run.NewDeleteNamespace(cfg, kubeConfigFile))
+}
+
+// cleanupPreviewReleases uninstalls each of a preview's releases, then deletes the namespaces they were in.
+func cleanupPreviewReleases(cfg env.Config) []Step {
+	var steps []Step
+	if !cfg.SkipKubeconfig {
+		steps = append(steps, run.NewInitKube(cfg, kubeConfigTemplate, kubeConfigFile))
+	}
+
+	var namespaces []string
+	seen := make(map[string]bool)
+	for _, release := range cfg.Releases {
+		releaseCfg := cfg.ForRelease(release)
+		steps = append(steps, withDeploymentStatus(releaseCfg, run.NewUninstall(releaseCfg), forge.StateInactive))
+		if !seen[releaseCfg.Namespace] {
+			seen[releaseCfg.Namespace] = true
+			namespaces = append(namespaces, releaseCfg.Namespace)
+		}
+	}
+	for _, namespace := range namespaces {
+		namespaceCfg := cfg
+		namespaceCfg.Namespace = namespace
+		steps = append(steps, run.NewDeleteNamespace(namespaceCfg, kubeConfigFile))
+	}
+	return steps
+}
+
+// previewConfig points the config at the releases and namespaces that belong to the build's pull request. The
+// namespace is based on the namespace setting if there is one, or the release name otherwise. Each entry in the
+// releases setting is renamed the same way, along with the releases it needs and any namespace of its own; an entry
+// without a namespace gets one based on its name, unless there's a namespace setting for it to share.
+func previewConfig(cfg env.Config) env.Config {
+	suffix := "-pr-" + cfg.DronePullRequest
+
+	namespace := cfg.Namespace
+	if namespace == "" {
+		namespace = cfg.Release
+	}
+	if namespace != "" {
+		cfg.Namespace = previewName(maxNamespaceLength, namespace, suffix)
+	}
+	if cfg.Release != "" {
+		cfg.Release = previewName(maxReleaseNameLength, cfg.Release, suffix)
+	}
+
+	if cfg.Releases != nil {
+		// the entries are copied, so that the original config's releases aren't renamed too
+		releases := make(env.Releases, len(cfg.Releases))
+		for i, release := range cfg.Releases {
+			switch {
+			case release.Namespace != "":
+				release.Namespace = previewName(maxNamespaceLength, release.Namespace, suffix)
+			case cfg.Namespace == "":
+				release.Namespace = previewName(maxNamespaceLength, release.Release, suffix)
+			}
+			release.Release = previewName(maxReleaseNameLength, release.Release, suffix)
+			if release.Needs != nil {
+				needs := make([]string, len(release.Needs))
+				for j, need := range release.Needs {
+					needs[j] = previewName(maxReleaseNameLength, need, suffix)
+				}
+				release.Needs = needs
+			}
+			releases[i] = release
+		}
+		cfg.Releases = releases
+	}
+	return cfg
+}
+
+// previewName adds the suffix to a name, truncating the name if necessary so that the result fits in maxLength.
+func previewName(maxLength int, name, suffix string) string {
+	return env.TruncateName(maxLength-len(suffix), name) + suffix
+}
+
+// isPreviewCleanupEvent reports whether the build was triggered by the event that should remove a preview environment.
+// The preview_cleanup_event setting is either a drone event, or an event and action separated by a colon.
+func isPreviewCleanupEvent(cfg env.Config) bool {
+	want := cfg.PreviewCleanupOn
+	if want == "" {
+		want = defaultPreviewCleanupEvent
+	}
+	return want == cfg.DroneEvent || want == cfg.DroneEvent+":"+cfg.DroneBuildAction
+}
@@ -0,0 +1,162 @@
+package helm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/run"
+)
+
+type PreviewTestSuite struct {
+	suite.Suite
+}
+
+func TestPreviewTestSuite(t *testing.T) {
+	suite.Run(t, new(PreviewTestSuite))
+}
+
+func (suite *PreviewTestSuite) TestDeterminePlanPreviewUpgrade() {
+	cfg := env.Config{
+		Preview:    true,
+		DroneEvent: "pull_request",
+	}
+	suite.Same(&previewUpgrade, determineSteps(cfg))
+
+	cfg.Command = "upgrade"
+	suite.Same(&previewUpgrade, determineSteps(cfg))
+
+	cfg.Preview = false
+	suite.Same(&upgrade, determineSteps(cfg), "pull requests should deploy normally unless preview is set")
+
+	cfg.Preview = true
+	cfg.DroneEvent = "push"
+	suite.Same(&upgrade, determineSteps(cfg), "only pull requests should be previewed")
+}
+
+func (suite *PreviewTestSuite) TestDeterminePlanPreviewCleanup() {
+	cfg := env.Config{
+		Preview:          true,
+		DroneEvent:       "pull_request",
+		DroneBuildAction: "closed",
+	}
+	suite.Same(&previewCleanup, determineSteps(cfg))
+
+	cfg = env.Config{
+		Preview:          true,
+		PreviewCleanupOn: "custom",
+		DroneEvent:       "custom",
+	}
+	suite.Same(&previewCleanup, determineSteps(cfg))
+
+	cfg = env.Config{
+		Command: "preview-cleanup",
+	}
+	suite.Same(&previewCleanup, determineSteps(cfg))
+
+	cfg = env.Config{
+		Command:          "lint",
+		Preview:          true,
+		DroneEvent:       "pull_request",
+		DroneBuildAction: "closed",
+	}
+	suite.Same(&lint, determineSteps(cfg), "an explicit mode other than upgrade should take priority")
+}
+
+func (suite *PreviewTestSuite) TestPreviewConfig() {
+	cfg := previewConfig(env.Config{
+		Release:          "frodo",
+		DronePullRequest: "123",
+	})
+	suite.Equal("frodo-pr-123", cfg.Release)
+	suite.Equal("frodo-pr-123", cfg.Namespace)
+
+	cfg = previewConfig(env.Config{
+		Release:          "frodo",
+		Namespace:        "shire",
+		DronePullRequest: "123",
+	})
+	suite.Equal("frodo-pr-123", cfg.Release)
+	suite.Equal("shire-pr-123", cfg.Namespace)
+
+	cfg = previewConfig(env.Config{
+		Release:          strings.Repeat("baggins-", 10),
+		DronePullRequest: "123",
+	})
+	suite.Len(cfg.Release, 53)
+	suite.True(strings.HasSuffix(cfg.Release, "-pr-123"), "the pull request number should survive truncation")
+	suite.Len(cfg.Namespace, 62)
+}
+
+func (suite *PreviewTestSuite) TestPreviewConfigReleases() {
+	releases := env.Releases{
+		{Release: "frodo"},
+		{Release: "sam", Namespace: "shire", Needs: []string{"frodo"}},
+	}
+	cfg := previewConfig(env.Config{Releases: releases, DronePullRequest: "123"})
+	suite.Equal(env.Releases{
+		{Release: "frodo-pr-123", Namespace: "frodo-pr-123"},
+		{Release: "sam-pr-123", Namespace: "shire-pr-123", Needs: []string{"frodo-pr-123"}},
+	}, cfg.Releases)
+	suite.Equal("frodo", releases[0].Release, "the original releases shouldn't be renamed")
+
+	cfg = previewConfig(env.Config{Releases: releases, Namespace: "mordor", DronePullRequest: "123"})
+	suite.Equal("mordor-pr-123", cfg.Namespace)
+	suite.Equal("", cfg.Releases[0].Namespace, "releases without a namespace should share the namespace setting")
+	suite.Equal("shire-pr-123", cfg.Releases[1].Namespace)
+}
+
+func (suite *PreviewTestSuite) TestPreviewUpgradeReleases() {
+	steps := previewUpgrade(env.Config{
+		Releases:         env.Releases{{Release: "frodo"}, {Release: "sam", Needs: []string{"frodo"}}},
+		DronePullRequest: "7",
+	})
+	suite.Require().Equal(3, len(steps))
+	suite.IsType(&run.InitKube{}, steps[0])
+	for i, name := range []string{"frodo-pr-7", "sam-pr-7"} {
+		group := steps[i+1].(*releaseSteps)
+		suite.Equal(name, group.name)
+		suite.Equal(name, group.attributes["helm.release"])
+		suite.Equal(name, group.attributes["helm.namespace"])
+	}
+	suite.Equal([]string{"frodo-pr-7"}, steps[2].(*releaseSteps).needs)
+}
+
+func (suite *PreviewTestSuite) TestPreviewCleanupReleases() {
+	steps := previewCleanup(env.Config{
+		Releases:         env.Releases{{Release: "frodo"}, {Release: "sam"}, {Release: "pippin", Namespace: "shire"}},
+		Namespace:        "shire",
+		DronePullRequest: "7",
+	})
+	suite.Require().Equal(5, len(steps))
+	suite.IsType(&run.InitKube{}, steps[0])
+	for i, release := range []string{"frodo-pr-7", "sam-pr-7", "pippin-pr-7"} {
+		uninstall := steps[i+1].(*run.Uninstall)
+		suite.Require().NoError(uninstall.Prepare())
+		suite.True(strings.HasSuffix(uninstall.Command(), "helm --namespace shire-pr-7 uninstall "+release))
+	}
+	suite.IsType(&run.DeleteNamespace{}, steps[4], "the releases' shared namespace should be deleted once")
+}
+
+func (suite *PreviewTestSuite) TestPreviewUpgrade() {
+	steps := previewUpgrade(env.Config{Release: "sam", DronePullRequest: "7"})
+	suite.Require().Equal(3, len(steps))
+	suite.IsType(&run.InitKube{}, steps[0])
+	suite.IsType(&run.Upgrade{}, steps[1])
+	suite.IsType(&run.ExportStatus{}, steps[2])
+}
+
+func (suite *PreviewTestSuite) TestPreviewCleanup() {
+	steps := previewCleanup(env.Config{Release: "sam", DronePullRequest: "7"})
+	suite.Require().Equal(3, len(steps))
+	suite.IsType(&run.InitKube{}, steps[0])
+	suite.IsType(&run.Uninstall{}, steps[1])
+	suite.IsType(&run.DeleteNamespace{}, steps[2])
+}
+
+func (suite *PreviewTestSuite) TestNewPlanRequiresPullRequest() {
+	_, err := NewPlan(env.Config{Command: "preview-cleanup", Release: "sam"})
+	suite.EqualError(err, "preview environments can only be used in pull request builds")
+}
@@ -0,0 +1,368 @@
+package helm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/forge"
+	"github.com/pelotech/drone-helm3/internal/logging"
+	"github.com/pelotech/drone-helm3/internal/run"
+	"github.com/pelotech/drone-helm3/internal/tracing"
+)
+
+// releaseSteps groups the steps that deploy one of several releases, so that a failure can be attributed to its
+// release without stopping the others.
+type releaseSteps struct {
+	name     string
+	needs    []string
+	steps    []Step
+	buffers  []*logBuffer
+	debug    bool
+	stderr   io.Writer
+	outcomes stepOutcomes
+
+	// the release's span, when it's traced, and its attributes
+	tracer     *tracing.Tracer
+	span       *tracing.Span
+	attributes map[string]string
+}
+
+// Prepare prepares each of the release's steps.
+func (r *releaseSteps) Prepare() error {
+	defer flushAll(r.buffers)
+	for i, step := range r.steps {
+		if r.debug {
+			logging.Debugf(r.stderr, "calling %T.Prepare (release %s, step %d)", step, r.name, i)
+		}
+		if err := traced(r.tracer, r.span, "Prepare", step, r.outcomes.prepare); err != nil {
+			return fmt.Errorf("while preparing %s: %w", describe(step), err)
+		}
+	}
+	return nil
+}
+
+// Execute executes each of the release's steps, stopping at the first error.
+func (r *releaseSteps) Execute() error {
+	defer flushAll(r.buffers)
+	for i, step := range r.steps {
+		if r.debug {
+			logging.Debugf(r.stderr, "calling %T.Execute (release %s, step %d)", step, r.name, i)
+		}
+		if err := traced(r.tracer, r.span, "Execute", step, r.outcomes.execute); err != nil {
+			return fmt.Errorf("while executing %s: %w", describe(step), err)
+		}
+	}
+	return nil
+}
+
+// Cleanup cleans up after any of the release's steps that need it.
+func (r *releaseSteps) Cleanup() {
+	for _, step := range r.steps {
+		if c, ok := step.(cleaner); ok {
+			c.Cleanup()
+		}
+	}
+}
+
+// lintReleases lints each of the Config's releases' charts.
+func lintReleases(cfg env.Config) []Step {
+	steps := addRepos(cfg)
+	for _, release := range cfg.Releases {
+		group, releaseCfg := newReleaseSteps(cfg, release)
+		if cfg.UpdateDependencies {
+			group.steps = append(group.steps, run.NewDepUpdate(releaseCfg))
+		}
+		group.steps = append(group.steps, run.NewLint(releaseCfg))
+		steps = append(steps, group)
+	}
+	return steps
+}
+
+// diffReleases compares each of the Config's releases with its chart, returning the Diff steps along with the rest.
+func diffReleases(cfg env.Config) ([]Step, []differ) {
+	var steps []Step
+	if !cfg.SkipKubeconfig {
+		steps = append(steps, run.NewInitKube(cfg, kubeConfigTemplate, kubeConfigFile))
+	}
+	steps = append(steps, addRepos(cfg)...)
+
+	var diffs []differ
+	for _, release := range cfg.Releases {
+		group, releaseCfg := newReleaseSteps(cfg, release)
+		if cfg.DependenciesAction != "" {
+			group.steps = append(group.steps, run.NewDepAction(releaseCfg))
+		}
+		if cfg.UpdateDependencies {
+			group.steps = append(group.steps, run.NewDepUpdate(releaseCfg))
+		}
+		d := run.NewDiff(releaseCfg)
+		group.steps = append(group.steps, d)
+		diffs = append(diffs, d)
+		steps = append(steps, group)
+	}
+	return steps, diffs
+}
+
+// newReleaseSteps creates an empty group for the given release, along with the Config its steps should use. When
+// releases may run concurrently, their output is buffered so that it isn't interleaved.
+func newReleaseSteps(cfg env.Config, release env.Release) (*releaseSteps, env.Config) {
+	group := &releaseSteps{
+		name:  release.Release,
+		needs: release.Needs,
+		debug: cfg.Debug,
+	}
+	releaseCfg := cfg.ForRelease(release)
+	if cfg.Parallelism > 1 && len(cfg.Releases) > 1 {
+		releaseCfg, group.buffers = bufferOutput(releaseCfg, release.Release)
+	}
+	group.stderr = releaseCfg.Stderr
+	group.attributes = map[string]string{
+		"helm.release":   releaseCfg.Release,
+		"helm.namespace": releaseCfg.Namespace,
+		"helm.chart":     releaseCfg.Chart,
+	}
+	return group, releaseCfg
+}
+
+// upgradeReleases deploys each of the Config's releases, sharing a kubeconfig and chart repositories between them.
+func upgradeReleases(cfg env.Config) []Step {
+	var steps []Step
+	if !cfg.SkipKubeconfig {
+		steps = append(steps, run.NewInitKube(cfg, kubeConfigTemplate, kubeConfigFile))
+	}
+	steps = append(steps, addRepos(cfg)...)
+
+	for _, release := range cfg.Releases {
+		group, releaseCfg := newReleaseSteps(cfg, release)
+		if cfg.DependenciesAction != "" {
+			group.steps = append(group.steps, run.NewDepAction(releaseCfg))
+		}
+		if cfg.UpdateDependencies {
+			group.steps = append(group.steps, run.NewDepUpdate(releaseCfg))
+		}
+		upgrade := withSmokeTest(releaseCfg, run.NewUpgrade(releaseCfg))
+		upgrade = withAnnotation(releaseCfg, withDeploymentStatus(releaseCfg, upgrade, forge.StateSuccess))
+		group.steps = append(group.steps, withDiagnostics(releaseCfg, upgrade))
+		if !cfg.DryRun {
+			group.steps = append(group.steps, run.NewExportStatus(releaseCfg, true))
+		}
+		steps = append(steps, group)
+	}
+
+	return steps
+}
+
+func isRelease(step Step) bool {
+	_, ok := step.(*releaseSteps)
+	return ok
+}
+
+// releaseResult is the outcome of executing one release.
+type releaseResult struct {
+	release  *releaseSteps
+	err      error
+	duration time.Duration
+}
+
+// executeReleases deploys a group of releases, running as many at once as the parallelism setting allows. Each release
+// starts once the releases it needs have succeeded; if any of them failed or were skipped, it's skipped too. A failed
+// release doesn't stop the rest, but all failures are reported once every release has finished.
+func (p *Plan) executeReleases(steps []Step) error {
+	limit := p.cfg.Parallelism
+	if limit < 1 {
+		limit = 1
+	}
+
+	pending := make([]*releaseSteps, len(steps))
+	for i, step := range steps {
+		pending[i] = step.(*releaseSteps)
+	}
+
+	succeeded := make(map[string]bool)
+	errs := make(map[string]error)
+	var failed, skipped []string
+	results := make(chan releaseResult)
+	running := 0
+
+	for len(pending) > 0 || running > 0 {
+		// releases are in dependency order, so a release's needs are always ahead of it in the queue
+		for i := 0; i < len(pending); {
+			release := pending[i]
+			if blocker := firstBlocker(release.needs, failed, skipped); blocker != "" {
+				logging.Infof(p.cfg.Stderr, "release %s skipped, since %s was not deployed", release.name, blocker)
+				skipped = append(skipped, release.name)
+				p.outcomes.skip(release)
+				pending = append(pending[:i], pending[i+1:]...)
+				continue
+			}
+			if running < limit && allPlaced(release.needs, succeeded) {
+				running++
+				go func(release *releaseSteps) {
+					start := timeNow()
+					err := traced(p.tracer, p.span, "Execute", release, Step.Execute)
+					results <- releaseResult{release: release, err: err, duration: timeNow().Sub(start)}
+				}(release)
+				pending = append(pending[:i], pending[i+1:]...)
+				continue
+			}
+			i++
+		}
+		if running == 0 {
+			break
+		}
+
+		result := <-results
+		running--
+		if result.err == nil {
+			p.outcomes.record(result.release, result.duration, nil)
+			succeeded[result.release.name] = true
+			continue
+		}
+		err := fmt.Errorf("while executing %s: %w", describe(result.release), result.err)
+		logging.Errorf(p.cfg.Stderr, "release %s failed: %s", result.release.name, err)
+		failed = append(failed, result.release.name)
+		p.outcomes.record(result.release, result.duration, result.err)
+		errs[result.release.name] = err
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+	if len(failed) == 1 && len(skipped) == 0 {
+		return errs[failed[0]]
+	}
+
+	// report failures in the order the releases were listed, rather than the order they happened to finish in
+	var ordered []error
+	var names []string
+	for _, step := range steps {
+		name := step.(*releaseSteps).name
+		if err, ok := errs[name]; ok {
+			ordered = append(ordered, err)
+			names = append(names, name)
+		}
+	}
+	summary := fmt.Sprintf("%s failed (%s)", countReleases(len(names)), strings.Join(names, ", "))
+	if len(skipped) > 0 {
+		summary += fmt.Sprintf(" and %s skipped (%s)", countReleases(len(skipped)), strings.Join(skipped, ", "))
+	}
+	return &aggregateError{summary: summary, errs: ordered}
+}
+
+// firstBlocker returns the first of a release's needs that failed or was skipped, or "" if there isn't one.
+func firstBlocker(needs, failed, skipped []string) string {
+	for _, need := range needs {
+		if contains(failed, need) || contains(skipped, need) {
+			return need
+		}
+	}
+	return ""
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func countReleases(n int) string {
+	if n == 1 {
+		return "1 release"
+	}
+	return fmt.Sprintf("%d releases", n)
+}
+
+// orderReleases sorts the release groups among the given steps so that each release comes after the ones it needs.
+// Releases are otherwise kept in the order they were listed. Other steps are left where they are.
+func orderReleases(steps []Step) error {
+	var positions []int
+	byName := make(map[string]*releaseSteps)
+	duplicated := make(map[string]bool)
+	for i, step := range steps {
+		if release, ok := step.(*releaseSteps); ok {
+			positions = append(positions, i)
+			if byName[release.name] != nil {
+				duplicated[release.name] = true
+			}
+			byName[release.name] = release
+		}
+	}
+
+	for _, i := range positions {
+		release := steps[i].(*releaseSteps)
+		for _, need := range release.needs {
+			switch {
+			case byName[need] == nil:
+				return fmt.Errorf("release %s needs %s, which isn't one of the releases", release.name, need)
+			case duplicated[need]:
+				return fmt.Errorf("release %s needs %s, but there's more than one release with that name", release.name, need)
+			case need == release.name:
+				return fmt.Errorf("release %s can't need itself", release.name)
+			}
+		}
+	}
+
+	// repeatedly take the first release whose needs have all been placed
+	placed := make(map[string]bool)
+	pending := make([]*releaseSteps, 0, len(positions))
+	for _, i := range positions {
+		pending = append(pending, steps[i].(*releaseSteps))
+	}
+	for _, i := range positions {
+		next := -1
+		for j, release := range pending {
+			if allPlaced(release.needs, placed) {
+				next = j
+				break
+			}
+		}
+		if next == -1 {
+			return fmt.Errorf("releases have a dependency cycle: %s", describeCycle(pending, byName))
+		}
+		steps[i] = pending[next]
+		placed[pending[next].name] = true
+		pending = append(pending[:next], pending[next+1:]...)
+	}
+	return nil
+}
+
+func allPlaced(needs []string, placed map[string]bool) bool {
+	for _, need := range needs {
+		if !placed[need] {
+			return false
+		}
+	}
+	return true
+}
+
+// describeCycle follows unplaced needs from the first pending release until one repeats, and lists the releases in
+// the loop it finds.
+func describeCycle(pending []*releaseSteps, byName map[string]*releaseSteps) string {
+	isPending := make(map[string]bool)
+	for _, release := range pending {
+		isPending[release.name] = true
+	}
+
+	var path []string
+	seen := make(map[string]int)
+	current := pending[0]
+	for {
+		if start, ok := seen[current.name]; ok {
+			return strings.Join(append(path[start:], current.name), " -> ")
+		}
+		seen[current.name] = len(path)
+		path = append(path, current.name)
+		for _, need := range current.needs {
+			if isPending[need] {
+				current = byName[need]
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package helm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/run"
+)
+
+type ReleasesTestSuite struct {
+	suite.Suite
+}
+
+func TestReleasesTestSuite(t *testing.T) {
+	suite.Run(t, new(ReleasesTestSuite))
+}
+
+func (suite *ReleasesTestSuite) TestUpgradeReleases() {
+	cfg := env.Config{
+		AddRepos:           []string{"shire=https://shire.example.com"},
+		DependenciesAction: "build",
+		Releases: env.Releases{
+			{Release: "frodo", Chart: "./frodo"},
+			{Release: "sam", Chart: "./sam"},
+		},
+	}
+	steps := upgrade(cfg)
+	suite.Require().Equal(4, len(steps), "there should be one InitKube, one AddRepo and a step for each release")
+	suite.IsType(&run.InitKube{}, steps[0])
+	suite.IsType(&run.AddRepo{}, steps[1])
+
+	for i, name := range []string{"frodo", "sam"} {
+		suite.Require().IsType(&releaseSteps{}, steps[i+2])
+		release := steps[i+2].(*releaseSteps)
+		suite.Equal(name, release.name)
+		suite.Require().Equal(3, len(release.steps))
+		suite.IsType(&run.DepAction{}, release.steps[0])
+		suite.IsType(&run.Upgrade{}, release.steps[1])
+		suite.IsType(&run.ExportStatus{}, release.steps[2])
+	}
+}
+
+func (suite *ReleasesTestSuite) TestUpgradeReleasesWithSmokeTests() {
+	cfg := env.Config{
+		SkipKubeconfig: true,
+		Releases: env.Releases{
+			{Release: "frodo", SmokeTests: env.SmokeTests{{URL: "https://bag-end.example.com", Status: 200}}},
+			{Release: "sam"},
+		},
+	}
+	steps := upgrade(cfg)
+	suite.Require().Equal(2, len(steps))
+	frodo, sam := steps[0].(*releaseSteps), steps[1].(*releaseSteps)
+	suite.Require().Equal(2, len(frodo.steps))
+	suite.IsType(&smokeTestedStep{}, frodo.steps[0])
+	suite.Require().Equal(2, len(sam.steps))
+	suite.IsType(&run.Upgrade{}, sam.steps[0], "releases without smoke tests shouldn't be tested")
+}
+
+func (suite *ReleasesTestSuite) TestUpgradeReleasesWithSkipKubeconfig() {
+	cfg := env.Config{
+		SkipKubeconfig: true,
+		Releases:       env.Releases{{Release: "merry"}, {Release: "pippin"}},
+	}
+	steps := upgrade(cfg)
+	suite.Require().Equal(2, len(steps))
+	suite.IsType(&releaseSteps{}, steps[0])
+	suite.IsType(&releaseSteps{}, steps[1])
+}
+
+func (suite *ReleasesTestSuite) TestReleaseStepsPrepareAndExecute() {
+	ctrl := gomock.NewController(suite.T())
+	defer ctrl.Finish()
+	stepOne := NewMockStep(ctrl)
+	stepTwo := &cleanableStep{MockStep: NewMockStep(ctrl)}
+	release := &releaseSteps{name: "bilbo", steps: []Step{stepOne, stepTwo}}
+
+	stepOne.EXPECT().Prepare()
+	stepTwo.EXPECT().Prepare()
+	suite.NoError(release.Prepare())
+
+	stepOne.EXPECT().Execute().Return(fmt.Errorf("there and back again"))
+	suite.EqualError(release.Execute(), "while executing *helm.MockStep step: there and back again")
+
+	release.Cleanup()
+	suite.True(stepTwo.cleaned)
+}
+
+func (suite *ReleasesTestSuite) TestNewPlanReportsRelease() {
+	cfg := env.Config{
+		Command:        "upgrade",
+		SkipKubeconfig: true,
+		Releases:       env.Releases{{Release: "gandalf", Chart: "./gandalf"}, {Release: "saruman"}},
+	}
+	_, err := NewPlan(cfg)
+	suite.EqualError(err, "while preparing release saruman: while preparing *run.Upgrade step: chart is required")
+}
+
+func (suite *ReleasesTestSuite) TestExecuteContinuesAfterReleaseFailure() {
+	ctrl := gomock.NewController(suite.T())
+	defer ctrl.Finish()
+	steps := make([]*MockStep, 3)
+	for i := range steps {
+		steps[i] = NewMockStep(ctrl)
+	}
+
+	stderr := strings.Builder{}
+	plan := Plan{
+		steps: []Step{
+			&releaseSteps{name: "aragorn", steps: []Step{steps[0]}},
+			&releaseSteps{name: "boromir", steps: []Step{steps[1]}},
+			&releaseSteps{name: "faramir", steps: []Step{steps[2]}},
+		},
+		cfg: env.Config{Stderr: &stderr},
+	}
+
+	steps[0].EXPECT().Execute().Return(fmt.Errorf("one does not simply walk into mordor"))
+	steps[1].EXPECT().Execute()
+	steps[2].EXPECT().Execute().Return(fmt.Errorf("it is but a dream"))
+
+	_, err := plan.Execute()
+	suite.EqualError(err, "2 releases failed (aragorn, faramir): "+
+		"while executing release aragorn: while executing *helm.MockStep step: one does not simply walk into mordor; "+
+		"while executing release faramir: while executing *helm.MockStep step: it is but a dream")
+	suite.Contains(stderr.String(), "release aragorn failed: ")
+	suite.Contains(stderr.String(), "release faramir failed: ")
+
+	steps[0].EXPECT().Execute().Return(fmt.Errorf("one does not simply walk into mordor"))
+	steps[1].EXPECT().Execute()
+	steps[2].EXPECT().Execute()
+	_, err = plan.Execute()
+	suite.EqualError(err, "while executing release aragorn: while executing *helm.MockStep step: one does not simply walk into mordor")
+}
+
+func (suite *ReleasesTestSuite) TestOrderReleases() {
+	kube := &run.InitKube{}
+	app := &releaseSteps{name: "app", needs: []string{"database", "operator"}}
+	database := &releaseSteps{name: "database"}
+	crds := &releaseSteps{name: "crds"}
+	operator := &releaseSteps{name: "operator", needs: []string{"crds"}}
+	steps := []Step{kube, app, database, operator, crds}
+
+	suite.Require().NoError(orderReleases(steps))
+	suite.Equal([]Step{kube, database, crds, operator, app}, steps)
+}
+
+func (suite *ReleasesTestSuite) TestOrderReleasesErrors() {
+	err := orderReleases([]Step{&releaseSteps{name: "app", needs: []string{"database"}}})
+	suite.EqualError(err, "release app needs database, which isn't one of the releases")
+
+	err = orderReleases([]Step{&releaseSteps{name: "app", needs: []string{"app"}}})
+	suite.EqualError(err, "release app can't need itself")
+
+	err = orderReleases([]Step{
+		&releaseSteps{name: "app", needs: []string{"database"}},
+		&releaseSteps{name: "database"},
+		&releaseSteps{name: "database"},
+	})
+	suite.EqualError(err, "release app needs database, but there's more than one release with that name")
+
+	err = orderReleases([]Step{
+		&releaseSteps{name: "web"},
+		&releaseSteps{name: "app", needs: []string{"web", "cache"}},
+		&releaseSteps{name: "cache", needs: []string{"database"}},
+		&releaseSteps{name: "database", needs: []string{"app"}},
+	})
+	suite.EqualError(err, "releases have a dependency cycle: app -> cache -> database -> app")
+}
+
+func (suite *ReleasesTestSuite) TestNewPlanRejectsCycles() {
+	cfg := env.Config{
+		Command:        "upgrade",
+		SkipKubeconfig: true,
+		Releases: env.Releases{
+			{Release: "chicken", Chart: "./chicken", Needs: []string{"egg"}},
+			{Release: "egg", Chart: "./egg", Needs: []string{"chicken"}},
+		},
+	}
+	_, err := NewPlan(cfg)
+	suite.EqualError(err, "releases have a dependency cycle: chicken -> egg -> chicken")
+}
+
+func (suite *ReleasesTestSuite) TestExecuteSkipsDependents() {
+	ctrl := gomock.NewController(suite.T())
+	defer ctrl.Finish()
+	database := NewMockStep(ctrl)
+	cache := NewMockStep(ctrl)
+
+	stderr := strings.Builder{}
+	plan := Plan{
+		steps: []Step{
+			&releaseSteps{name: "database", steps: []Step{database}},
+			&releaseSteps{name: "cache", steps: []Step{cache}},
+			&releaseSteps{name: "api", needs: []string{"cache", "database"}, steps: []Step{NewMockStep(ctrl)}},
+			&releaseSteps{name: "web", needs: []string{"api"}, steps: []Step{NewMockStep(ctrl)}},
+		},
+		cfg: env.Config{Stderr: &stderr},
+	}
+
+	database.EXPECT().Execute().Return(fmt.Errorf("disk full"))
+	cache.EXPECT().Execute()
+
+	_, err := plan.Execute()
+	suite.EqualError(err, "1 release failed (database) and 2 releases skipped (api, web): "+
+		"while executing release database: while executing *helm.MockStep step: disk full")
+	suite.Contains(stderr.String(), "release api skipped, since database was not deployed\n")
+	suite.Contains(stderr.String(), "release web skipped, since api was not deployed\n")
+}
@@ -0,0 +1,196 @@
+package helm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pelotech/drone-helm3/internal/logging"
+)
+
+var timeNow = time.Now
+
+// stepOutcome records how one of the plan's steps went.
+type stepOutcome struct {
+	outcome  string
+	prepared time.Duration
+	duration time.Duration
+	err      error
+}
+
+// ran reports whether the step was executed.
+func (o stepOutcome) ran() bool {
+	return o.outcome == outcomeSucceeded || o.outcome == outcomeFailed
+}
+
+// stepOutcomes records how each of a series of steps went.
+type stepOutcomes map[Step]*stepOutcome
+
+func (o *stepOutcomes) get(step Step) *stepOutcome {
+	if *o == nil {
+		*o = make(stepOutcomes)
+	}
+	if (*o)[step] == nil {
+		(*o)[step] = &stepOutcome{outcome: outcomeNotRun}
+	}
+	return (*o)[step]
+}
+
+// of returns the outcome of a step, which is "not run" if nothing was recorded for it.
+func (o stepOutcomes) of(step Step) stepOutcome {
+	if outcome, ok := o[step]; ok {
+		return *outcome
+	}
+	return stepOutcome{outcome: outcomeNotRun}
+}
+
+// prepare prepares a step, recording how long it took.
+func (o *stepOutcomes) prepare(step Step) error {
+	start := timeNow()
+	err := step.Prepare()
+	o.get(step).prepared = timeNow().Sub(start)
+	return err
+}
+
+// execute executes a step, recording how long it took and whether it succeeded.
+func (o *stepOutcomes) execute(step Step) error {
+	start := timeNow()
+	err := step.Execute()
+	o.record(step, timeNow().Sub(start), err)
+	return err
+}
+
+// record notes that a step succeeded, if err is nil, or failed.
+func (o *stepOutcomes) record(step Step, duration time.Duration, err error) {
+	outcome := o.get(step)
+	outcome.outcome = outcomeSucceeded
+	if err != nil {
+		outcome.outcome = outcomeFailed
+	}
+	outcome.duration = duration
+	outcome.err = err
+}
+
+// skip notes that a step was skipped.
+func (o *stepOutcomes) skip(step Step) {
+	o.get(step).outcome = outcomeSkipped
+}
+
+// A Report describes how each of the plan's steps went. Releases are reported step by step.
+type Report struct {
+	Steps []StepReport
+}
+
+// A StepReport describes how one step went.
+type StepReport struct {
+	Type     string        // the kind of step, such as "Upgrade" or "release my-app: Upgrade"
+	Command  string        // the helm command the step ran, with any secrets redacted
+	Prepare  time.Duration // how long the step took to prepare
+	Execute  time.Duration // how long the step took to execute
+	ExitCode int           // the helm command's exit code, or -1 if there's no exit code to report
+	Outcome  string        // "succeeded", "failed", "skipped" or "not run"
+}
+
+// report describes how each of the plan's steps went.
+func (p *Plan) report() *Report {
+	r := &Report{}
+	for _, step := range p.steps {
+		outcome := p.outcomes.of(step)
+		group, ok := step.(*releaseSteps)
+		if !ok {
+			r.Steps = append(r.Steps, stepReport(stepName(step), step, outcome))
+			continue
+		}
+		for _, s := range group.steps {
+			stepOutcome := group.outcomes.of(s)
+			if outcome.outcome == outcomeSkipped {
+				stepOutcome.outcome = outcomeSkipped
+			}
+			name := fmt.Sprintf("release %s: %s", group.name, stepName(s))
+			r.Steps = append(r.Steps, stepReport(name, s, stepOutcome))
+		}
+	}
+	return r
+}
+
+func stepReport(name string, step Step, outcome stepOutcome) StepReport {
+	report := StepReport{
+		Type:     name,
+		Command:  commandOf(step),
+		Prepare:  outcome.prepared,
+		ExitCode: -1,
+		Outcome:  outcome.outcome,
+	}
+	if !outcome.ran() {
+		return report
+	}
+	report.Execute = outcome.duration
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(outcome.err, &exitErr):
+		report.ExitCode = exitErr.ExitCode()
+	case outcome.err == nil && report.Command != "":
+		report.ExitCode = 0
+	}
+	return report
+}
+
+// commandOf finds the helm command that a step runs, if it runs one.
+func commandOf(step Step) string {
+	switch step := unwrap(step).(type) {
+	case *parallelSteps:
+		var commands []string
+		for _, s := range step.steps {
+			if command := commandOf(s); command != "" {
+				commands = append(commands, command)
+			}
+		}
+		return strings.Join(commands, "; ")
+	case interface{ Command() string }:
+		return step.Command()
+	default:
+		return ""
+	}
+}
+
+// Print writes the report as a table, or as one record per step if the writer produces JSON.
+func (r *Report) Print(w io.Writer) {
+	if logging.Structured(w) {
+		for _, step := range r.Steps {
+			fields := logging.Fields{
+				"type":     step.Type,
+				"command":  step.Command,
+				"prepare":  step.Prepare.Seconds(),
+				"execute":  step.Execute.Seconds(),
+				"exitCode": step.ExitCode,
+				"outcome":  step.Outcome,
+			}
+			logging.Infof(logging.WithFields(w, fields), "step %s %s", step.Type, step.Outcome)
+		}
+		return
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "STEP\tCOMMAND\tPREPARE\tEXECUTE\tEXIT\tSTATE")
+	for _, step := range r.Steps {
+		command := step.Command
+		if command == "" {
+			command = "-"
+		}
+		execute, exitCode := "-", "-"
+		if step.Outcome == outcomeSucceeded || step.Outcome == outcomeFailed {
+			execute = step.Execute.Round(time.Millisecond).String()
+		}
+		if step.ExitCode >= 0 {
+			exitCode = fmt.Sprint(step.ExitCode)
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", step.Type, command, step.Prepare.Round(time.Millisecond),
+			execute, exitCode, step.Outcome)
+	}
+	table.Flush()
+}
@@ -0,0 +1,149 @@
+package helm
+
+import (
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
+)
+
+// commandStep is a funcStep that reports the command it runs.
+type commandStep struct {
+	funcStep
+	command string
+}
+
+func (c *commandStep) Command() string {
+	return c.command
+}
+
+type ReportTestSuite struct {
+	suite.Suite
+	originalTimeNow func() time.Time
+}
+
+func (suite *ReportTestSuite) BeforeTest(_, _ string) {
+	// each reading of the clock is 500ms after the last
+	suite.originalTimeNow = timeNow
+	clock := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		clock = clock.Add(500 * time.Millisecond)
+		return clock
+	}
+}
+
+func (suite *ReportTestSuite) AfterTest(_, _ string) {
+	timeNow = suite.originalTimeNow
+}
+
+func TestReportTestSuite(t *testing.T) {
+	suite.Run(t, new(ReportTestSuite))
+}
+
+func (suite *ReportTestSuite) TestExecuteReturnsReport() {
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+	suite.Require().IsType(&exec.ExitError{}, exitErr)
+
+	succeed := func() error { return nil }
+	initKube := &funcStep{execute: succeed}
+	addRepo := &commandStep{funcStep{execute: succeed}, "helm repo add isengard https://isengard.example"}
+	upgrade := &commandStep{funcStep{execute: func() error { return exitErr }}, "helm upgrade --install orthanc"}
+	export := &commandStep{funcStep{execute: succeed}, "helm status orthanc"}
+	stderr := &strings.Builder{}
+	p := &Plan{
+		cfg: env.Config{Stderr: stderr},
+		steps: []Step{
+			initKube,
+			addRepo,
+			&releaseSteps{name: "orthanc", steps: []Step{upgrade, export}},
+			&releaseSteps{name: "nan-curunir", needs: []string{"orthanc"}, steps: []Step{&funcStep{execute: succeed}}},
+		},
+	}
+	suite.Require().NoError(p.outcomes.prepare(initKube))
+	suite.Require().NoError(p.outcomes.prepare(addRepo))
+
+	report, err := p.Execute()
+	suite.Error(err)
+	suite.Equal(&Report{Steps: []StepReport{
+		{Type: "funcStep", Prepare: 500 * time.Millisecond, Execute: 500 * time.Millisecond, ExitCode: -1,
+			Outcome: "succeeded"},
+		{Type: "commandStep", Command: "helm repo add isengard https://isengard.example", Prepare: 500 * time.Millisecond,
+			Execute: 500 * time.Millisecond, ExitCode: 0, Outcome: "succeeded"},
+		{Type: "release orthanc: commandStep", Command: "helm upgrade --install orthanc",
+			Execute: 500 * time.Millisecond, ExitCode: 3, Outcome: "failed"},
+		{Type: "release orthanc: commandStep", Command: "helm status orthanc", ExitCode: -1, Outcome: "not run"},
+		{Type: "release nan-curunir: funcStep", ExitCode: -1, Outcome: "skipped"},
+	}}, report)
+
+	suite.Contains(stderr.String(), "STEP                           COMMAND")
+	suite.Contains(stderr.String(), "release orthanc: commandStep   helm upgrade --install orthanc")
+}
+
+func (suite *ReportTestSuite) TestPrint() {
+	report := &Report{Steps: []StepReport{
+		{Type: "AddRepo", Command: "helm repo add rohan https://rohan.example", Prepare: time.Millisecond,
+			Execute: 12*time.Second + 345*time.Millisecond + 678*time.Microsecond, ExitCode: 0, Outcome: "succeeded"},
+		{Type: "Upgrade", Command: "helm upgrade --install edoras", ExitCode: -1, Outcome: "not run"},
+		{Type: "InitKube", Execute: time.Millisecond, ExitCode: -1, Outcome: "succeeded"},
+	}}
+
+	out := &strings.Builder{}
+	report.Print(out)
+	suite.Equal(""+
+		"STEP      COMMAND                                    PREPARE  EXECUTE  EXIT  STATE\n"+
+		"AddRepo   helm repo add rohan https://rohan.example  1ms      12.346s  0     succeeded\n"+
+		"Upgrade   helm upgrade --install edoras              0s       -        -     not run\n"+
+		"InitKube  -                                          0s       1ms      -     succeeded\n", out.String())
+}
+
+func (suite *ReportTestSuite) TestPrintJSON() {
+	report := &Report{Steps: []StepReport{
+		{Type: "Upgrade", Command: "helm upgrade --install edoras", Prepare: 250 * time.Millisecond,
+			Execute: 2 * time.Second, ExitCode: 1, Outcome: "failed"},
+	}}
+
+	out := &strings.Builder{}
+	_, stderr, err := logging.Wrap("json", &strings.Builder{}, out)
+	suite.Require().NoError(err)
+	report.Print(stderr)
+
+	var record struct {
+		Message string                 `json:"message"`
+		Fields  map[string]interface{} `json:"fields"`
+	}
+	suite.Require().NoError(json.Unmarshal([]byte(out.String()), &record))
+	suite.Equal("step Upgrade failed", record.Message)
+	suite.Equal(map[string]interface{}{
+		"type":     "Upgrade",
+		"command":  "helm upgrade --install edoras",
+		"prepare":  0.25,
+		"execute":  float64(2),
+		"exitCode": float64(1),
+		"outcome":  "failed",
+	}, record.Fields)
+}
+
+func (suite *ReportTestSuite) TestCommandOf() {
+	suite.Equal("", commandOf(&funcStep{}))
+	suite.Equal("helm upgrade", commandOf(&diagnosedStep{Step: &commandStep{command: "helm upgrade"}}))
+	suite.Equal("helm repo add a; helm repo add b", commandOf(&parallelSteps{steps: []Step{
+		&bufferedStep{Step: &commandStep{command: "helm repo add a"}},
+		&bufferedStep{Step: &commandStep{command: "helm repo add b"}},
+	}}))
+}
+
+func (suite *ReportTestSuite) TestExitCodeOfOtherErrors() {
+	p := &Plan{steps: []Step{&commandStep{funcStep{execute: func() error {
+		return errors.New("no such file or directory")
+	}}, "helm lint"}}}
+	report, err := p.Execute()
+	suite.Error(err)
+	suite.Equal(-1, report.Steps[0].ExitCode, "only helm's exit status should be reported")
+}
@@ -0,0 +1,49 @@
+package helm
+
+import (
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/run"
+)
+
+// smokeTestedStep smoke tests the release once the upgrade it wraps has succeeded. The smoke test is part of the
+// upgrade as far as the wrappers around it are concerned, so a deployment whose smoke test fails is reported as failed.
+type smokeTestedStep struct {
+	Step
+	smokeTest *run.SmokeTest
+}
+
+// withSmokeTest wraps an upgrade step so that the release is smoke tested afterwards, if the Config has smoke tests.
+func withSmokeTest(cfg env.Config, step Step) Step {
+	if len(cfg.SmokeTests) == 0 || cfg.DryRun {
+		return step
+	}
+	return &smokeTestedStep{
+		Step:      step,
+		smokeTest: run.NewSmokeTest(cfg),
+	}
+}
+
+func (s *smokeTestedStep) Prepare() error {
+	if err := s.Step.Prepare(); err != nil {
+		return err
+	}
+	return s.smokeTest.Prepare()
+}
+
+// Execute executes the wrapped step, then runs the smoke test if it succeeded.
+func (s *smokeTestedStep) Execute() error {
+	if err := s.Step.Execute(); err != nil {
+		return err
+	}
+	return s.smokeTest.Execute()
+}
+
+func (s *smokeTestedStep) unwrap() Step {
+	return s.Step
+}
+
+func (s *smokeTestedStep) Cleanup() {
+	if c, ok := s.Step.(cleaner); ok {
+		c.Cleanup()
+	}
+}
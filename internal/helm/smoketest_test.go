@@ -0,0 +1,107 @@
+package helm
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/run"
+)
+
+type SmokeTestTestSuite struct {
+	suite.Suite
+	site     *httptest.Server
+	status   int
+	requests int
+}
+
+func (suite *SmokeTestTestSuite) BeforeTest(_, _ string) {
+	suite.status, suite.requests = http.StatusOK, 0
+	suite.site = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.requests++
+		w.WriteHeader(suite.status)
+	}))
+}
+
+func (suite *SmokeTestTestSuite) AfterTest(_, _ string) {
+	suite.site.Close()
+}
+
+func TestSmokeTestTestSuite(t *testing.T) {
+	suite.Run(t, new(SmokeTestTestSuite))
+}
+
+// smokeTestConfig is a Config whose smoke test of the given server gives up quickly.
+func smokeTestConfig(site *httptest.Server) env.Config {
+	return env.Config{
+		Release:           "hobbiton",
+		SmokeTests:        env.SmokeTests{{URL: site.URL, Status: 200}},
+		SmokeTestTimeout:  "20ms",
+		SmokeTestInterval: "5ms",
+		Stdout:            &strings.Builder{},
+		Stderr:            &strings.Builder{},
+	}
+}
+
+func (suite *SmokeTestTestSuite) TestWithSmokeTest() {
+	step := &funcStep{}
+	suite.Same(step, withSmokeTest(env.Config{Release: "hobbiton"}, step), "there are no smoke tests to run")
+
+	cfg := smokeTestConfig(suite.site)
+	cfg.DryRun = true
+	suite.Same(step, withSmokeTest(cfg, step), "a dry run has nothing to smoke test")
+
+	wrapped := withSmokeTest(smokeTestConfig(suite.site), step)
+	suite.Require().IsType(&smokeTestedStep{}, wrapped)
+	suite.Same(step, wrapped.(*smokeTestedStep).Step)
+}
+
+func (suite *SmokeTestTestSuite) TestExecute() {
+	upgraded := false
+	wrapped := withSmokeTest(smokeTestConfig(suite.site), &funcStep{execute: func() error {
+		suite.Zero(suite.requests, "the release should be smoke tested once it's upgraded")
+		upgraded = true
+		return nil
+	}})
+	suite.Require().NoError(wrapped.Prepare())
+	suite.NoError(wrapped.Execute())
+	suite.True(upgraded)
+	suite.Equal(1, suite.requests)
+}
+
+func (suite *SmokeTestTestSuite) TestExecuteFailures() {
+	wrapped := withSmokeTest(smokeTestConfig(suite.site), &funcStep{execute: func() error {
+		return errors.New("the road goes ever on")
+	}})
+	suite.Require().NoError(wrapped.Prepare())
+	suite.EqualError(wrapped.Execute(), "the road goes ever on")
+	suite.Zero(suite.requests, "a failed upgrade shouldn't be smoke tested")
+
+	suite.status = http.StatusServiceUnavailable
+	wrapped = withSmokeTest(smokeTestConfig(suite.site), &funcStep{execute: func() error { return nil }})
+	suite.Require().NoError(wrapped.Prepare())
+	err := wrapped.Execute()
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "smoke test of "+suite.site.URL+" failed")
+}
+
+func (suite *SmokeTestTestSuite) TestPrepareErrors() {
+	cfg := smokeTestConfig(suite.site)
+	cfg.SmokeTestTimeout = "soon"
+	wrapped := withSmokeTest(cfg, &funcStep{})
+	suite.EqualError(wrapped.Prepare(), `invalid smoke_test_timeout 'soon': time: invalid duration "soon"`)
+}
+
+func (suite *SmokeTestTestSuite) TestReportsWrappedStep() {
+	upgrade := run.NewUpgrade(env.Config{Chart: "shire/hobbiton", Release: "hobbiton"})
+	wrapped := withSmokeTest(smokeTestConfig(suite.site), upgrade)
+	suite.Require().NoError(wrapped.Prepare())
+	suite.Equal(upgrade.Command(), commandOf(wrapped))
+	suite.Equal("*run.Upgrade step", describe(wrapped))
+	suite.Equal("Upgrade", stepName(wrapped))
+}
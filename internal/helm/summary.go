@@ -0,0 +1,154 @@
+package helm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pelotech/drone-helm3/internal/logging"
+	"github.com/pelotech/drone-helm3/internal/run"
+)
+
+// cardSchema is the adaptive card template drone uses to display the summary.
+const cardSchema = "https://raw.githubusercontent.com/pelotech/drone-helm3/master/assets/card.json"
+
+const (
+	outcomeSucceeded = "succeeded"
+	outcomeFailed    = "failed"
+	outcomeSkipped   = "skipped"
+	outcomeNotRun    = "not run"
+)
+
+// summary is the data for the deployment's card or markdown summary.
+type summary struct {
+	Outcome  string             `json:"outcome"`
+	Error    string             `json:"error,omitempty"`
+	Steps    []stepSummary      `json:"steps"`
+	Releases []*run.ReleaseInfo `json:"releases"`
+}
+
+type stepSummary struct {
+	Name     string `json:"name"`
+	Outcome  string `json:"outcome"`
+	Duration string `json:"duration"`
+}
+
+// writeSummary writes a drone card, if drone provides a path for one, or a markdown summary if the Config asks for
+// one. A summary that can't be written is reported, but doesn't fail the build.
+func (p *Plan) writeSummary(planErr error) {
+	var err error
+	switch {
+	case p.cfg.DroneCardPath != "":
+		err = writeCard(p.cfg.DroneCardPath, p.summarize(planErr))
+	case p.cfg.SummaryFile != "":
+		err = ioutil.WriteFile(p.cfg.SummaryFile, []byte(p.summarize(planErr).markdown()), 0644)
+	default:
+		return
+	}
+	if err != nil {
+		logging.Warnf(p.cfg.Stderr, "could not write the deployment summary: %s", err)
+	}
+}
+
+func (p *Plan) summarize(err error) summary {
+	s := summary{Outcome: outcomeSucceeded}
+	if err != nil {
+		s.Outcome = outcomeFailed
+		s.Error = err.Error()
+	}
+
+	for _, step := range p.steps {
+		outcome := p.outcomes.of(step)
+		duration := ""
+		if outcome.ran() {
+			duration = outcome.duration.Round(time.Millisecond).String()
+		}
+		s.Steps = append(s.Steps, stepSummary{Name: stepName(step), Outcome: outcome.outcome, Duration: duration})
+		s.Releases = append(s.Releases, deployedReleases(step)...)
+	}
+	return s
+}
+
+// stepName names a step for the summary.
+func stepName(step Step) string {
+	switch step := unwrap(step).(type) {
+	case *releaseSteps:
+		return "release " + step.name
+	case *parallelSteps:
+		var names []string
+		for _, s := range step.steps {
+			names = append(names, stepName(s))
+		}
+		return strings.Join(names, ", ")
+	default:
+		name := fmt.Sprintf("%T", step)
+		return name[strings.LastIndex(name, ".")+1:]
+	}
+}
+
+// deployedReleases finds the releases that a step deployed and exported the status of.
+func deployedReleases(step Step) []*run.ReleaseInfo {
+	var steps []Step
+	switch step := unwrap(step).(type) {
+	case *run.ExportStatus:
+		if info := step.Info(); info != nil {
+			return []*run.ReleaseInfo{info}
+		}
+		return nil
+	case *releaseSteps:
+		steps = step.steps
+	case *parallelSteps:
+		steps = step.steps
+	}
+
+	var releases []*run.ReleaseInfo
+	for _, s := range steps {
+		releases = append(releases, deployedReleases(s)...)
+	}
+	return releases
+}
+
+func writeCard(path string, s summary) error {
+	card, err := json.Marshal(struct {
+		Schema string  `json:"schema"`
+		Data   summary `json:"data"`
+	}{cardSchema, s})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, card, 0644)
+}
+
+func (s summary) markdown() string {
+	md := &strings.Builder{}
+	fmt.Fprintf(md, "## Deployment %s\n", s.Outcome)
+	if s.Error != "" {
+		fmt.Fprintf(md, "\n```\n%s\n```\n", s.Error)
+	}
+
+	fmt.Fprintf(md, "\n| Step | Outcome | Duration |\n|------|---------|----------|\n")
+	for _, step := range s.Steps {
+		fmt.Fprintf(md, "| %s | %s | %s |\n", step.Name, step.Outcome, step.Duration)
+	}
+
+	for _, release := range s.Releases {
+		fmt.Fprintf(md, "\n### Release %s\n\n", release.Name)
+		fmt.Fprintf(md, "| Namespace | Revision | Status | Chart | App version |\n")
+		fmt.Fprintf(md, "|-----------|----------|--------|-------|-------------|\n")
+		fmt.Fprintf(md, "| %s | %d | %s | %s %s | %s |\n", release.Namespace, release.Revision, release.Status,
+			release.Chart, release.ChartVersion, release.AppVersion)
+
+		if len(release.Changes) > 0 {
+			fmt.Fprintf(md, "\n| Resource | Change |\n|----------|--------|\n")
+			for _, change := range release.Changes {
+				fmt.Fprintf(md, "| %s | %s |\n", change.Resource, change.Change)
+			}
+		}
+		if release.Unchanged > 0 {
+			fmt.Fprintf(md, "\n%d other resources were unchanged.\n", release.Unchanged)
+		}
+	}
+	return md.String()
+}
@@ -0,0 +1,175 @@
+package helm
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/run"
+)
+
+type SummaryTestSuite struct {
+	suite.Suite
+	dir             string
+	originalTimeNow func() time.Time
+}
+
+func (suite *SummaryTestSuite) BeforeTest(_, _ string) {
+	dir, err := ioutil.TempDir("", "summary")
+	suite.Require().NoError(err)
+	suite.dir = dir
+
+	// each reading of the clock is 750ms after the last, so each step appears to take 750ms, and each release,
+	// which also times its own steps, 2.25s
+	suite.originalTimeNow = timeNow
+	clock := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		clock = clock.Add(750 * time.Millisecond)
+		return clock
+	}
+}
+
+func (suite *SummaryTestSuite) AfterTest(_, _ string) {
+	os.RemoveAll(suite.dir)
+	timeNow = suite.originalTimeNow
+}
+
+func TestSummaryTestSuite(t *testing.T) {
+	suite.Run(t, new(SummaryTestSuite))
+}
+
+func (suite *SummaryTestSuite) plan(cfg env.Config) *Plan {
+	succeed := func() error { return nil }
+	return &Plan{
+		cfg: cfg,
+		steps: []Step{
+			&funcStep{execute: succeed},
+			&releaseSteps{name: "rohan", steps: []Step{&funcStep{execute: succeed}}},
+			&releaseSteps{name: "gondor", needs: []string{"rohan"}, steps: []Step{&funcStep{execute: func() error {
+				return errors.New("the beacons are lit")
+			}}}},
+			&releaseSteps{name: "dol-amroth", needs: []string{"gondor"}, steps: []Step{&funcStep{execute: succeed}}},
+			&funcStep{execute: succeed},
+		},
+	}
+}
+
+func (suite *SummaryTestSuite) TestWritesMarkdown() {
+	summaryFile := filepath.Join(suite.dir, "summary.md")
+	p := suite.plan(env.Config{SummaryFile: summaryFile, Stderr: &strings.Builder{}})
+
+	_, err := p.Execute()
+	suite.Error(err)
+
+	summary, err := ioutil.ReadFile(summaryFile)
+	suite.Require().NoError(err)
+	suite.Equal("## Deployment failed\n"+
+		"\n```\n1 release failed (gondor) and 1 release skipped (dol-amroth): "+
+		"while executing release gondor: while executing *helm.funcStep step: the beacons are lit\n```\n"+
+		"\n| Step | Outcome | Duration |\n|------|---------|----------|\n"+
+		"| funcStep | succeeded | 750ms |\n"+
+		"| release rohan | succeeded | 2.25s |\n"+
+		"| release gondor | failed | 2.25s |\n"+
+		"| release dol-amroth | skipped |  |\n"+
+		"| funcStep | not run |  |\n", string(summary))
+}
+
+func (suite *SummaryTestSuite) TestWritesCard() {
+	cardPath := filepath.Join(suite.dir, "card.json")
+	summaryFile := filepath.Join(suite.dir, "summary.md")
+	p := &Plan{
+		cfg:   env.Config{DroneCardPath: cardPath, SummaryFile: summaryFile},
+		steps: []Step{&funcStep{execute: func() error { return nil }}},
+	}
+	_, err := p.Execute()
+	suite.NoError(err)
+
+	raw, err := ioutil.ReadFile(cardPath)
+	suite.Require().NoError(err)
+	var card struct {
+		Schema string  `json:"schema"`
+		Data   summary `json:"data"`
+	}
+	suite.Require().NoError(json.Unmarshal(raw, &card))
+	suite.Equal(cardSchema, card.Schema)
+	suite.Equal(summary{
+		Outcome: "succeeded",
+		Steps:   []stepSummary{{Name: "funcStep", Outcome: "succeeded", Duration: "750ms"}},
+	}, card.Data)
+
+	_, err = os.Stat(summaryFile)
+	suite.True(os.IsNotExist(err), "the markdown summary is only for when cards aren't available")
+}
+
+func (suite *SummaryTestSuite) TestNoSummary() {
+	p := &Plan{steps: []Step{&funcStep{execute: func() error { return nil }}}}
+	_, err := p.Execute()
+	suite.NoError(err)
+	files, err := ioutil.ReadDir(suite.dir)
+	suite.Require().NoError(err)
+	suite.Empty(files)
+}
+
+func (suite *SummaryTestSuite) TestUnwritableSummary() {
+	stderr := &strings.Builder{}
+	p := &Plan{
+		cfg:   env.Config{SummaryFile: filepath.Join(suite.dir, "missing", "summary.md"), Stderr: stderr},
+		steps: []Step{&funcStep{execute: func() error { return nil }}},
+	}
+	_, err := p.Execute()
+	suite.NoError(err, "failing to write the summary shouldn't fail the build")
+	suite.Contains(stderr.String(), "could not write the deployment summary")
+}
+
+func (suite *SummaryTestSuite) TestMarkdownReleases() {
+	s := summary{
+		Outcome: "succeeded",
+		Releases: []*run.ReleaseInfo{{
+			Name:         "edoras",
+			Namespace:    "rohan",
+			Revision:     3,
+			Status:       "deployed",
+			Chart:        "meduseld",
+			ChartVersion: "1.4.0",
+			AppVersion:   "v3",
+			Changes: []run.ResourceChange{
+				{Resource: "Deployment/meduseld", Change: "changed"},
+				{Resource: "ConfigMap/eored", Change: "created"},
+			},
+			Unchanged: 2,
+		}},
+	}
+	suite.Equal("## Deployment succeeded\n"+
+		"\n| Step | Outcome | Duration |\n|------|---------|----------|\n"+
+		"\n### Release edoras\n\n"+
+		"| Namespace | Revision | Status | Chart | App version |\n"+
+		"|-----------|----------|--------|-------|-------------|\n"+
+		"| rohan | 3 | deployed | meduseld 1.4.0 | v3 |\n"+
+		"\n| Resource | Change |\n|----------|--------|\n"+
+		"| Deployment/meduseld | changed |\n"+
+		"| ConfigMap/eored | created |\n"+
+		"\n2 other resources were unchanged.\n", s.markdown())
+}
+
+func (suite *SummaryTestSuite) TestStepName() {
+	suite.Equal("InitKube", stepName(run.NewInitKube(env.Config{}, "", "")))
+	suite.Equal("release rohan", stepName(&releaseSteps{name: "rohan"}))
+	suite.Equal("Upgrade", stepName(withDiagnostics(env.Config{DiagnoseOnFailure: true}, run.NewUpgrade(env.Config{}))))
+	suite.Equal("AddRepo, AddRepo", stepName(&parallelSteps{steps: []Step{
+		&bufferedStep{Step: run.NewAddRepo(env.Config{}, "")},
+		&bufferedStep{Step: run.NewAddRepo(env.Config{}, "")},
+	}}))
+}
+
+func (suite *SummaryTestSuite) TestDeployedReleases() {
+	steps := upgrade(env.Config{SkipKubeconfig: true, Releases: env.Releases{{Release: "rohan"}}})
+	suite.Empty(deployedReleases(steps[0]), "releases that haven't been exported shouldn't be summarized")
+}
@@ -0,0 +1,44 @@
+package helm
+
+import (
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
+	"github.com/pelotech/drone-helm3/internal/tracing"
+)
+
+// traceAttributes describes the deployment, for the plan's root span.
+func traceAttributes(cfg env.Config) map[string]string {
+	return map[string]string{
+		"helm.release":       cfg.Release,
+		"helm.namespace":     cfg.Namespace,
+		"helm.chart":         cfg.Chart,
+		"drone.build.number": cfg.DroneBuildNumber,
+		"drone.build.event":  cfg.DroneEvent,
+		"drone.repo":         cfg.DroneRepo,
+	}
+}
+
+// traced runs one phase of a step, such as its Prepare method, in a span that's a child of parent. Each of a
+// release's steps gets a span of its own, as a child of the release's.
+func traced(tracer *tracing.Tracer, parent *tracing.Span, phase string, step Step, run func(Step) error) error {
+	var attributes map[string]string
+	group, isRelease := step.(*releaseSteps)
+	if isRelease {
+		attributes = group.attributes
+	}
+
+	span := tracer.Start(phase+" "+stepName(step), parent, attributes)
+	if isRelease {
+		group.tracer, group.span = tracer, span
+	}
+	err := run(step)
+	span.End(err)
+	return err
+}
+
+// exportTraces sends the plan's spans to the collector. A failed export is reported, but doesn't fail the build.
+func (p *Plan) exportTraces() {
+	if err := p.tracer.Flush(); err != nil && p.cfg.Stderr != nil {
+		logging.Warnf(p.cfg.Stderr, "%s", err)
+	}
+}
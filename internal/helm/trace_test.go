@@ -0,0 +1,133 @@
+package helm
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/tracing"
+)
+
+// exportedSpan is the part of an OTLP/JSON span that the tests look at.
+type exportedSpan struct {
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Attributes   []struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	} `json:"attributes"`
+	Status struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+func (s exportedSpan) attribute(key string) string {
+	for _, attribute := range s.Attributes {
+		if attribute.Key == key {
+			return attribute.Value.StringValue
+		}
+	}
+	return ""
+}
+
+type TraceTestSuite struct {
+	suite.Suite
+	collector *httptest.Server
+	spans     map[string]exportedSpan
+	envBackup *string
+}
+
+func (suite *TraceTestSuite) BeforeTest(_, _ string) {
+	suite.spans = make(map[string]exportedSpan)
+	suite.collector = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var export struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []exportedSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		suite.Require().NoError(json.NewDecoder(r.Body).Decode(&export))
+		for _, span := range export.ResourceSpans[0].ScopeSpans[0].Spans {
+			suite.spans[span.Name] = span
+		}
+	}))
+
+	if value, ok := os.LookupEnv("OTEL_EXPORTER_OTLP_ENDPOINT"); ok {
+		suite.envBackup = &value
+	}
+	os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", suite.collector.URL)
+}
+
+func (suite *TraceTestSuite) AfterTest(_, _ string) {
+	suite.collector.Close()
+	if suite.envBackup == nil {
+		os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	} else {
+		os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", *suite.envBackup)
+	}
+	suite.envBackup = nil
+}
+
+func TestTraceTestSuite(t *testing.T) {
+	suite.Run(t, new(TraceTestSuite))
+}
+
+func (suite *TraceTestSuite) TestExecuteExportsSpans() {
+	cfg := env.Config{
+		Release:          "rivendell",
+		Namespace:        "eriador",
+		DroneBuildNumber: "3019",
+		DroneRepo:        "elrond/council",
+		Stderr:           &strings.Builder{},
+	}
+	initKube := &funcStep{execute: func() error { return nil }}
+	upgrade := &commandStep{funcStep{execute: func() error {
+		return errors.New("the ring cannot be destroyed here")
+	}}, "helm upgrade --install imladris"}
+	release := &releaseSteps{name: "imladris", steps: []Step{upgrade}, attributes: map[string]string{
+		"helm.release":   "imladris",
+		"helm.namespace": "eriador",
+	}}
+
+	p := &Plan{cfg: cfg, steps: []Step{initKube, release}, tracer: tracing.New()}
+	p.span = p.tracer.Start("drone-helm3", nil, traceAttributes(cfg))
+	suite.Require().NoError(traced(p.tracer, p.span, "Prepare", release, p.outcomes.prepare))
+
+	_, err := p.Execute()
+	suite.Error(err)
+
+	root := suite.spans["drone-helm3"]
+	suite.Equal("", root.ParentSpanID)
+	suite.Equal("rivendell", root.attribute("helm.release"))
+	suite.Equal("eriador", root.attribute("helm.namespace"))
+	suite.Equal("3019", root.attribute("drone.build.number"))
+	suite.Equal("elrond/council", root.attribute("drone.repo"))
+	suite.Equal(2, root.Status.Code, "the plan's failure should be recorded")
+
+	suite.Equal(root.SpanID, suite.spans["Execute funcStep"].ParentSpanID)
+	suite.Equal(0, suite.spans["Execute funcStep"].Status.Code)
+	suite.Equal(root.SpanID, suite.spans["Prepare release imladris"].ParentSpanID)
+
+	releaseSpan := suite.spans["Execute release imladris"]
+	suite.Equal(root.SpanID, releaseSpan.ParentSpanID)
+	suite.Equal("imladris", releaseSpan.attribute("helm.release"))
+	suite.Equal(2, releaseSpan.Status.Code)
+
+	// the release's own steps are children of the release's spans
+	suite.Equal(suite.spans["Prepare release imladris"].SpanID, suite.spans["Prepare commandStep"].ParentSpanID)
+	upgradeSpan := suite.spans["Execute commandStep"]
+	suite.Equal(releaseSpan.SpanID, upgradeSpan.ParentSpanID)
+	suite.Equal("the ring cannot be destroyed here", upgradeSpan.Status.Message)
+}
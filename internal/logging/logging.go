@@ -0,0 +1,271 @@
+// Package logging formats drone-helm3's output. By default, messages are written as plain text, exactly as they're
+// given. In JSON mode, every line of output becomes a JSON object, so that log aggregators can tell the plugin's own
+// messages from the output of the helm commands it runs.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// The log formats that the log_format setting accepts.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Message levels.
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// Record types: messages come from drone-helm3 itself, streams are the output of the commands it runs.
+const (
+	typeMessage = "message"
+	typeStream  = "stream"
+)
+
+var now = time.Now
+
+// Fields are structured data attached to a message. They're only included in JSON output.
+type Fields map[string]interface{}
+
+// record is one line of JSON output.
+type record struct {
+	Timestamp string `json:"timestamp"`
+	Level     string `json:"level"`
+	Type      string `json:"type"`
+	Stream    string `json:"stream,omitempty"`
+	Step      string `json:"step,omitempty"`
+	StepIndex *int   `json:"stepIndex,omitempty"`
+	Message   string `json:"message"`
+	Fields    Fields `json:"fields,omitempty"`
+}
+
+// logger holds the state shared by all of a run's JSON writers: the step that's currently running, and the writers
+// with partial lines that may need flushing.
+type logger struct {
+	mu        sync.Mutex
+	step      string
+	stepIndex *int
+	partial   []*jsonWriter
+}
+
+// A jsonWriter turns each line written to it into a JSON record.
+type jsonWriter struct {
+	log    *logger
+	dest   io.Writer
+	level  string
+	kind   string
+	stream string
+	fields Fields
+	buf    *bytes.Buffer
+}
+
+// Wrap returns writers for the plugin's stdout and stderr in the given format. Text output is passed through
+// unchanged.
+func Wrap(format string, stdout, stderr io.Writer) (io.Writer, io.Writer, error) {
+	switch format {
+	case "", FormatText:
+		return stdout, stderr, nil
+	case FormatJSON:
+		log := &logger{}
+		stdout = log.writer(stdout, LevelInfo, typeMessage, "", nil)
+		stderr = log.writer(stderr, LevelInfo, typeMessage, "", nil)
+		return stdout, stderr, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown log_format '%s' (expected '%s' or '%s')", format, FormatText, FormatJSON)
+	}
+}
+
+func (l *logger) writer(dest io.Writer, level, kind, stream string, fields Fields) *jsonWriter {
+	w := &jsonWriter{log: l, dest: dest, level: level, kind: kind, stream: stream, fields: fields}
+	w.buf = &bytes.Buffer{}
+	return w
+}
+
+// track keeps note of whether the writer holds a partial line, so that only those writers are flushed. The caller
+// must hold the logger's lock.
+func (l *logger) track(w *jsonWriter) {
+	for i, partial := range l.partial {
+		if partial == w {
+			if w.buf.Len() == 0 {
+				l.partial = append(l.partial[:i], l.partial[i+1:]...)
+			}
+			return
+		}
+	}
+	if w.buf.Len() > 0 {
+		l.partial = append(l.partial, w)
+	}
+}
+
+// Write emits a record for each complete line; a partial line is held until the rest of it arrives, or it's flushed.
+func (w *jsonWriter) Write(p []byte) (int, error) {
+	w.log.mu.Lock()
+	defer w.log.mu.Unlock()
+	defer w.log.track(w)
+
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		line := string(w.buf.Next(i + 1))
+		if err := w.emit(w.level, line[:len(line)-1], nil); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// emit writes a single record. The caller must hold the logger's lock.
+func (w *jsonWriter) emit(level, message string, fields Fields) error {
+	rec := record{
+		Timestamp: now().UTC().Format(time.RFC3339Nano),
+		Level:     level,
+		Type:      w.kind,
+		Stream:    w.stream,
+		Step:      w.log.step,
+		StepIndex: w.log.stepIndex,
+		Message:   message,
+		Fields:    merge(w.fields, fields),
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = w.dest.Write(append(line, '\n'))
+	return err
+}
+
+func (w *jsonWriter) flush() {
+	if w.buf.Len() > 0 {
+		w.emit(w.level, w.buf.String(), nil)
+		w.buf.Reset()
+	}
+}
+
+func merge(a, b Fields) Fields {
+	if len(a) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return a
+	}
+	merged := make(Fields, len(a)+len(b))
+	for k, v := range a {
+		merged[k] = v
+	}
+	for k, v := range b {
+		merged[k] = v
+	}
+	return merged
+}
+
+// Debugf writes a debugging message.
+func Debugf(w io.Writer, format string, args ...interface{}) {
+	logf(w, LevelDebug, format, args...)
+}
+
+// Infof writes an informational message.
+func Infof(w io.Writer, format string, args ...interface{}) {
+	logf(w, LevelInfo, format, args...)
+}
+
+// Warnf writes a warning. In text mode, it's prefixed with "Warning: ".
+func Warnf(w io.Writer, format string, args ...interface{}) {
+	logf(w, LevelWarn, format, args...)
+}
+
+// Errorf writes an error message.
+func Errorf(w io.Writer, format string, args ...interface{}) {
+	logf(w, LevelError, format, args...)
+}
+
+func logf(w io.Writer, level, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	jw, ok := w.(*jsonWriter)
+	if !ok {
+		if level == LevelWarn {
+			message = "Warning: " + message
+		}
+		fmt.Fprintln(w, message)
+		return
+	}
+
+	jw.log.mu.Lock()
+	defer jw.log.mu.Unlock()
+	jw.emit(level, message, nil)
+}
+
+// WithFields returns a writer whose messages include the given fields. Text output is unaffected.
+func WithFields(w io.Writer, fields Fields) io.Writer {
+	jw, ok := w.(*jsonWriter)
+	if !ok {
+		return w
+	}
+	return jw.log.writer(jw.dest, jw.level, jw.kind, jw.stream, merge(jw.fields, fields))
+}
+
+// Stream returns a writer for a command's output, which is recorded as a stream with the given name (usually
+// "stdout" or "stderr"). Text output is unaffected.
+func Stream(w io.Writer, name string) io.Writer {
+	jw, ok := w.(*jsonWriter)
+	if !ok {
+		return w
+	}
+	return jw.log.writer(jw.dest, LevelInfo, typeStream, name, jw.fields)
+}
+
+// Structured reports whether the writer produces JSON records.
+func Structured(w io.Writer) bool {
+	_, ok := w.(*jsonWriter)
+	return ok
+}
+
+// SetStep labels the records written from now on with the plan step that's running, given by its type and index.
+func SetStep(w io.Writer, step string, index int) {
+	jw, ok := w.(*jsonWriter)
+	if !ok {
+		return
+	}
+	jw.log.mu.Lock()
+	defer jw.log.mu.Unlock()
+	jw.log.step = step
+	jw.log.stepIndex = &index
+}
+
+// ClearStep stops labelling records with a plan step.
+func ClearStep(w io.Writer) {
+	jw, ok := w.(*jsonWriter)
+	if !ok {
+		return
+	}
+	jw.log.mu.Lock()
+	defer jw.log.mu.Unlock()
+	jw.log.step = ""
+	jw.log.stepIndex = nil
+}
+
+// Flush writes out any partial lines held by the writer, or any other writer that shares its state.
+func Flush(w io.Writer) {
+	jw, ok := w.(*jsonWriter)
+	if !ok {
+		return
+	}
+	jw.log.mu.Lock()
+	defer jw.log.mu.Unlock()
+	for _, writer := range jw.log.partial {
+		writer.flush()
+	}
+	jw.log.partial = nil
+}
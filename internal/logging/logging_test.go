@@ -0,0 +1,161 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type LoggingTestSuite struct {
+	suite.Suite
+	originalNow func() time.Time
+}
+
+func (suite *LoggingTestSuite) BeforeTest(_, _ string) {
+	suite.originalNow = now
+	now = func() time.Time { return time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC) }
+}
+
+func (suite *LoggingTestSuite) AfterTest(_, _ string) {
+	now = suite.originalNow
+}
+
+func TestLoggingTestSuite(t *testing.T) {
+	suite.Run(t, new(LoggingTestSuite))
+}
+
+// records parses the JSON records written to the builder.
+func (suite *LoggingTestSuite) records(out *strings.Builder) []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		record := map[string]interface{}{}
+		suite.Require().NoError(json.Unmarshal([]byte(line), &record), "every line should be a JSON object: %s", line)
+		records = append(records, record)
+	}
+	return records
+}
+
+func (suite *LoggingTestSuite) TestWrapText() {
+	stdout, stderr := &strings.Builder{}, &strings.Builder{}
+	out, err, wrapErr := Wrap("", stdout, stderr)
+	suite.Require().NoError(wrapErr)
+	suite.Same(stdout, out)
+	suite.Same(stderr, err)
+
+	out, err, wrapErr = Wrap("text", stdout, stderr)
+	suite.Require().NoError(wrapErr)
+	suite.Same(stdout, out)
+	suite.Same(stderr, err)
+
+	_, _, wrapErr = Wrap("xml", stdout, stderr)
+	suite.EqualError(wrapErr, "unknown log_format 'xml' (expected 'text' or 'json')")
+}
+
+func (suite *LoggingTestSuite) TestTextMessages() {
+	stderr := &strings.Builder{}
+	Debugf(stderr, "calling %s", "Upgrade.Execute")
+	Infof(stderr, "release %s deployed", "fangorn")
+	Warnf(stderr, "ignoring deprecated '%s' setting", "purge")
+	Errorf(WithFields(stderr, Fields{"release": "fangorn"}), "upgrade failed")
+	fmt.Fprint(Stream(stderr, "stderr"), "Release \"fangorn\" has been upgraded.\n")
+	SetStep(stderr, "Upgrade", 2)
+	Flush(stderr)
+
+	suite.Equal("calling Upgrade.Execute\n"+
+		"release fangorn deployed\n"+
+		"Warning: ignoring deprecated 'purge' setting\n"+
+		"upgrade failed\n"+
+		"Release \"fangorn\" has been upgraded.\n", stderr.String())
+	suite.False(Structured(stderr))
+}
+
+func (suite *LoggingTestSuite) TestJSONMessages() {
+	stdout, stderr := &strings.Builder{}, &strings.Builder{}
+	out, err, wrapErr := Wrap("json", stdout, stderr)
+	suite.Require().NoError(wrapErr)
+	suite.True(Structured(out))
+
+	Infof(out, "release %s deployed", "fangorn")
+	SetStep(err, "Upgrade", 2)
+	Warnf(WithFields(err, Fields{"attempt": 1}), "retrying")
+	ClearStep(err)
+	Errorf(err, "upgrade failed")
+
+	suite.Equal([]map[string]interface{}{{
+		"timestamp": "2026-10-19T10:00:00Z",
+		"level":     "info",
+		"type":      "message",
+		"message":   "release fangorn deployed",
+	}}, suite.records(stdout))
+	suite.Equal([]map[string]interface{}{{
+		"timestamp": "2026-10-19T10:00:00Z",
+		"level":     "warn",
+		"type":      "message",
+		"step":      "Upgrade",
+		"stepIndex": float64(2),
+		"message":   "retrying",
+		"fields":    map[string]interface{}{"attempt": float64(1)},
+	}, {
+		"timestamp": "2026-10-19T10:00:00Z",
+		"level":     "error",
+		"type":      "message",
+		"message":   "upgrade failed",
+	}}, suite.records(stderr))
+}
+
+func (suite *LoggingTestSuite) TestJSONStreams() {
+	stdout, stderr := &strings.Builder{}, &strings.Builder{}
+	_, err, wrapErr := Wrap("json", stdout, stderr)
+	suite.Require().NoError(wrapErr)
+	SetStep(err, "Upgrade", 0)
+
+	helmStderr := Stream(WithFields(err, Fields{"label": "fangorn"}), "stderr")
+	fmt.Fprint(helmStderr, "history.go:56: getting history\nupgrade.go:1")
+	fmt.Fprint(err, "a plain ")
+	suite.Len(suite.records(stderr), 1, "partial lines should be held")
+
+	fmt.Fprint(helmStderr, "42: preparing upgrade\n")
+	Flush(err)
+
+	records := suite.records(stderr)
+	suite.Require().Len(records, 3)
+	suite.Equal(map[string]interface{}{
+		"timestamp": "2026-10-19T10:00:00Z",
+		"level":     "info",
+		"type":      "stream",
+		"stream":    "stderr",
+		"step":      "Upgrade",
+		"stepIndex": float64(0),
+		"message":   "history.go:56: getting history",
+		"fields":    map[string]interface{}{"label": "fangorn"},
+	}, records[0])
+	suite.Equal("upgrade.go:142: preparing upgrade", records[1]["message"])
+	suite.Equal("a plain ", records[2]["message"], "flushing should write out partial lines")
+	suite.Equal("message", records[2]["type"])
+}
+
+func (suite *LoggingTestSuite) TestJSONOnlyTracksPartialLines() {
+	_, err, wrapErr := Wrap("json", &strings.Builder{}, &strings.Builder{})
+	suite.Require().NoError(wrapErr)
+	log := err.(*jsonWriter).log
+
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(Stream(WithFields(err, Fields{"attempt": i}), "stderr"), "attempt %d\n", i)
+	}
+	suite.Empty(log.partial, "writers without partial lines shouldn't be kept")
+
+	stream := Stream(err, "stdout")
+	fmt.Fprint(stream, "the road ")
+	fmt.Fprint(stream, "goes ")
+	suite.Len(log.partial, 1)
+	fmt.Fprint(stream, "ever on\n")
+	suite.Empty(log.partial, "a writer should be forgotten once its line is complete")
+
+	fmt.Fprint(stream, "and on")
+	Flush(err)
+	suite.Empty(log.partial, "a writer should be forgotten once it's flushed")
+}
@@ -3,6 +3,7 @@ package run
 import (
 	"fmt"
 	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
 	"strings"
 )
 
@@ -11,6 +12,9 @@ type AddRepo struct {
 	*config
 	repo  string
 	certs *repoCerts
+	retry *retryPolicy
+	errs  *errorCapture
+	args  []string
 	cmd   cmd
 }
 
@@ -20,12 +24,14 @@ func NewAddRepo(cfg env.Config, repo string) *AddRepo {
 		config: newConfig(cfg),
 		repo:   repo,
 		certs:  newRepoCerts(cfg),
+		retry:  newRetryPolicy(cfg),
+		errs:   newErrorCapture(),
 	}
 }
 
 // Execute executes the `helm repo add` command.
 func (a *AddRepo) Execute() error {
-	return a.cmd.Run()
+	return a.errs.classify(a.retry.run(a.cmd, a.args, nil))
 }
 
 // Prepare gets the AddRepo ready to execute.
@@ -41,6 +47,9 @@ func (a *AddRepo) Prepare() error {
 	if err := a.certs.write(); err != nil {
 		return err
 	}
+	if err := a.retry.prepare(); err != nil {
+		return err
+	}
 
 	name := split[0]
 	url := split[1]
@@ -50,13 +59,19 @@ func (a *AddRepo) Prepare() error {
 	args = append(args, a.certs.flags()...)
 	args = append(args, name, url)
 
+	a.args = args
 	a.cmd = command(helmBin, args...)
-	a.cmd.Stdout(a.stdout)
-	a.cmd.Stderr(a.stderr)
+	a.cmd.Stdout(logging.Stream(a.stdout, "stdout"))
+	a.cmd.Stderr(a.retry.output(logging.Stream(a.stderr, "stderr"), a.errs))
 
 	if a.debug {
-		fmt.Fprintf(a.stderr, "Generated command: '%s'\n", a.cmd.String())
+		logging.Debugf(a.stderr, "Generated command: '%s'", a.cmd.String())
 	}
 
 	return nil
 }
+
+// Command returns the `helm repo add` command, with any password in the repository's URL hidden.
+func (a *AddRepo) Command() string {
+	return redactCommand(a.args)
+}
@@ -0,0 +1,58 @@
+package run
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+var errNotStarted = errors.New("not started, since drone-helm3 is stopping")
+
+// running tracks the commands that have been started and not yet finished, so they can be stopped by Cancel.
+var running = struct {
+	sync.Mutex
+	cancelled bool
+	processes map[*os.Process]struct{}
+}{processes: map[*os.Process]struct{}{}}
+
+// Cancel passes the given signal on to any helm commands that are running, and stops any more from starting. Steps
+// that fail as a result return a HelmError whose Cancelled method returns true.
+func Cancel(sig os.Signal) {
+	running.Lock()
+	defer running.Unlock()
+
+	running.cancelled = true
+	for process := range running.processes {
+		process.Signal(sig)
+	}
+}
+
+func cancelled() bool {
+	running.Lock()
+	defer running.Unlock()
+	return running.cancelled
+}
+
+// Run starts the command and waits for it to finish, keeping track of it so that it can be cancelled.
+func (c *execCmd) Run() error {
+	running.Lock()
+	if running.cancelled {
+		running.Unlock()
+		return errNotStarted
+	}
+	err := c.Cmd.Start()
+	if err == nil {
+		running.processes[c.Cmd.Process] = struct{}{}
+	}
+	running.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = c.Cmd.Wait()
+
+	running.Lock()
+	delete(running.processes, c.Cmd.Process)
+	running.Unlock()
+	return err
+}
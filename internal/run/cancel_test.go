@@ -0,0 +1,57 @@
+package run
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CancelTestSuite struct {
+	suite.Suite
+}
+
+func (suite *CancelTestSuite) AfterTest(_, _ string) {
+	running.Lock()
+	defer running.Unlock()
+	running.cancelled = false
+}
+
+func TestCancelTestSuite(t *testing.T) {
+	suite.Run(t, new(CancelTestSuite))
+}
+
+func (suite *CancelTestSuite) TestCancelStopsRunningCommands() {
+	c := command("/bin/sh", "-c", "sleep 30")
+	done := make(chan error)
+	go func() { done <- c.Run() }()
+
+	suite.Eventually(func() bool {
+		running.Lock()
+		defer running.Unlock()
+		return len(running.processes) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	Cancel(syscall.SIGTERM)
+	select {
+	case err := <-done:
+		suite.Error(err)
+	case <-time.After(5 * time.Second):
+		suite.Fail("the command should have been stopped")
+	}
+	suite.Empty(running.processes)
+	suite.True(cancelled())
+
+	err := newErrorCapture().classify(errors.New("signal: terminated"))
+	helmErr := &HelmError{}
+	suite.Require().True(errors.As(err, &helmErr))
+	suite.True(helmErr.Cancelled())
+	suite.EqualError(helmErr, "cancelled: signal: terminated")
+}
+
+func (suite *CancelTestSuite) TestNothingStartsAfterCancel() {
+	Cancel(syscall.SIGTERM)
+	suite.Equal(errNotStarted, command("/bin/true").Run())
+}
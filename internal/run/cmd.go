@@ -2,13 +2,23 @@ package run
 
 import (
 	"io"
+	"net/url"
 	"os"
 	"os/exec"
+	"strings"
 	"syscall"
 )
 
 const helmBin = "/usr/bin/helm"
 
+// secretFlags are the helm flags whose values may include secrets, such as interpolated values.
+var secretFlags = map[string]bool{
+	"--set":        true,
+	"--set-string": true,
+	"--password":   true,
+	"--kube-token": true,
+}
+
 // The cmd interface provides a generic form of exec.Cmd so that it can be mocked out in tests.
 type cmd interface {
 	// methods that exist on exec.Cmd
@@ -61,3 +71,31 @@ func (c *execCmd) SysProcAttr(s *syscall.SysProcAttr) { c.Cmd.SysProcAttr = s }
 
 func (c *execCmd) Process() *os.Process           { return c.Cmd.Process }
 func (c *execCmd) ProcessState() *os.ProcessState { return c.Cmd.ProcessState }
+
+// redactCommand returns the command line for running helm with the given args, with the values of secretFlags and
+// any passwords in URLs hidden. It returns "" if there are no args. Steps use it to implement a Command method, which
+// reports the command they run for the execution report; since they build their args in Prepare, the command is ""
+// until they've been prepared.
+func redactCommand(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	words := []string{helmBin}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if flag, _, found := strings.Cut(arg, "="); found && secretFlags[flag] {
+			words = append(words, flag+"=(redacted)")
+			continue
+		}
+		if secretFlags[arg] && i+1 < len(args) {
+			words = append(words, arg, "(redacted)")
+			i++
+			continue
+		}
+		if u, err := url.Parse(arg); err == nil && u.User != nil {
+			arg = u.Redacted()
+		}
+		words = append(words, arg)
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,64 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
+)
+
+// DeleteNamespace is an execution step that deletes a kubernetes namespace, along with everything in it.
+type DeleteNamespace struct {
+	*config
+	kubeconfigFile string
+	dryRun         bool
+}
+
+// NewDeleteNamespace creates a DeleteNamespace for the Config's namespace, using the credentials in the given kubeconfig
+// file. No validation is performed at this time.
+func NewDeleteNamespace(cfg env.Config, kubeconfigFile string) *DeleteNamespace {
+	return &DeleteNamespace{
+		config:         newConfig(cfg),
+		kubeconfigFile: kubeconfigFile,
+		dryRun:         cfg.DryRun,
+	}
+}
+
+// Prepare ensures there's a namespace to delete.
+func (d *DeleteNamespace) Prepare() error {
+	if d.namespace == "" {
+		return errors.New("namespace is required")
+	}
+	return validateNamespace(d.namespace)
+}
+
+// Execute deletes the namespace. A namespace that doesn't exist is not considered an error.
+func (d *DeleteNamespace) Execute() error {
+	// the kubeconfig is read here rather than in Prepare, since InitKube doesn't write it until it executes
+	kube, err := newKubeClient(d.kubeconfigFile)
+	if err != nil {
+		return err
+	}
+
+	path := "/api/v1/namespaces/" + url.PathEscape(d.namespace)
+	if d.dryRun {
+		path += "?dryRun=All"
+	}
+	if d.debug {
+		logging.Debugf(d.stderr, "deleting namespace via %s %s", http.MethodDelete, path)
+	}
+
+	err = kube.do(http.MethodDelete, path, nil)
+	if errors.Is(err, errNotFound) {
+		logging.Infof(d.stdout, "namespace \"%s\" not found; nothing to delete", d.namespace)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not delete namespace %s: %w", d.namespace, err)
+	}
+	logging.Infof(d.stdout, "namespace \"%s\" deleted", d.namespace)
+	return nil
+}
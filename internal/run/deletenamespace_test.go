@@ -0,0 +1,63 @@
+package run
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/stretchr/testify/suite"
+)
+
+type DeleteNamespaceTestSuite struct {
+	suite.Suite
+}
+
+func TestDeleteNamespaceTestSuite(t *testing.T) {
+	suite.Run(t, new(DeleteNamespaceTestSuite))
+}
+
+func (suite *DeleteNamespaceTestSuite) TestNewDeleteNamespace() {
+	d := NewDeleteNamespace(env.Config{Namespace: "moria", DryRun: true}, "/root/.kube/config")
+	suite.Equal("moria", d.namespace)
+	suite.Equal("/root/.kube/config", d.kubeconfigFile)
+	suite.True(d.dryRun)
+}
+
+func (suite *DeleteNamespaceTestSuite) TestPrepare() {
+	suite.EqualError(NewDeleteNamespace(env.Config{}, "").Prepare(), "namespace is required")
+	suite.EqualError(NewDeleteNamespace(env.Config{Namespace: "Moria"}, "").Prepare(),
+		"namespace 'Moria' must consist of lowercase letters, numbers and '-'")
+	suite.NoError(NewDeleteNamespace(env.Config{Namespace: "moria"}, "").Prepare())
+}
+
+func (suite *DeleteNamespaceTestSuite) TestExecute() {
+	var requests []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.String())
+		if r.URL.Path == "/api/v1/namespaces/erebor" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"kind": "Namespace"}`))
+	}))
+	defer server.Close()
+	kubeconfig := writeTestKubeconfig(suite.T(), server, "dGhlIGFya2Vuc3RvbmU=")
+	defer os.Remove(kubeconfig)
+
+	stdout := strings.Builder{}
+	cfg := env.Config{Namespace: "moria", Stdout: &stdout}
+	d := NewDeleteNamespace(cfg, kubeconfig)
+	suite.Require().NoError(d.Prepare())
+	suite.Require().NoError(d.Execute())
+
+	cfg.Namespace = "erebor"
+	cfg.DryRun = true
+	d = NewDeleteNamespace(cfg, kubeconfig)
+	suite.Require().NoError(d.Execute(), "a missing namespace shouldn't be an error")
+
+	suite.Equal([]string{"DELETE /api/v1/namespaces/moria", "DELETE /api/v1/namespaces/erebor?dryRun=All"}, requests)
+	suite.Equal("namespace \"moria\" deleted\nnamespace \"erebor\" not found; nothing to delete\n", stdout.String())
+}
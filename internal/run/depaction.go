@@ -4,6 +4,7 @@ import (
   "errors"
   "fmt"
   "github.com/pelotech/drone-helm3/internal/env"
+  "github.com/pelotech/drone-helm3/internal/logging"
 )
 
 const (
@@ -16,7 +17,10 @@ type DepAction struct {
   *config
   chart  string
   cmd    cmd
+  args   []string
   action string
+  retry  *retryPolicy
+  errs   *errorCapture
 }
 
 // NewDepAction creates a DepAction using fields from the given Config. No validation is performed at this time.
@@ -25,12 +29,14 @@ func NewDepAction(cfg env.Config) *DepAction {
     config: newConfig(cfg),
     chart:  cfg.Chart,
     action: cfg.DependenciesAction,
+    retry:  newRetryPolicy(cfg),
+    errs:   newErrorCapture(),
   }
 }
 
 // Execute executes the `helm upgrade` command.
 func (d *DepAction) Execute() error {
-  return d.cmd.Run()
+  return d.errs.classify(d.retry.run(d.cmd, d.args, nil))
 }
 
 // Prepare gets the DepAction ready to execute.
@@ -45,15 +51,25 @@ func (d *DepAction) Prepare() error {
     return errors.New("unknown dependency_action: " + d.action)
   }
 
+  if err := d.retry.prepare(); err != nil {
+    return err
+  }
+
   args = append(args, "dependency", d.action, d.chart)
 
+  d.args = args
   d.cmd = command(helmBin, args...)
-  d.cmd.Stdout(d.stdout)
-  d.cmd.Stderr(d.stderr)
+  d.cmd.Stdout(logging.Stream(d.stdout, "stdout"))
+  d.cmd.Stderr(d.retry.output(logging.Stream(d.stderr, "stderr"), d.errs))
 
   if d.debug {
-    fmt.Fprintf(d.stderr, "Generated command: '%s'\n", d.cmd.String())
+    logging.Debugf(d.stderr, "Generated command: '%s'", d.cmd.String())
   }
 
   return nil
 }
+
+// Command returns the `helm dependency` command for the chart.
+func (d *DepAction) Command() string {
+  return redactCommand(d.args)
+}
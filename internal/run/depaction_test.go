@@ -61,7 +61,7 @@ func (suite *DepActionTestSuite) TestPrepareAndExecuteBuild() {
   suite.mockCmd.EXPECT().
     Stdout(&stdout)
   suite.mockCmd.EXPECT().
-    Stderr(&stderr)
+    Stderr(writesTo(&stderr))
   suite.mockCmd.EXPECT().
     Run().
     Times(1)
@@ -93,7 +93,7 @@ func (suite *DepActionTestSuite) TestPrepareAndExecuteUpdate() {
   suite.mockCmd.EXPECT().
     Stdout(&stdout)
   suite.mockCmd.EXPECT().
-    Stderr(&stderr)
+    Stderr(writesTo(&stderr))
   suite.mockCmd.EXPECT().
     Run().
     Times(1)
@@ -3,12 +3,15 @@ package run
 import (
 	"fmt"
 	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
 )
 
 // DepUpdate is an execution step that calls `helm dependency update` when executed.
 type DepUpdate struct {
 	*config
 	chart string
+	errs  *errorCapture
+	args  []string
 	cmd   cmd
 }
 
@@ -17,12 +20,13 @@ func NewDepUpdate(cfg env.Config) *DepUpdate {
 	return &DepUpdate{
 		config: newConfig(cfg),
 		chart:  cfg.Chart,
+		errs:   newErrorCapture(),
 	}
 }
 
 // Execute executes the `helm upgrade` command.
 func (d *DepUpdate) Execute() error {
-	return d.cmd.Run()
+	return d.errs.classify(d.cmd.Run())
 }
 
 // Prepare gets the DepUpdate ready to execute.
@@ -34,13 +38,19 @@ func (d *DepUpdate) Prepare() error {
 	args := d.globalFlags()
 	args = append(args, "dependency", "update", d.chart)
 
+	d.args = args
 	d.cmd = command(helmBin, args...)
-	d.cmd.Stdout(d.stdout)
-	d.cmd.Stderr(d.stderr)
+	d.cmd.Stdout(logging.Stream(d.stdout, "stdout"))
+	d.cmd.Stderr(d.errs.output(logging.Stream(d.stderr, "stderr")))
 
 	if d.debug {
-		fmt.Fprintf(d.stderr, "Generated command: '%s'\n", d.cmd.String())
+		logging.Debugf(d.stderr, "Generated command: '%s'", d.cmd.String())
 	}
 
 	return nil
 }
+
+// Command returns the `helm dependency update` command for the chart.
+func (d *DepUpdate) Command() string {
+	return redactCommand(d.args)
+}
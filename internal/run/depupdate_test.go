@@ -59,7 +59,7 @@ func (suite *DepUpdateTestSuite) TestPrepareAndExecute() {
 	suite.mockCmd.EXPECT().
 		Stdout(&stdout)
 	suite.mockCmd.EXPECT().
-		Stderr(&stderr)
+		Stderr(writesTo(&stderr))
 	suite.mockCmd.EXPECT().
 		Run().
 		Times(1)
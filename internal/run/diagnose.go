@@ -0,0 +1,272 @@
+package run
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
+)
+
+const (
+	diagnosticEventCount = 20
+	diagnosticLogLines   = 20
+	maxDiagnosedPods     = 5
+)
+
+// Diagnose is an execution step that reports on the state of a release, for working out why it failed to deploy.
+type Diagnose struct {
+	*config
+	release        string
+	kubeconfigFile string
+	reportFile     string
+}
+
+// NewDiagnose creates a Diagnose for the Config's release, using the credentials in the given kubeconfig file. No
+// validation is performed at this time.
+func NewDiagnose(cfg env.Config, kubeconfigFile string) *Diagnose {
+	return &Diagnose{
+		config:         newConfig(cfg),
+		release:        cfg.Release,
+		kubeconfigFile: kubeconfigFile,
+		reportFile:     cfg.DiagnosticsFile,
+	}
+}
+
+// Prepare ensures there's a release to diagnose.
+func (d *Diagnose) Prepare() error {
+	if d.release == "" {
+		return errors.New("release is required")
+	}
+	return nil
+}
+
+// Execute prints the report, and writes it to the report file if there is one. Anything that can't be collected is
+// noted in the report, rather than being treated as an error.
+func (d *Diagnose) Execute() error {
+	report := &bytes.Buffer{}
+	d.writeReport(report)
+	d.stderr.Write(report.Bytes())
+
+	if d.reportFile != "" {
+		if err := ioutil.WriteFile(d.reportFile, report.Bytes(), 0644); err != nil {
+			return fmt.Errorf("could not write diagnostics file: %w", err)
+		}
+		logging.Infof(d.stderr, "diagnostics written to %s", d.reportFile)
+	}
+	return nil
+}
+
+func (d *Diagnose) writeReport(w io.Writer) {
+	namespace := d.namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	fmt.Fprintf(w, "==== diagnostics for release %s in namespace %s ====\n", d.release, namespace)
+
+	status, err := getReleaseStatus(d.config, d.release)
+	switch {
+	case err != nil:
+		fmt.Fprintf(w, "\nstatus: %s\n", err)
+	case status == nil:
+		fmt.Fprintf(w, "\nstatus: release not found\n")
+	default:
+		fmt.Fprintf(w, "\nstatus: revision %d is %s: %s\n", status.Version, status.Info.Status, status.Info.Description)
+	}
+
+	fmt.Fprintf(w, "\nresources:\n")
+	resources, err := getManifest(d.config, d.release, 0)
+	if err != nil {
+		fmt.Fprintf(w, "  %s\n", err)
+	}
+	for _, resource := range resources {
+		fmt.Fprintf(w, "  %s\n", resource.id)
+	}
+
+	kube, err := newKubeClient(d.kubeconfigFile)
+	if err != nil {
+		fmt.Fprintf(w, "\ncould not connect to kubernetes: %s\n", err)
+		return
+	}
+	writeEvents(w, kube, namespace)
+	d.writePods(w, kube, namespace)
+}
+
+type kubeEvent struct {
+	Type           string `json:"type"`
+	Reason         string `json:"reason"`
+	Message        string `json:"message"`
+	LastTimestamp  string `json:"lastTimestamp"`
+	EventTime      string `json:"eventTime"`
+	InvolvedObject struct {
+		Kind string `json:"kind"`
+		Name string `json:"name"`
+	} `json:"involvedObject"`
+}
+
+// time returns when the event last happened. Events from newer APIs may only have an eventTime.
+func (e kubeEvent) time() time.Time {
+	stamp := e.LastTimestamp
+	if stamp == "" {
+		stamp = e.EventTime
+	}
+	t, _ := time.Parse(time.RFC3339, stamp)
+	return t
+}
+
+// writeEvents writes the most recent of the namespace's events.
+func writeEvents(w io.Writer, kube *kubeClient, namespace string) {
+	var events struct {
+		Items []kubeEvent `json:"items"`
+	}
+	if err := kube.do(http.MethodGet, "/api/v1/namespaces/"+url.PathEscape(namespace)+"/events", &events); err != nil {
+		fmt.Fprintf(w, "\ncould not get events: %s\n", err)
+		return
+	}
+	if len(events.Items) == 0 {
+		fmt.Fprintf(w, "\nno recent events in namespace %s\n", namespace)
+		return
+	}
+
+	sort.SliceStable(events.Items, func(i, j int) bool {
+		return events.Items[i].time().Before(events.Items[j].time())
+	})
+	if len(events.Items) > diagnosticEventCount {
+		events.Items = events.Items[len(events.Items)-diagnosticEventCount:]
+	}
+
+	fmt.Fprintf(w, "\nrecent events in namespace %s:\n", namespace)
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "  AGE\tTYPE\tREASON\tOBJECT\tMESSAGE\n")
+	for _, event := range events.Items {
+		fmt.Fprintf(table, "  %s\t%s\t%s\t%s/%s\t%s\n", formatAge(now().Sub(event.time())), event.Type, event.Reason,
+			event.InvolvedObject.Kind, event.InvolvedObject.Name, strings.TrimSpace(event.Message))
+	}
+	table.Flush()
+}
+
+type kubePod struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Status struct {
+		Phase      string `json:"phase"`
+		Conditions []struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"conditions"`
+		ContainerStatuses []kubeContainerStatus `json:"containerStatuses"`
+	} `json:"status"`
+}
+
+type kubeContainerStatus struct {
+	Name         string `json:"name"`
+	Ready        bool   `json:"ready"`
+	RestartCount int    `json:"restartCount"`
+	State        struct {
+		Waiting *struct {
+			Reason string `json:"reason"`
+		} `json:"waiting"`
+		Terminated *struct {
+			Reason   string `json:"reason"`
+			ExitCode int    `json:"exitCode"`
+		} `json:"terminated"`
+	} `json:"state"`
+}
+
+// ready reports whether the pod is ready, or has finished successfully.
+func (p kubePod) ready() bool {
+	if p.Status.Phase == "Succeeded" {
+		return true
+	}
+	for _, condition := range p.Status.Conditions {
+		if condition.Type == "Ready" {
+			return condition.Status == "True"
+		}
+	}
+	return false
+}
+
+// state describes why a container isn't ready.
+func (c kubeContainerStatus) state() string {
+	switch {
+	case c.State.Waiting != nil:
+		return "waiting: " + c.State.Waiting.Reason
+	case c.State.Terminated != nil:
+		return fmt.Sprintf("terminated: %s (exit code %d)", c.State.Terminated.Reason, c.State.Terminated.ExitCode)
+	default:
+		return "running, but not ready"
+	}
+}
+
+// writePods writes the state and recent logs of the release's pods that aren't ready. Pods are matched by the
+// app.kubernetes.io/instance label, which most charts set; if none have it, all the pods in the namespace are checked.
+func (d *Diagnose) writePods(w io.Writer, kube *kubeClient, namespace string) {
+	podsPath := "/api/v1/namespaces/" + url.PathEscape(namespace) + "/pods"
+	var pods struct {
+		Items []kubePod `json:"items"`
+	}
+	selector := url.Values{"labelSelector": {"app.kubernetes.io/instance=" + d.release}}
+	err := kube.do(http.MethodGet, podsPath+"?"+selector.Encode(), &pods)
+	if err == nil && len(pods.Items) == 0 {
+		err = kube.do(http.MethodGet, podsPath, &pods)
+	}
+	if err != nil {
+		fmt.Fprintf(w, "\ncould not get pods: %s\n", err)
+		return
+	}
+
+	var notReady []kubePod
+	for _, pod := range pods.Items {
+		if !pod.ready() {
+			notReady = append(notReady, pod)
+		}
+	}
+	if len(notReady) == 0 {
+		fmt.Fprintf(w, "\nall pods are ready\n")
+		return
+	}
+	if len(notReady) > maxDiagnosedPods {
+		fmt.Fprintf(w, "\n%d pods aren't ready; showing the first %d\n", len(notReady), maxDiagnosedPods)
+		notReady = notReady[:maxDiagnosedPods]
+	}
+
+	for _, pod := range notReady {
+		fmt.Fprintf(w, "\npod %s is %s:\n", pod.Metadata.Name, pod.Status.Phase)
+		for _, container := range pod.Status.ContainerStatuses {
+			if container.Ready {
+				continue
+			}
+			fmt.Fprintf(w, "  container %s is %s, after %d restarts\n", container.Name, container.state(),
+				container.RestartCount)
+
+			query := url.Values{"container": {container.Name}, "tailLines": {fmt.Sprint(diagnosticLogLines)}}
+			if container.RestartCount > 0 && container.State.Waiting != nil {
+				// a crashing container's own logs are gone; the previous one's say why it crashed
+				query.Set("previous", "true")
+			}
+			logs, err := kube.text(podsPath + "/" + url.PathEscape(pod.Metadata.Name) + "/log?" + query.Encode())
+			if err != nil {
+				fmt.Fprintf(w, "    could not get logs: %s\n", err)
+				continue
+			}
+			logs = strings.TrimRight(logs, "\n")
+			if logs == "" {
+				fmt.Fprintf(w, "    (no logs)\n")
+				continue
+			}
+			for _, line := range strings.Split(logs, "\n") {
+				fmt.Fprintf(w, "    %s\n", line)
+			}
+		}
+	}
+}
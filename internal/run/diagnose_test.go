@@ -0,0 +1,171 @@
+package run
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/stretchr/testify/suite"
+)
+
+const diagnosePods = `{"items": [
+	{"metadata": {"name": "orthanc-5d8f-ready"}, "status": {"phase": "Running",
+		"conditions": [{"type": "Ready", "status": "True"}],
+		"containerStatuses": [{"name": "palantir", "ready": true}]}},
+	{"metadata": {"name": "orthanc-5d8f-crashing"}, "status": {"phase": "Running",
+		"conditions": [{"type": "Ready", "status": "False"}],
+		"containerStatuses": [{"name": "palantir", "ready": false, "restartCount": 4,
+			"state": {"waiting": {"reason": "CrashLoopBackOff"}}}]}}
+]}`
+
+const diagnoseEvents = `{"items": [
+	{"type": "Warning", "reason": "BackOff", "message": "Back-off restarting failed container",
+		"lastTimestamp": "2026-10-19T09:58:00Z", "involvedObject": {"kind": "Pod", "name": "orthanc-5d8f-crashing"}},
+	{"type": "Normal", "reason": "Scheduled", "message": "Successfully assigned isengard/orthanc-5d8f-crashing",
+		"eventTime": "2026-10-19T09:50:00.000000Z", "involvedObject": {"kind": "Pod", "name": "orthanc-5d8f-crashing"}}
+]}`
+
+const diagnoseManifest = `---
+# Source: orthanc/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: orthanc
+---
+# Source: orthanc/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: orthanc
+`
+
+type DiagnoseTestSuite struct {
+	suite.Suite
+	ctrl        *gomock.Controller
+	originalNow func() time.Time
+	requests    []string
+}
+
+func (suite *DiagnoseTestSuite) BeforeTest(_, _ string) {
+	suite.ctrl = gomock.NewController(suite.T())
+	suite.originalNow = now
+	now = func() time.Time { return time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC) }
+	suite.requests = nil
+}
+
+func (suite *DiagnoseTestSuite) AfterTest(_, _ string) {
+	suite.ctrl.Finish()
+	now = suite.originalNow
+}
+
+func TestDiagnoseTestSuite(t *testing.T) {
+	suite.Run(t, new(DiagnoseTestSuite))
+}
+
+func (suite *DiagnoseTestSuite) server() *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.requests = append(suite.requests, r.URL.String())
+		switch r.URL.Path {
+		case "/api/v1/namespaces/isengard/events":
+			w.Write([]byte(diagnoseEvents))
+		case "/api/v1/namespaces/isengard/pods":
+			w.Write([]byte(diagnosePods))
+		case "/api/v1/namespaces/isengard/pods/orthanc-5d8f-crashing/log":
+			w.Write([]byte("panic: the palantir is clouded\ngoroutine 1 [running]:\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func (suite *DiagnoseTestSuite) TestPrepare() {
+	suite.EqualError(NewDiagnose(env.Config{}, "").Prepare(), "release is required")
+	suite.NoError(NewDiagnose(env.Config{Release: "orthanc"}, "").Prepare())
+}
+
+func (suite *DiagnoseTestSuite) TestExecute() {
+	server := suite.server()
+	defer server.Close()
+	kubeconfig := writeTestKubeconfig(suite.T(), server, "c2FydW1hbg==")
+	defer os.Remove(kubeconfig)
+
+	calls, restore := scriptedCommands(suite.ctrl,
+		scriptedRun{stdout: `{"name": "orthanc", "version": 7, "info": {"status": "failed", ` +
+			`"description": "Upgrade \"orthanc\" failed: timed out waiting for the condition"}}`},
+		scriptedRun{stdout: diagnoseManifest},
+	)
+	defer restore()
+
+	stderr := &strings.Builder{}
+	d := NewDiagnose(env.Config{Release: "orthanc", Namespace: "isengard", Stderr: stderr}, kubeconfig)
+	suite.Require().NoError(d.Prepare())
+	suite.Require().NoError(d.Execute())
+
+	suite.Equal([][]string{
+		{"--namespace", "isengard", "status", "orthanc", "--output", "json"},
+		{"--namespace", "isengard", "get", "manifest", "orthanc"},
+	}, *calls)
+	suite.Equal([]string{
+		"/api/v1/namespaces/isengard/events",
+		"/api/v1/namespaces/isengard/pods?labelSelector=app.kubernetes.io%2Finstance%3Dorthanc",
+		"/api/v1/namespaces/isengard/pods/orthanc-5d8f-crashing/log?container=palantir&previous=true&tailLines=20",
+	}, suite.requests)
+
+	suite.Equal(`==== diagnostics for release orthanc in namespace isengard ====
+
+status: revision 7 is failed: Upgrade "orthanc" failed: timed out waiting for the condition
+
+resources:
+  Service/orthanc
+  Deployment/orthanc
+
+recent events in namespace isengard:
+  AGE  TYPE     REASON     OBJECT                     MESSAGE
+  10m  Normal   Scheduled  Pod/orthanc-5d8f-crashing  Successfully assigned isengard/orthanc-5d8f-crashing
+  2m   Warning  BackOff    Pod/orthanc-5d8f-crashing  Back-off restarting failed container
+
+pod orthanc-5d8f-crashing is Running:
+  container palantir is waiting: CrashLoopBackOff, after 4 restarts
+    panic: the palantir is clouded
+    goroutine 1 [running]:
+`, stderr.String())
+}
+
+func (suite *DiagnoseTestSuite) TestExecuteWritesReportFile() {
+	_, restore := scriptedCommands(suite.ctrl,
+		scriptedRun{stderr: "Error: release: not found\n", err: errors.New("exit status 1")},
+		scriptedRun{stderr: "Error: release: not found\n", err: errors.New("exit status 1")},
+	)
+	defer restore()
+
+	dir, err := ioutil.TempDir("", "diagnostics")
+	suite.Require().NoError(err)
+	defer os.RemoveAll(dir)
+	reportFile := filepath.Join(dir, "diagnostics.txt")
+
+	stderr := &strings.Builder{}
+	cfg := env.Config{Release: "orthanc", Stderr: stderr, DiagnosticsFile: reportFile}
+	d := NewDiagnose(cfg, filepath.Join(dir, "no-kubeconfig"))
+	suite.Require().NoError(d.Execute(), "missing information should be noted in the report, not returned")
+
+	report, err := ioutil.ReadFile(reportFile)
+	suite.Require().NoError(err)
+	suite.Contains(string(report), "==== diagnostics for release orthanc in namespace default ====\n")
+	suite.Contains(string(report), "status: release not found\n")
+	suite.Contains(string(report), "could not get manifest: exit status 1: Error: release: not found\n")
+	suite.Contains(string(report), "could not connect to kubernetes: could not read kubeconfig")
+	suite.Equal(string(report)+"diagnostics written to "+reportFile+"\n", stderr.String())
+
+	d.reportFile = filepath.Join(dir, "missing", "diagnostics.txt")
+	err = d.Execute()
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "could not write diagnostics file")
+}
@@ -0,0 +1,349 @@
+package run
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"gopkg.in/yaml.v2"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
+)
+
+const (
+	redactedValue  = "(redacted)"
+	changedValue   = "(redacted, changed)"
+	redactedSecret = "# (Secret could not be parsed, so it isn't shown)"
+
+	// minSecretLength is the length of the shortest secret value that's hidden in diffs. Shorter values, such as
+	// replica counts and booleans, would hide far more of a diff than they protect.
+	minSecretLength = 4
+)
+
+// A ReleaseDiff describes the changes that upgrading a release would make to its resources.
+type ReleaseDiff struct {
+	Release   string
+	Namespace string
+	Changes   []ResourceChange
+	Text      string // a unified diff of the changed resources, with secret values redacted
+}
+
+// Diff is an execution step that renders the chart with `helm template` and compares the result with the release's
+// live manifest.
+type Diff struct {
+	*config
+	chart   string
+	release string
+
+	chartVersion string
+	values       string
+	stringValues string
+	valuesFiles  []string
+	secrets      []string
+	certs        *repoCerts
+	resolver     *valuesResolver
+	errs         *errorCapture
+
+	args     []string
+	cmd      cmd
+	rendered *bytes.Buffer
+	result   *ReleaseDiff
+}
+
+// NewDiff creates a Diff using fields from the given Config. No validation is performed at this time.
+func NewDiff(cfg env.Config) *Diff {
+	return &Diff{
+		config:       newConfig(cfg),
+		chart:        cfg.Chart,
+		release:      cfg.Release,
+		chartVersion: cfg.ChartVersion,
+		values:       cfg.Values,
+		stringValues: cfg.StringValues,
+		valuesFiles:  cfg.ValuesFiles,
+		secrets:      cfg.SecretValues,
+		certs:        newRepoCerts(cfg),
+		resolver:     newValuesResolver(cfg),
+		errs:         newErrorCapture(),
+	}
+}
+
+// Prepare gets the Diff ready to execute.
+func (d *Diff) Prepare() error {
+	if d.chart == "" {
+		return fmt.Errorf("chart is required")
+	}
+	if d.release == "" {
+		return fmt.Errorf("release is required")
+	}
+	if err := validateReleaseName(d.release); err != nil {
+		return err
+	}
+	if err := validateNamespace(d.namespace); err != nil {
+		return err
+	}
+
+	args := d.globalFlags()
+	args = append(args, "template", "--no-hooks")
+
+	if d.chartVersion != "" {
+		args = append(args, "--version", d.chartVersion)
+	}
+	if d.values != "" {
+		args = append(args, "--set", d.values)
+	}
+	if d.stringValues != "" {
+		args = append(args, "--set-string", d.stringValues)
+	}
+	valuesFiles, err := d.resolver.resolve(d.valuesFiles)
+	if err != nil {
+		return err
+	}
+	for _, vFile := range valuesFiles {
+		args = append(args, "--values", vFile)
+	}
+	args = append(args, d.certs.flags()...)
+
+	args = append(args, d.release, d.chart)
+	d.args = args
+	d.rendered = &bytes.Buffer{}
+	d.cmd = command(helmBin, args...)
+	d.cmd.Stdout(d.rendered)
+	d.cmd.Stderr(d.errs.output(logging.Stream(d.stderr, "stderr")))
+
+	if d.debug {
+		logging.Debugf(d.stderr, "Generated command: '%s'", d.cmd.String())
+	}
+
+	return nil
+}
+
+// Execute renders the chart, compares it with the live release, and prints the differences.
+func (d *Diff) Execute() error {
+	var live []manifestResource
+	status, err := getReleaseStatus(d.config, d.release)
+	if err != nil {
+		return err
+	}
+	if status != nil {
+		if live, err = getManifest(d.config, d.release, 0); err != nil {
+			return err
+		}
+	}
+
+	if err := d.errs.classify(d.cmd.Run()); err != nil {
+		return err
+	}
+	rendered, err := parseManifest(d.rendered.String())
+	if err != nil {
+		return err
+	}
+
+	d.result = &ReleaseDiff{Release: d.release, Namespace: d.namespace}
+	d.result.Changes, d.result.Text = compareManifests(live, rendered)
+	d.result.Text = scrubSecrets(d.result.Text, d.secrets, d.resolver.secrets)
+
+	stdout := logging.WithFields(d.stdout, logging.Fields{"release": d.release})
+	if len(d.result.Changes) == 0 {
+		logging.Infof(stdout, "release %s: no changes", d.release)
+		return nil
+	}
+	logging.Infof(stdout, "release %s: %s", d.release, d.result.Summary())
+	_, err = io.WriteString(logging.Stream(d.stdout, "stdout"), d.result.Text)
+	return err
+}
+
+// Cleanup removes any decrypted values files created by Prepare.
+func (d *Diff) Cleanup() {
+	d.resolver.cleanup()
+}
+
+// Command returns the `helm template` command that renders the chart to compare with the release, with any
+// --set and --set-string values hidden.
+func (d *Diff) Command() string {
+	return redactCommand(d.args)
+}
+
+// Result returns what Execute found out about the release, or nil if it hasn't been executed.
+func (d *Diff) Result() *ReleaseDiff {
+	return d.result
+}
+
+// Summary counts the changes, such as "1 to create, 2 to change".
+func (r *ReleaseDiff) Summary() string {
+	counts := make(map[string]int)
+	for _, change := range r.Changes {
+		counts[change.Change]++
+	}
+	var parts []string
+	for _, change := range []struct{ key, verb string }{{"created", "create"}, {"changed", "change"},
+		{"deleted", "delete"}} {
+		if counts[change.key] > 0 {
+			parts = append(parts, fmt.Sprintf("%d to %s", counts[change.key], change.verb))
+		}
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// compareManifests lists the resources that differ between the live and rendered manifests, in the rendered
+// manifest's order followed by the deleted ones, and produces a unified diff of them.
+func compareManifests(live, rendered []manifestResource) ([]ResourceChange, string) {
+	previous := make(map[string]string)
+	for _, resource := range live {
+		previous[resource.id] = resource.text
+	}
+
+	var changes []ResourceChange
+	diff := &strings.Builder{}
+	for _, resource := range rendered {
+		text, existed := previous[resource.id]
+		delete(previous, resource.id)
+		if existed && text == resource.text {
+			continue
+		}
+		change := "changed"
+		if !existed {
+			change = "created"
+		}
+		changes = append(changes, ResourceChange{Resource: resource.id, Change: change})
+		writeResourceDiff(diff, resource.id, text, resource.text)
+	}
+
+	var deleted []string
+	for id := range previous {
+		deleted = append(deleted, id)
+	}
+	sort.Strings(deleted)
+	for _, id := range deleted {
+		changes = append(changes, ResourceChange{Resource: id, Change: "deleted"})
+		writeResourceDiff(diff, id, previous[id], "")
+	}
+	return changes, diff.String()
+}
+
+func writeResourceDiff(w io.Writer, id, before, after string) {
+	if strings.HasPrefix(id, "Secret/") {
+		before, after = redactSecrets(before, after)
+	}
+	difflib.WriteUnifiedDiff(w, difflib.UnifiedDiff{
+		A:        splitLines(before),
+		B:        splitLines(after),
+		FromFile: id + " (live)",
+		ToFile:   id + " (chart)",
+		Context:  3,
+	})
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return difflib.SplitLines(text)
+}
+
+// redactSecrets replaces the values in two versions of a Secret with placeholders. Values that changed get a
+// different placeholder, so that the diff still shows which ones changed without showing what they are.
+func redactSecrets(before, after string) (string, string) {
+	beforeDoc, beforeValues, beforeErr := parseSecret(before)
+	afterDoc, _, afterErr := parseSecret(after)
+	if beforeErr != nil || afterErr != nil {
+		// there's no telling where the values are, so none of it can be shown
+		return redactUnparsable(before), redactUnparsable(after)
+	}
+
+	return redactValues(beforeDoc, func(string, string) string { return redactedValue }),
+		redactValues(afterDoc, func(key, value string) string {
+			if old, existed := beforeValues[key]; existed && old != value {
+				return changedValue
+			}
+			return redactedValue
+		})
+}
+
+// parseSecret parses a Secret, returning its values keyed by field and name, such as "data.password".
+func parseSecret(text string) (yaml.MapSlice, map[string]string, error) {
+	if text == "" {
+		return nil, nil, nil
+	}
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(text), &doc); err != nil {
+		return nil, nil, err
+	}
+	values := make(map[string]string)
+	for _, field := range doc {
+		items, ok := field.Value.(yaml.MapSlice)
+		if !isSecretField(field.Key) || !ok {
+			continue
+		}
+		for _, item := range items {
+			values[fmt.Sprintf("%v.%v", field.Key, item.Key)] = fmt.Sprint(item.Value)
+		}
+	}
+	return doc, values, nil
+}
+
+func redactValues(doc yaml.MapSlice, placeholder func(key, value string) string) string {
+	if doc == nil {
+		return ""
+	}
+	for i, field := range doc {
+		items, ok := field.Value.(yaml.MapSlice)
+		if !isSecretField(field.Key) || !ok {
+			continue
+		}
+		redacted := make(yaml.MapSlice, len(items))
+		for j, item := range items {
+			key := fmt.Sprintf("%v.%v", field.Key, item.Key)
+			redacted[j] = yaml.MapItem{Key: item.Key, Value: placeholder(key, fmt.Sprint(item.Value))}
+		}
+		doc[i].Value = redacted
+	}
+	text, err := yaml.Marshal(doc)
+	if err != nil {
+		return redactedSecret
+	}
+	return strings.TrimSpace(string(text))
+}
+
+func isSecretField(key interface{}) bool {
+	return key == "data" || key == "stringData"
+}
+
+func redactUnparsable(text string) string {
+	if text == "" {
+		return ""
+	}
+	return redactedSecret
+}
+
+// scrubSecrets hides any of the secret values in the text, such as values interpolated from environment variables or
+// decrypted from values files, along with each line of the multi-line ones.
+func scrubSecrets(text string, secrets ...[]string) string {
+	var hidden []string
+	for _, list := range secrets {
+		for _, secret := range list {
+			for _, part := range append([]string{secret}, strings.Split(secret, "\n")...) {
+				if part = strings.TrimSpace(part); len(part) >= minSecretLength {
+					hidden = append(hidden, part)
+				}
+			}
+		}
+	}
+	if len(hidden) == 0 {
+		return text
+	}
+
+	// the longest values are replaced first, so that a secret that contains another is hidden whole
+	sort.Slice(hidden, func(i, j int) bool { return len(hidden[i]) > len(hidden[j]) })
+	replacements := make([]string, 0, 2*len(hidden))
+	for _, secret := range hidden {
+		replacements = append(replacements, secret, redactedValue)
+	}
+	return strings.NewReplacer(replacements...).Replace(text)
+}
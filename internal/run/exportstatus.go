@@ -0,0 +1,190 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
+)
+
+var (
+	// outputLock keeps releases that are deployed in parallel from writing to the output file at the same time.
+	outputLock    sync.Mutex
+	nonKeyPattern = regexp.MustCompile(`[^A-Z0-9]+`)
+)
+
+// ReleaseInfo describes a deployed release, for summarizing the deployment.
+type ReleaseInfo struct {
+	Name         string           `json:"name"`
+	Namespace    string           `json:"namespace"`
+	Revision     int              `json:"revision"`
+	Status       string           `json:"status"`
+	Chart        string           `json:"chart"`
+	ChartVersion string           `json:"chartVersion"`
+	AppVersion   string           `json:"appVersion"`
+	Changes      []ResourceChange `json:"changes"`
+	Unchanged    int              `json:"unchanged"`
+}
+
+// A ResourceChange is a resource that was created, changed or deleted by a release's latest revision.
+type ResourceChange struct {
+	Resource string `json:"resource"`
+	Change   string `json:"change"`
+}
+
+// ExportStatus is an execution step that passes the status of a release on to later steps in the pipeline.
+type ExportStatus struct {
+	*config
+	release    string
+	outputFile string
+	prefixed   bool
+	summarize  bool
+	info       *ReleaseInfo
+}
+
+// NewExportStatus creates an ExportStatus for the Config's release. If prefixed is true, the release's name is
+// included in the output keys, so that several releases' results can be exported together. No validation is
+// performed at this time.
+func NewExportStatus(cfg env.Config, prefixed bool) *ExportStatus {
+	outputFile := cfg.OutputFile
+	if outputFile == "" {
+		outputFile = cfg.DroneOutput
+	}
+	return &ExportStatus{
+		config:     newConfig(cfg),
+		release:    cfg.Release,
+		outputFile: outputFile,
+		prefixed:   prefixed,
+		summarize:  cfg.DroneCardPath != "" || cfg.SummaryFile != "",
+	}
+}
+
+// Prepare ensures there's a release to export.
+func (e *ExportStatus) Prepare() error {
+	if e.release == "" {
+		return errors.New("release is required")
+	}
+	return nil
+}
+
+// Execute prints a summary of the release's status, and appends it to the output file as KEY=value lines. The release
+// has already been deployed by this point, so if its status can't be found, that's only a warning.
+func (e *ExportStatus) Execute() error {
+	status, err := getReleaseStatus(e.config, e.release)
+	if err == nil && status == nil {
+		err = fmt.Errorf("release %s wasn't found after deploying it", e.release)
+	}
+	if err != nil {
+		logging.Warnf(e.stderr, "could not export the status of release %s: %s", e.release, err)
+		return nil
+	}
+
+	metadata := status.Chart.Metadata
+	e.info = &ReleaseInfo{
+		Name:         status.Name,
+		Namespace:    status.Namespace,
+		Revision:     status.Version,
+		Status:       status.Info.Status,
+		Chart:        metadata.Name,
+		ChartVersion: metadata.Version,
+		AppVersion:   metadata.AppVersion,
+	}
+	if e.summarize {
+		// the changes are only for the summary, so failing to get them shouldn't fail the build
+		if err := e.describeChanges(); err != nil {
+			logging.Warnf(e.stderr, "could not get the changes to release %s: %s", e.release, err)
+		}
+	}
+
+	stdout := logging.WithFields(e.stdout, logging.Fields{"release": status.Name, "revision": status.Version,
+		"status": status.Info.Status})
+	logging.Infof(stdout, "release %s: revision %d is %s in namespace %s (chart %s %s, app version %s)",
+		status.Name, status.Version, status.Info.Status, status.Namespace, metadata.Name, metadata.Version,
+		metadata.AppVersion)
+
+	if e.outputFile == "" {
+		return nil
+	}
+
+	prefix := "HELM_"
+	if e.prefixed {
+		prefix += strings.Trim(nonKeyPattern.ReplaceAllString(strings.ToUpper(e.release), "_"), "_") + "_"
+	}
+	values := [][2]string{
+		{"RELEASE", status.Name},
+		{"NAMESPACE", status.Namespace},
+		{"REVISION", strconv.Itoa(status.Version)},
+		{"STATUS", status.Info.Status},
+		{"CHART", metadata.Name},
+		{"CHART_VERSION", metadata.Version},
+		{"APP_VERSION", metadata.AppVersion},
+	}
+	lines := &strings.Builder{}
+	for _, value := range values {
+		fmt.Fprintf(lines, "%s%s=%s\n", prefix, value[0], value[1])
+	}
+
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	file, err := os.OpenFile(e.outputFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open output file: %w", err)
+	}
+	if _, err := file.WriteString(lines.String()); err != nil {
+		file.Close()
+		return fmt.Errorf("could not write output file: %w", err)
+	}
+	return file.Close()
+}
+
+// Info returns what Execute found out about the release, or nil if it hasn't been executed.
+func (e *ExportStatus) Info() *ReleaseInfo {
+	return e.info
+}
+
+// describeChanges compares the release's manifest with its previous revision's.
+func (e *ExportStatus) describeChanges() error {
+	current, err := getManifest(e.config, e.release, e.info.Revision)
+	if err != nil {
+		return err
+	}
+	previous := make(map[string]string)
+	if e.info.Revision > 1 {
+		resources, err := getManifest(e.config, e.release, e.info.Revision-1)
+		if err != nil {
+			return err
+		}
+		for _, resource := range resources {
+			previous[resource.id] = resource.text
+		}
+	}
+
+	for _, resource := range current {
+		text, existed := previous[resource.id]
+		switch {
+		case !existed:
+			e.info.Changes = append(e.info.Changes, ResourceChange{Resource: resource.id, Change: "created"})
+		case text != resource.text:
+			e.info.Changes = append(e.info.Changes, ResourceChange{Resource: resource.id, Change: "changed"})
+		default:
+			e.info.Unchanged++
+		}
+		delete(previous, resource.id)
+	}
+	var deleted []string
+	for id := range previous {
+		deleted = append(deleted, id)
+	}
+	sort.Strings(deleted)
+	for _, id := range deleted {
+		e.info.Changes = append(e.info.Changes, ResourceChange{Resource: id, Change: "deleted"})
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package run
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/stretchr/testify/suite"
+)
+
+const exportedStatus = `{"name": "minas-tirith", "namespace": "gondor", "version": 12,
+	"chart": {"metadata": {"name": "citadel", "version": "3.0.19", "appVersion": "v2.1"}},
+	"info": {"status": "deployed", "description": "Upgrade complete"}}`
+
+type ExportStatusTestSuite struct {
+	suite.Suite
+	ctrl *gomock.Controller
+	dir  string
+}
+
+func (suite *ExportStatusTestSuite) BeforeTest(_, _ string) {
+	suite.ctrl = gomock.NewController(suite.T())
+	dir, err := ioutil.TempDir("", "output")
+	suite.Require().NoError(err)
+	suite.dir = dir
+}
+
+func (suite *ExportStatusTestSuite) AfterTest(_, _ string) {
+	suite.ctrl.Finish()
+	os.RemoveAll(suite.dir)
+}
+
+func TestExportStatusTestSuite(t *testing.T) {
+	suite.Run(t, new(ExportStatusTestSuite))
+}
+
+func (suite *ExportStatusTestSuite) TestNewExportStatus() {
+	e := NewExportStatus(env.Config{Release: "minas-tirith", DroneOutput: "/drone/output"}, false)
+	suite.Equal("minas-tirith", e.release)
+	suite.Equal("/drone/output", e.outputFile)
+
+	e = NewExportStatus(env.Config{DroneOutput: "/drone/output", OutputFile: "./release.env"}, true)
+	suite.Equal("./release.env", e.outputFile, "output_file should take precedence over DRONE_OUTPUT")
+	suite.True(e.prefixed)
+
+	suite.EqualError(e.Prepare(), "release is required")
+}
+
+func (suite *ExportStatusTestSuite) TestExecute() {
+	calls, restore := scriptedCommands(suite.ctrl, scriptedRun{stdout: exportedStatus})
+	defer restore()
+
+	outputFile := filepath.Join(suite.dir, "output")
+	suite.Require().NoError(ioutil.WriteFile(outputFile, []byte("EARLIER=step\n"), 0644))
+
+	stdout := &strings.Builder{}
+	cfg := env.Config{Release: "minas-tirith", Namespace: "gondor", DroneOutput: outputFile, Stdout: stdout}
+	e := NewExportStatus(cfg, false)
+	suite.Require().NoError(e.Prepare())
+	suite.Require().NoError(e.Execute())
+
+	suite.Equal([][]string{{"--namespace", "gondor", "status", "minas-tirith", "--output", "json"}}, *calls)
+	suite.Equal("release minas-tirith: revision 12 is deployed in namespace gondor (chart citadel 3.0.19, app version v2.1)\n",
+		stdout.String())
+
+	output, err := ioutil.ReadFile(outputFile)
+	suite.Require().NoError(err)
+	suite.Equal(`EARLIER=step
+HELM_RELEASE=minas-tirith
+HELM_NAMESPACE=gondor
+HELM_REVISION=12
+HELM_STATUS=deployed
+HELM_CHART=citadel
+HELM_CHART_VERSION=3.0.19
+HELM_APP_VERSION=v2.1
+`, string(output))
+}
+
+func (suite *ExportStatusTestSuite) TestExecutePrefixed() {
+	_, restore := scriptedCommands(suite.ctrl, scriptedRun{stdout: exportedStatus})
+	defer restore()
+
+	outputFile := filepath.Join(suite.dir, "release.env")
+	cfg := env.Config{Release: "minas-tirith", OutputFile: outputFile, Stdout: &strings.Builder{}}
+	suite.Require().NoError(NewExportStatus(cfg, true).Execute())
+
+	output, err := ioutil.ReadFile(outputFile)
+	suite.Require().NoError(err)
+	suite.Contains(string(output), "HELM_MINAS_TIRITH_REVISION=12\n")
+	suite.Contains(string(output), "HELM_MINAS_TIRITH_APP_VERSION=v2.1\n")
+}
+
+func (suite *ExportStatusTestSuite) TestExecuteWithoutOutputFile() {
+	_, restore := scriptedCommands(suite.ctrl, scriptedRun{stdout: exportedStatus})
+	defer restore()
+
+	stdout := &strings.Builder{}
+	suite.Require().NoError(NewExportStatus(env.Config{Release: "minas-tirith", Stdout: stdout}, false).Execute())
+	suite.Contains(stdout.String(), "revision 12 is deployed", "the summary should be printed even if it isn't exported")
+}
+
+func (suite *ExportStatusTestSuite) TestExecuteErrors() {
+	_, restore := scriptedCommands(suite.ctrl,
+		scriptedRun{stderr: "Error: release: not found\n", err: errors.New("exit status 1")},
+		scriptedRun{stdout: exportedStatus},
+	)
+	defer restore()
+
+	stderr := &strings.Builder{}
+	cfg := env.Config{Release: "minas-tirith", Stdout: &strings.Builder{}, Stderr: stderr}
+	e := NewExportStatus(cfg, false)
+	suite.NoError(e.Execute(), "the status is only informational, so it shouldn't fail the build")
+	suite.Nil(e.Info())
+	suite.Contains(stderr.String(), "could not export the status of release minas-tirith: "+
+		"release minas-tirith wasn't found after deploying it")
+
+	cfg.OutputFile = filepath.Join(suite.dir, "missing", "output")
+	err := NewExportStatus(cfg, false).Execute()
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "could not open output file")
+}
+
+func (suite *ExportStatusTestSuite) TestExecuteWithoutPermission() {
+	_, restore := scriptedCommands(suite.ctrl, scriptedRun{
+		stderr: `Error: secrets is forbidden: User "drone" cannot list resource "secrets"` + "\n",
+		err:    errors.New("exit status 1"),
+	})
+	defer restore()
+
+	stderr := &strings.Builder{}
+	cfg := env.Config{Release: "minas-tirith", OutputFile: filepath.Join(suite.dir, "output"), Stderr: stderr}
+	suite.NoError(NewExportStatus(cfg, false).Execute())
+	suite.Contains(stderr.String(), "could not export the status of release minas-tirith: "+
+		"could not get status of release minas-tirith: exit status 1: Error: secrets is forbidden")
+	_, err := os.Stat(cfg.OutputFile)
+	suite.True(os.IsNotExist(err), "nothing should be exported")
+}
+
+func (suite *ExportStatusTestSuite) TestExecuteDescribesChanges() {
+	calls, restore := scriptedCommands(suite.ctrl,
+		scriptedRun{stdout: exportedStatus},
+		scriptedRun{stdout: "---\nkind: Deployment\nmetadata:\n  name: citadel\nspec:\n  replicas: 3\n" +
+			"---\nkind: Service\nmetadata:\n  name: citadel\n" +
+			"---\nkind: ConfigMap\nmetadata:\n  name: white-tree\n"},
+		scriptedRun{stdout: "---\nkind: Deployment\nmetadata:\n  name: citadel\nspec:\n  replicas: 1\n" +
+			"---\nkind: Service\nmetadata:\n  name: citadel\n" +
+			"---\nkind: Secret\nmetadata:\n  name: palantir\n"},
+	)
+	defer restore()
+
+	cfg := env.Config{Release: "minas-tirith", SummaryFile: "summary.md", Stdout: &strings.Builder{}}
+	e := NewExportStatus(cfg, false)
+	suite.Nil(e.Info())
+	suite.Require().NoError(e.Execute())
+
+	suite.Equal([]string{"get", "manifest", "minas-tirith", "--revision", "12"}, (*calls)[1])
+	suite.Equal([]string{"get", "manifest", "minas-tirith", "--revision", "11"}, (*calls)[2])
+	suite.Equal(&ReleaseInfo{
+		Name:         "minas-tirith",
+		Namespace:    "gondor",
+		Revision:     12,
+		Status:       "deployed",
+		Chart:        "citadel",
+		ChartVersion: "3.0.19",
+		AppVersion:   "v2.1",
+		Changes: []ResourceChange{
+			{Resource: "Deployment/citadel", Change: "changed"},
+			{Resource: "ConfigMap/white-tree", Change: "created"},
+			{Resource: "Secret/palantir", Change: "deleted"},
+		},
+		Unchanged: 1,
+	}, e.Info())
+}
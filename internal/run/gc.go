@@ -0,0 +1,210 @@
+package run
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
+)
+
+// helm formats release timestamps with time.Time's String method.
+const helmTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+var (
+	now      = time.Now
+	dayCount = regexp.MustCompile(`^(\d+)d$`)
+)
+
+// GC is an execution step that uninstalls releases that haven't been updated within a given time.
+type GC struct {
+	*config
+	pattern       string
+	selector      string
+	allNamespaces bool
+	maxAge        string
+	dryRun        bool
+	keepHistory   bool
+	nameFilter    *regexp.Regexp
+	age           time.Duration
+	list          *bytes.Buffer
+	args          []string
+	cmd           cmd
+}
+
+// listedRelease is an entry in the output of `helm list -o json`.
+type listedRelease struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Updated   string `json:"updated"`
+	Status    string `json:"status"`
+}
+
+// NewGC creates a GC using fields from the given Config. No validation is performed at this time.
+func NewGC(cfg env.Config) *GC {
+	return &GC{
+		config:        newConfig(cfg),
+		pattern:       cfg.GCPattern,
+		selector:      cfg.GCSelector,
+		allNamespaces: cfg.GCAllNamespaces,
+		maxAge:        cfg.MaxAge,
+		dryRun:        cfg.DryRun,
+		keepHistory:   cfg.KeepHistory,
+	}
+}
+
+// Prepare gets the GC ready to execute.
+func (g *GC) Prepare() error {
+	if g.pattern == "" && g.selector == "" {
+		return errors.New("gc_pattern or gc_selector is required")
+	}
+	if g.maxAge == "" {
+		return errors.New("max_age is required")
+	}
+
+	var err error
+	if g.age, err = parseAge(g.maxAge); err != nil {
+		return err
+	}
+	if g.pattern != "" {
+		if g.nameFilter, err = regexp.Compile(g.pattern); err != nil {
+			return fmt.Errorf("invalid gc_pattern: %w", err)
+		}
+	}
+	if err := validateNamespace(g.namespace); err != nil {
+		return err
+	}
+
+	// --all-namespaces takes precedence over the --namespace flag
+	args := g.globalFlags()
+	args = append(args, "list")
+	if g.allNamespaces {
+		args = append(args, "--all-namespaces")
+	}
+	// helm lists 256 releases by default; --max 0 lifts the limit, so that none are overlooked
+	args = append(args, "--all", "--max", "0", "--output", "json")
+	if g.selector != "" {
+		args = append(args, "--selector", g.selector)
+	}
+
+	g.list = &bytes.Buffer{}
+	g.args = args
+	g.cmd = command(helmBin, args...)
+	g.cmd.Stdout(g.list)
+	g.cmd.Stderr(logging.Stream(g.stderr, "stderr"))
+
+	if g.debug {
+		logging.Debugf(g.stderr, "Generated command: '%s'", g.cmd.String())
+	}
+
+	return nil
+}
+
+// Execute lists the matching releases and uninstalls the stale ones, then prints a summary.
+func (g *GC) Execute() error {
+	if err := g.cmd.Run(); err != nil {
+		return fmt.Errorf("could not list releases: %w", err)
+	}
+	var releases []listedRelease
+	if err := json.Unmarshal(g.list.Bytes(), &releases); err != nil {
+		return fmt.Errorf("could not parse release list: %w", err)
+	}
+
+	table := tabwriter.NewWriter(g.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "RELEASE\tNAMESPACE\tUPDATED\tAGE\tACTION")
+
+	var failed []string
+	for _, rel := range releases {
+		if g.nameFilter != nil && !g.nameFilter.MatchString(rel.Name) {
+			continue
+		}
+
+		updated, err := time.Parse(helmTimeLayout, rel.Updated)
+		if err != nil {
+			// without its age, the release can't be judged stale, but the rest of them still can be
+			logging.Warnf(g.stderr, "could not parse update time of release %s: %s", rel.Name, err)
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", rel.Name, rel.Namespace, "unknown", "unknown", "failed")
+			failed = append(failed, rel.Name)
+			continue
+		}
+		age := now().Sub(updated)
+
+		action := "kept"
+		if age > g.age {
+			switch {
+			case g.dryRun:
+				action = "would uninstall"
+			case g.uninstall(rel) != nil:
+				action = "failed"
+				failed = append(failed, rel.Name)
+			default:
+				action = "uninstalled"
+			}
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", rel.Name, rel.Namespace, updated.UTC().Format(time.RFC3339),
+			formatAge(age), action)
+	}
+	table.Flush()
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to uninstall %d release(s): %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (g *GC) uninstall(rel listedRelease) error {
+	scope := *g.config
+	scope.namespace = rel.Namespace
+	args := scope.globalFlags()
+	args = append(args, "uninstall")
+	if g.keepHistory {
+		args = append(args, "--keep-history")
+	}
+	args = append(args, rel.Name)
+
+	uninstall := command(helmBin, args...)
+	uninstall.Stdout(logging.Stream(g.stdout, "stdout"))
+	uninstall.Stderr(logging.Stream(g.stderr, "stderr"))
+	if g.debug {
+		logging.Debugf(g.stderr, "Generated command: '%s'", uninstall.String())
+	}
+	return uninstall.Run()
+}
+
+// parseAge accepts any duration time.ParseDuration does, as well as a whole number of days (e.g. "7d").
+func parseAge(age string) (time.Duration, error) {
+	if match := dayCount.FindStringSubmatch(age); match != nil {
+		days, _ := strconv.Atoi(match[1])
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(age)
+	if err != nil {
+		return 0, fmt.Errorf("invalid max_age '%s': must be a duration like 36h or a number of days like 7d", age)
+	}
+	return d, nil
+}
+
+// formatAge rounds down to the largest convenient unit, the way kubectl does.
+func formatAge(age time.Duration) string {
+	switch {
+	case age >= 48*time.Hour:
+		return fmt.Sprintf("%dd", age/(24*time.Hour))
+	case age >= time.Hour:
+		return fmt.Sprintf("%dh", age/time.Hour)
+	default:
+		return fmt.Sprintf("%dm", age/time.Minute)
+	}
+}
+
+// Command returns the `helm list` command that finds the releases to consider. The uninstalls depend on what it
+// finds, so they aren't included.
+func (g *GC) Command() string {
+	return redactCommand(g.args)
+}
@@ -0,0 +1,230 @@
+package run
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/stretchr/testify/suite"
+)
+
+const gcTestReleases = `[
+	{"name": "lembas-pr-12", "namespace": "lembas-pr-12", "revision": "3", "updated": "2020-03-01 12:00:00.123456789 +0000 UTC", "status": "deployed"},
+	{"name": "lembas-pr-15", "namespace": "lembas-pr-15", "revision": "1", "updated": "2020-03-09 09:30:00.5 +0000 UTC", "status": "failed"},
+	{"name": "lembas", "namespace": "lembas", "revision": "41", "updated": "2020-01-01 00:00:00 +0000 UTC", "status": "deployed"}
+]`
+
+type GCTestSuite struct {
+	suite.Suite
+	ctrl            *gomock.Controller
+	commands        [][]string
+	listOutput      string
+	failures        map[string]error
+	originalCommand func(string, ...string) cmd
+	originalNow     func() time.Time
+}
+
+func (suite *GCTestSuite) BeforeTest(_, _ string) {
+	suite.ctrl = gomock.NewController(suite.T())
+	suite.commands = nil
+	suite.listOutput = gcTestReleases
+	suite.failures = map[string]error{}
+
+	// `helm list` writes suite.listOutput; `helm uninstall` fails if its release is in suite.failures
+	suite.originalCommand = command
+	command = func(path string, args ...string) cmd {
+		suite.Equal(helmBin, path)
+		suite.commands = append(suite.commands, args)
+
+		var stdout io.Writer
+		mockCmd := NewMockcmd(suite.ctrl)
+		mockCmd.EXPECT().Stdout(gomock.Any()).Do(func(w io.Writer) { stdout = w })
+		mockCmd.EXPECT().Stderr(gomock.Any())
+		mockCmd.EXPECT().String().Return(helmBin + " " + strings.Join(args, " ")).AnyTimes()
+		mockCmd.EXPECT().Run().DoAndReturn(func() error {
+			for _, arg := range args {
+				if arg == "list" {
+					_, err := io.WriteString(stdout, suite.listOutput)
+					return err
+				}
+			}
+			return suite.failures[args[len(args)-1]]
+		}).AnyTimes()
+		return mockCmd
+	}
+
+	suite.originalNow = now
+	now = func() time.Time {
+		return time.Date(2020, time.March, 10, 12, 0, 0, 0, time.UTC)
+	}
+}
+
+func (suite *GCTestSuite) AfterTest(_, _ string) {
+	suite.ctrl.Finish()
+	command = suite.originalCommand
+	now = suite.originalNow
+}
+
+func TestGCTestSuite(t *testing.T) {
+	suite.Run(t, new(GCTestSuite))
+}
+
+func (suite *GCTestSuite) TestNewGC() {
+	cfg := env.Config{
+		GCPattern:       "-pr-[0-9]+$",
+		GCSelector:      "owner=preview",
+		GCAllNamespaces: true,
+		MaxAge:          "7d",
+		DryRun:          true,
+		KeepHistory:     true,
+	}
+	g := NewGC(cfg)
+	suite.Equal("-pr-[0-9]+$", g.pattern)
+	suite.Equal("owner=preview", g.selector)
+	suite.True(g.allNamespaces)
+	suite.Equal("7d", g.maxAge)
+	suite.True(g.dryRun)
+	suite.True(g.keepHistory)
+	suite.NotNil(g.config)
+}
+
+func (suite *GCTestSuite) TestPrepareAndExecute() {
+	stdout := strings.Builder{}
+	cfg := env.Config{
+		GCPattern: "-pr-[0-9]+$",
+		MaxAge:    "7d",
+		Namespace: "lembas",
+		Stdout:    &stdout,
+	}
+	g := NewGC(cfg)
+
+	suite.Require().NoError(g.Prepare())
+	list := []string{"--namespace", "lembas", "list", "--all", "--max", "0", "--output", "json"}
+	suite.Equal([][]string{list}, suite.commands)
+
+	suite.Require().NoError(g.Execute())
+	suite.Equal([][]string{
+		list,
+		{"--namespace", "lembas-pr-12", "uninstall", "lembas-pr-12"},
+	}, suite.commands)
+
+	expected := "RELEASE       NAMESPACE     UPDATED               AGE  ACTION\n" +
+		"lembas-pr-12  lembas-pr-12  2020-03-01T12:00:00Z  8d   uninstalled\n" +
+		"lembas-pr-15  lembas-pr-15  2020-03-09T09:30:00Z  26h  kept\n"
+	suite.Equal(expected, stdout.String())
+}
+
+func (suite *GCTestSuite) TestSelectorAndAllNamespaces() {
+	cfg := env.Config{
+		GCSelector:      "owner=preview",
+		GCAllNamespaces: true,
+		MaxAge:          "24h",
+		Debug:           true,
+		KeepHistory:     true,
+		Namespace:       "ignored",
+		Stdout:          &strings.Builder{},
+		Stderr:          &strings.Builder{},
+	}
+	g := NewGC(cfg)
+	suite.Require().NoError(g.Prepare())
+	suite.Require().NoError(g.Execute())
+
+	suite.Equal([][]string{
+		{"--debug", "--namespace", "ignored", "list", "--all-namespaces", "--all", "--max", "0", "--output", "json",
+			"--selector", "owner=preview"},
+		{"--debug", "--namespace", "lembas-pr-12", "uninstall", "--keep-history", "lembas-pr-12"},
+		{"--debug", "--namespace", "lembas-pr-15", "uninstall", "--keep-history", "lembas-pr-15"},
+		{"--debug", "--namespace", "lembas", "uninstall", "--keep-history", "lembas"},
+	}, suite.commands)
+}
+
+func (suite *GCTestSuite) TestExecuteDryRun() {
+	stdout := strings.Builder{}
+	cfg := env.Config{
+		GCPattern: "^lembas",
+		MaxAge:    "48h",
+		DryRun:    true,
+		Stdout:    &stdout,
+	}
+	g := NewGC(cfg)
+	suite.Require().NoError(g.Prepare())
+	suite.Require().NoError(g.Execute())
+
+	suite.Len(suite.commands, 1, "a dry run shouldn't uninstall anything")
+	suite.Contains(stdout.String(), "lembas-pr-12  lembas-pr-12  2020-03-01T12:00:00Z  8d   would uninstall\n")
+	suite.Contains(stdout.String(), "lembas        lembas        2020-01-01T00:00:00Z  69d  would uninstall\n")
+}
+
+func (suite *GCTestSuite) TestExecuteReportsFailures() {
+	suite.failures["lembas-pr-12"] = errors.New("exit status 1")
+	stdout := strings.Builder{}
+	cfg := env.Config{
+		GCPattern: ".",
+		MaxAge:    "1h",
+		Stdout:    &stdout,
+	}
+	g := NewGC(cfg)
+	suite.Require().NoError(g.Prepare())
+
+	err := g.Execute()
+	suite.EqualError(err, "failed to uninstall 1 release(s): lembas-pr-12")
+	suite.Len(suite.commands, 4, "a failure shouldn't stop the remaining releases from being uninstalled")
+	suite.Contains(stdout.String(), "8d   failed\n")
+	suite.Contains(stdout.String(), "26h  uninstalled\n")
+}
+
+func (suite *GCTestSuite) TestExecuteWithUnparseableTime() {
+	suite.listOutput = strings.Replace(gcTestReleases, "2020-03-01 12:00:00.123456789 +0000 UTC", "last Tuesday", 1)
+	stdout, stderr := strings.Builder{}, strings.Builder{}
+	g := NewGC(env.Config{GCPattern: ".", MaxAge: "1h", Stdout: &stdout, Stderr: &stderr})
+	suite.Require().NoError(g.Prepare())
+
+	err := g.Execute()
+	suite.EqualError(err, "failed to uninstall 1 release(s): lembas-pr-12")
+	suite.Len(suite.commands, 3, "the other releases should still be uninstalled")
+	suite.Contains(stderr.String(), "could not parse update time of release lembas-pr-12")
+	suite.Contains(stdout.String(), "unknown               unknown  failed\n")
+	suite.Contains(stdout.String(), "26h      uninstalled\n")
+}
+
+func (suite *GCTestSuite) TestExecuteListErrors() {
+	suite.listOutput = "Error: Kubernetes cluster unreachable"
+	g := NewGC(env.Config{GCPattern: ".", MaxAge: "1h", Stdout: &strings.Builder{}})
+	suite.Require().NoError(g.Prepare())
+
+	err := g.Execute()
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "could not parse release list")
+}
+
+func (suite *GCTestSuite) TestPrepareValidation() {
+	suite.EqualError(NewGC(env.Config{MaxAge: "1h"}).Prepare(), "gc_pattern or gc_selector is required")
+	suite.EqualError(NewGC(env.Config{GCPattern: "."}).Prepare(), "max_age is required")
+	suite.EqualError(NewGC(env.Config{GCPattern: ".", MaxAge: "a fortnight"}).Prepare(),
+		"invalid max_age 'a fortnight': must be a duration like 36h or a number of days like 7d")
+
+	err := NewGC(env.Config{GCPattern: "(", MaxAge: "1h"}).Prepare()
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "invalid gc_pattern")
+	suite.Empty(suite.commands)
+}
+
+func (suite *GCTestSuite) TestParseAge() {
+	age, err := parseAge("7d")
+	suite.NoError(err)
+	suite.Equal(7*24*time.Hour, age)
+
+	age, err = parseAge("90m")
+	suite.NoError(err)
+	suite.Equal(90*time.Minute, age)
+}
+
+func (suite *GCTestSuite) TestFormatAge() {
+	suite.Equal("3d", formatAge(80*time.Hour))
+	suite.Equal("47h", formatAge(47*time.Hour+59*time.Minute))
+	suite.Equal("5m", formatAge(5*time.Minute+30*time.Second))
+}
@@ -0,0 +1,156 @@
+package run
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// maxCapturedErrors is how much of the end of a command's stderr is kept for classifying its failure.
+const maxCapturedErrors = 64 * 1024
+
+const (
+	categoryTimeout   = "timed out waiting"
+	categoryCancelled = "cancelled"
+)
+
+// A knownFailure is a kind of helm failure that can be recognized from helm's output.
+type knownFailure struct {
+	category string
+	pattern  *regexp.Regexp
+	hint     string
+}
+
+var knownFailures = []knownFailure{
+	{
+		category: "release in progress",
+		pattern:  regexp.MustCompile(`another operation \(install/upgrade/rollback\) is in progress`),
+		hint:     "an earlier deployment of this release didn't finish; roll it back with `helm rollback`, then deploy again",
+	},
+	{
+		category: "immutable field",
+		pattern:  regexp.MustCompile(`field is immutable`),
+		hint: "the upgrade changes a field kubernetes doesn't allow to change, such as a selector; " +
+			"delete the resource or uninstall the release, then deploy again",
+	},
+	{
+		category: "forbidden by RBAC",
+		pattern:  regexp.MustCompile(`is forbidden: User "[^"]*" cannot`),
+		hint:     "the service account helm runs as needs a Role or ClusterRole that allows this action",
+	},
+	{
+		category: "no matches for kind",
+		pattern:  regexp.MustCompile(`no matches for kind`),
+		hint: "the chart uses a resource type the cluster doesn't know; install its CRDs first, " +
+			"or check the apiVersion is supported by this version of kubernetes",
+	},
+	{
+		category: categoryTimeout,
+		pattern:  regexp.MustCompile(`timed out waiting for the condition|context deadline exceeded`),
+		hint:     "the release's resources didn't become ready in time; check its pods' events and logs, or raise `timeout`",
+	},
+	{
+		category: "chart not found",
+		pattern:  regexp.MustCompile(`chart "[^"]*" (version "[^"]*" )?not found|no chart version found|no chart name found`),
+		hint:     "check the chart name and `chart_version`, and that the chart's repository is in `add_repos`",
+	},
+	{
+		category: "x509 unknown authority",
+		pattern:  regexp.MustCompile(`x509: certificate signed by unknown authority`),
+		hint: "the server's certificate isn't trusted; set `kube_certificate` for the kubernetes API, " +
+			"or `repo_ca_certificate` for a chart repository",
+	},
+}
+
+// HelmError is returned when a helm command fails. If helm's output showed what went wrong, the Category says which
+// known failure it was, and the Hint suggests what to do about it.
+type HelmError struct {
+	Err      error
+	Category string
+	Detail   string
+	Hint     string
+}
+
+func (e *HelmError) Error() string {
+	if e.Category == "" {
+		return e.Err.Error()
+	}
+	msg := fmt.Sprintf("%s: %s", e.Category, e.Err)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.Hint != "" {
+		msg += fmt.Sprintf(" (hint: %s)", e.Hint)
+	}
+	return msg
+}
+
+func (e *HelmError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether helm gave up waiting for the release's resources.
+func (e *HelmError) Timeout() bool {
+	return e.Category == categoryTimeout
+}
+
+// Cancelled reports whether the command failed because drone-helm3 was told to stop.
+func (e *HelmError) Cancelled() bool {
+	return e.Category == categoryCancelled
+}
+
+// errorCapture keeps the end of a command's stderr, so that its failure can be classified.
+type errorCapture struct {
+	buf bytes.Buffer
+}
+
+func newErrorCapture() *errorCapture {
+	return &errorCapture{}
+}
+
+// output returns a writer that sends everything to stderr, as well as capturing it.
+func (e *errorCapture) output(stderr io.Writer) io.Writer {
+	return io.MultiWriter(stderr, e)
+}
+
+func (e *errorCapture) Write(p []byte) (int, error) {
+	e.buf.Write(p)
+	if extra := e.buf.Len() - maxCapturedErrors; extra > 0 {
+		e.buf.Next(extra)
+	}
+	return len(p), nil
+}
+
+// reset forgets the output captured so far, such as that of an earlier attempt at the command.
+func (e *errorCapture) reset() {
+	e.buf.Reset()
+}
+
+// classify wraps the error from a failed command in a HelmError, using the last line of the captured output that
+// matches a known failure. Commands that failed because drone-helm3 was cancelled aren't matched against anything.
+func (e *errorCapture) classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	helmErr := &HelmError{Err: err}
+	if cancelled() {
+		helmErr.Category = categoryCancelled
+		return helmErr
+	}
+
+	lines := strings.Split(e.buf.String(), "\n")
+	for i := len(lines) - 1; i >= 0 && helmErr.Category == ""; i-- {
+		for _, known := range knownFailures {
+			if known.pattern.MatchString(lines[i]) {
+				helmErr.Category = known.category
+				helmErr.Detail = strings.TrimPrefix(strings.TrimSpace(lines[i]), "Error: ")
+				helmErr.Hint = known.hint
+				break
+			}
+		}
+	}
+	return helmErr
+}
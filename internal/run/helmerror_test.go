@@ -0,0 +1,135 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/stretchr/testify/suite"
+)
+
+// writesTo matches a writer whose output ends up in the given builder, such as one that tees into it.
+func writesTo(dest *strings.Builder) gomock.Matcher {
+	return writesToMatcher{dest}
+}
+
+type writesToMatcher struct {
+	dest *strings.Builder
+}
+
+func (m writesToMatcher) Matches(x interface{}) bool {
+	w, ok := x.(io.Writer)
+	if !ok {
+		return false
+	}
+	before := m.dest.String()
+	io.WriteString(w, "probe")
+	matched := m.dest.String() == before+"probe"
+
+	m.dest.Reset()
+	m.dest.WriteString(before)
+	return matched
+}
+
+func (m writesToMatcher) String() string {
+	return fmt.Sprintf("writes to %p", m.dest)
+}
+
+type HelmErrorTestSuite struct {
+	suite.Suite
+}
+
+func TestHelmErrorTestSuite(t *testing.T) {
+	suite.Run(t, new(HelmErrorTestSuite))
+}
+
+func (suite *HelmErrorTestSuite) classify(stderr string) *HelmError {
+	capture := newErrorCapture()
+	shown := &strings.Builder{}
+	io.WriteString(capture.output(shown), stderr)
+	suite.Equal(stderr, shown.String(), "output should still be shown")
+
+	err := capture.classify(errors.New("exit status 1"))
+	helmErr := &HelmError{}
+	suite.Require().True(errors.As(err, &helmErr))
+	return helmErr
+}
+
+func (suite *HelmErrorTestSuite) TestKnownFailures() {
+	tests := map[string]string{
+		"Error: UPGRADE FAILED: another operation (install/upgrade/rollback) is in progress": "release in progress",
+		`Error: UPGRADE FAILED: cannot patch "orthanc" with kind Deployment: Deployment.apps "orthanc" is invalid: ` +
+			`spec.selector: Invalid value: v1.LabelSelector{}: field is immutable`: "immutable field",
+		`Error: UPGRADE FAILED: secrets is forbidden: User "system:serviceaccount:isengard:saruman" cannot list ` +
+			`resource "secrets" in API group "" in the namespace "isengard"`: "forbidden by RBAC",
+		`Error: UPGRADE FAILED: unable to build kubernetes objects from release manifest: resource mapping not found ` +
+			`for name: "palantir" namespace: "" from "": no matches for kind "Seeing" in version "stones.example.com/v1"`: "no matches for kind",
+		"Error: UPGRADE FAILED: timed out waiting for the condition":                                "timed out waiting",
+		"Error: UPGRADE FAILED: context deadline exceeded":                                          "timed out waiting",
+		`Error: chart "anduril" not found in https://charts.example.com repository`:                 "chart not found",
+		`Error: chart "anduril" version "3.0.1" not found in https://charts.example.com repository`: "chart not found",
+		`Error: Kubernetes cluster unreachable: Get "https://k8s.example.com/version": x509: ` +
+			"certificate signed by unknown authority": "x509 unknown authority",
+	}
+	for stderr, category := range tests {
+		helmErr := suite.classify("Release \"orthanc\" does not exist. Installing it now.\n" + stderr + "\n")
+		suite.Equal(category, helmErr.Category, stderr)
+		suite.Equal(strings.TrimPrefix(stderr, "Error: "), helmErr.Detail)
+		suite.NotEmpty(helmErr.Hint)
+	}
+}
+
+func (suite *HelmErrorTestSuite) TestError() {
+	helmErr := suite.classify("Error: UPGRADE FAILED: timed out waiting for the condition\n")
+	suite.EqualError(helmErr, "timed out waiting: exit status 1: UPGRADE FAILED: timed out waiting for the condition "+
+		"(hint: the release's resources didn't become ready in time; check its pods' events and logs, or raise `timeout`)")
+	suite.EqualError(errors.Unwrap(helmErr), "exit status 1")
+	suite.True(helmErr.Timeout())
+	suite.False(helmErr.Cancelled())
+}
+
+func (suite *HelmErrorTestSuite) TestUnknownFailure() {
+	helmErr := suite.classify("Error: template: mordor/templates/gate.yaml:3:4: executing \"gate\" at <.Values.key>: nil pointer\n")
+	suite.Empty(helmErr.Category)
+	suite.EqualError(helmErr, "exit status 1", "unrecognized failures should be reported as they are")
+}
+
+func (suite *HelmErrorTestSuite) TestClassifySuccess() {
+	suite.NoError(newErrorCapture().classify(nil))
+}
+
+func (suite *HelmErrorTestSuite) TestCaptureKeepsTheEnd() {
+	capture := newErrorCapture()
+	w := capture.output(io.Discard)
+	io.WriteString(w, strings.Repeat("the road goes ever on\n", maxCapturedErrors/10))
+	io.WriteString(w, "Error: UPGRADE FAILED: another operation (install/upgrade/rollback) is in progress\n")
+	suite.LessOrEqual(capture.buf.Len(), maxCapturedErrors)
+
+	helmErr := &HelmError{}
+	suite.Require().True(errors.As(capture.classify(errors.New("exit status 1")), &helmErr))
+	suite.Equal("release in progress", helmErr.Category)
+}
+
+func (suite *HelmErrorTestSuite) TestStepsClassifyFailures() {
+	ctrl := gomock.NewController(suite.T())
+	defer ctrl.Finish()
+	_, restore := scriptedCommands(ctrl, scriptedRun{
+		stderr: "Error: UPGRADE FAILED: another operation (install/upgrade/rollback) is in progress\n",
+		err:    errors.New("exit status 1"),
+	})
+	defer restore()
+
+	stderr := &strings.Builder{}
+	u := NewUpgrade(env.Config{Chart: "./mordor", Release: "barad-dur", Stdout: &strings.Builder{}, Stderr: stderr})
+	suite.Require().NoError(u.Prepare())
+	err := u.Execute()
+
+	helmErr := &HelmError{}
+	suite.Require().True(errors.As(err, &helmErr))
+	suite.Equal("release in progress", helmErr.Category)
+	suite.Contains(stderr.String(), "another operation", "helm's output should still be shown")
+}
@@ -3,12 +3,14 @@ package run
 import (
 	"fmt"
 	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
 )
 
 // Help is a step in a helm Plan that calls `helm help`.
 type Help struct {
 	*config
 	helmCommand string
+	args        []string
 	cmd         cmd
 }
 
@@ -37,13 +39,19 @@ func (h *Help) Prepare() error {
 	args := h.globalFlags()
 	args = append(args, "help")
 
+	h.args = args
 	h.cmd = command(helmBin, args...)
-	h.cmd.Stdout(h.stdout)
-	h.cmd.Stderr(h.stderr)
+	h.cmd.Stdout(logging.Stream(h.stdout, "stdout"))
+	h.cmd.Stderr(logging.Stream(h.stderr, "stderr"))
 
 	if h.debug {
-		fmt.Fprintf(h.stderr, "Generated command: '%s'\n", h.cmd.String())
+		logging.Debugf(h.stderr, "Generated command: '%s'", h.cmd.String())
 	}
 
 	return nil
 }
+
+// Command returns the `helm help` command.
+func (h *Help) Command() string {
+	return redactCommand(h.args)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
 	"io"
 	"os"
 	"text/template"
@@ -48,7 +49,7 @@ func NewInitKube(cfg env.Config, templateFile, configFile string) *InitKube {
 // Execute generates a kubernetes config file from drone-helm3's template.
 func (i *InitKube) Execute() error {
 	if i.debug {
-		fmt.Fprintf(i.stderr, "writing kubeconfig file to %s\n", i.configFilename)
+		logging.Debugf(i.stderr, "writing kubeconfig file to %s", i.configFilename)
 	}
 	defer i.configFile.Close()
 	return i.template.Execute(i.configFile, i.values)
@@ -70,7 +71,7 @@ func (i *InitKube) Prepare() error {
 	}
 
 	if i.debug {
-		fmt.Fprintf(i.stderr, "loading kubeconfig template from %s\n", i.templateFilename)
+		logging.Debugf(i.stderr, "loading kubeconfig template from %s", i.templateFilename)
 	}
 	i.template, err = template.ParseFiles(i.templateFilename)
 	if err != nil {
@@ -78,13 +79,12 @@ func (i *InitKube) Prepare() error {
 	}
 
 	if i.debug {
+		action := "truncating"
 		if _, err := os.Stat(i.configFilename); err != nil {
 			// non-nil err here isn't an actual error state; the kubeconfig just doesn't exist
-			fmt.Fprint(i.stderr, "creating ")
-		} else {
-			fmt.Fprint(i.stderr, "truncating ")
+			action = "creating"
 		}
-		fmt.Fprintf(i.stderr, "kubeconfig file at %s\n", i.configFilename)
+		logging.Debugf(i.stderr, "%s kubeconfig file at %s", action, i.configFilename)
 	}
 
 	i.configFile, err = os.Create(i.configFilename)
@@ -0,0 +1,213 @@
+package run
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+var kubeRequestTimeout = 30 * time.Second
+
+// errNotFound is returned by kubeClient when the API server responds with 404.
+var errNotFound = errors.New("not found")
+
+// kubeClient makes requests to the Kubernetes API, using the cluster and credentials from a kubeconfig file. It's
+// meant for the handful of operations helm can't perform, not as a general-purpose client.
+type kubeClient struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Contexts       []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Clusters []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string      `yaml:"token"`
+			ClientCertificateData string      `yaml:"client-certificate-data"`
+			ClientKeyData         string      `yaml:"client-key-data"`
+			Exec                  interface{} `yaml:"exec"`
+			AuthProvider          interface{} `yaml:"auth-provider"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// newKubeClient reads the current context of the given kubeconfig file.
+func newKubeClient(kubeconfigFile string) (*kubeClient, error) {
+	raw, err := ioutil.ReadFile(kubeconfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read kubeconfig: %w", err)
+	}
+	var kc kubeconfig
+	if err := yaml.Unmarshal(raw, &kc); err != nil {
+		return nil, fmt.Errorf("could not parse kubeconfig: %w", err)
+	}
+
+	var clusterName, userName string
+	for _, c := range kc.Contexts {
+		if c.Name == kc.CurrentContext {
+			clusterName, userName = c.Context.Cluster, c.Context.User
+		}
+	}
+	if clusterName == "" {
+		return nil, fmt.Errorf("kubeconfig has no context named '%s'", kc.CurrentContext)
+	}
+
+	kube := &kubeClient{}
+	tlsConfig := &tls.Config{}
+	for _, c := range kc.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		kube.server = strings.TrimSuffix(c.Cluster.Server, "/")
+		tlsConfig.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+
+		var ca []byte
+		if c.Cluster.CertificateAuthorityData != "" {
+			if ca, err = base64.StdEncoding.DecodeString(c.Cluster.CertificateAuthorityData); err != nil {
+				return nil, fmt.Errorf("failed to base64-decode kubernetes certificate: %w", err)
+			}
+		} else if c.Cluster.CertificateAuthority != "" {
+			if ca, err = ioutil.ReadFile(c.Cluster.CertificateAuthority); err != nil {
+				return nil, fmt.Errorf("could not read kubernetes certificate: %w", err)
+			}
+		}
+		if ca != nil {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+				return nil, errors.New("failed to parse kubernetes certificate")
+			}
+		}
+	}
+	if kube.server == "" {
+		return nil, fmt.Errorf("kubeconfig has no server for cluster '%s'", clusterName)
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		// plugins would have to be run to get these credentials, and without them, requests would be unauthenticated
+		switch {
+		case u.User.Exec != nil:
+			return nil, fmt.Errorf("kubeconfig user '%s' gets its credentials from a command, which isn't supported", userName)
+		case u.User.AuthProvider != nil:
+			return nil, fmt.Errorf("kubeconfig user '%s' gets its credentials from an auth provider, which isn't supported",
+				userName)
+		}
+		kube.token = u.User.Token
+		if u.User.ClientCertificateData != "" {
+			cert, err := base64.StdEncoding.DecodeString(u.User.ClientCertificateData)
+			if err != nil {
+				return nil, fmt.Errorf("failed to base64-decode client certificate: %w", err)
+			}
+			key, err := base64.StdEncoding.DecodeString(u.User.ClientKeyData)
+			if err != nil {
+				return nil, fmt.Errorf("failed to base64-decode client key: %w", err)
+			}
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("could not load client certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+	}
+
+	kube.client = &http.Client{
+		Timeout:   kubeRequestTimeout,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+	}
+	return kube, nil
+}
+
+// do sends a request to the API server and decodes the JSON response into out, if out is non-nil.
+func (k *kubeClient) do(method, path string, out interface{}) error {
+	resp, err := k.send(method, path, "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// text sends a GET request to the API server and returns the response as plain text, such as a container's logs.
+func (k *kubeClient) text(path string) (string, error) {
+	resp, err := k.send(http.MethodGet, path, "text/plain")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
+
+// send sends a request to the API server, returning an error unless the response was successful.
+func (k *kubeClient) send(method, path, accept string) (*http.Response, error) {
+	req, err := http.NewRequest(method, k.server+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if k.token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, errNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return nil, apiError(resp)
+	}
+	return resp, nil
+}
+
+// apiError describes a failed request using the message from the API server's Status response, if there is one.
+func apiError(resp *http.Response) error {
+	var status struct {
+		Message string `json:"message"`
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(body, &status) == nil && status.Message != "" {
+		return fmt.Errorf("%s: %s", resp.Status, status.Message)
+	}
+	return errors.New(resp.Status)
+}
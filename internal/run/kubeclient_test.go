@@ -0,0 +1,120 @@
+package run
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type KubeClientTestSuite struct {
+	suite.Suite
+}
+
+func TestKubeClientTestSuite(t *testing.T) {
+	suite.Run(t, new(KubeClientTestSuite))
+}
+
+// writeTestKubeconfig creates a kubeconfig file that trusts the given test server and authenticates with a token.
+func writeTestKubeconfig(t *testing.T, server *httptest.Server, token string) string {
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	file, err := ioutil.TempFile("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	fmt.Fprintf(file, `apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority-data: %s
+    server: %s
+  name: helm
+contexts:
+- context:
+    cluster: helm
+    user: helm
+  name: helm
+current-context: "helm"
+kind: Config
+users:
+- name: helm
+  user:
+    token: %s
+`, base64.StdEncoding.EncodeToString(caCert), server.URL, token)
+	return file.Name()
+}
+
+func (suite *KubeClientTestSuite) TestDo() {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.Equal("Bearer b2FrIHRyZWU=", r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/api/v1/namespaces/mirkwood":
+			w.Write([]byte(`{"metadata": {"name": "mirkwood"}}`))
+		case "/api/v1/namespaces/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"kind": "Status", "message": "namespaces \"forbidden\" is forbidden"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	kubeconfig := writeTestKubeconfig(suite.T(), server, "b2FrIHRyZWU=")
+	defer os.Remove(kubeconfig)
+
+	kube, err := newKubeClient(kubeconfig)
+	suite.Require().NoError(err)
+	suite.NotNil(kube.client.Transport.(*http.Transport).Proxy, "HTTPS_PROXY and NO_PROXY should be respected")
+
+	var ns struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+	}
+	suite.Require().NoError(kube.do(http.MethodGet, "/api/v1/namespaces/mirkwood", &ns))
+	suite.Equal("mirkwood", ns.Metadata.Name)
+
+	suite.Equal(errNotFound, kube.do(http.MethodGet, "/api/v1/namespaces/lothlorien", nil))
+	suite.EqualError(kube.do(http.MethodGet, "/api/v1/namespaces/forbidden", nil), `403 Forbidden: namespaces "forbidden" is forbidden`)
+}
+
+func (suite *KubeClientTestSuite) TestNewKubeClientErrors() {
+	_, err := newKubeClient("/nonexistent/kubeconfig")
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "could not read kubeconfig")
+
+	file, err := ioutil.TempFile("", "kubeconfig")
+	suite.Require().NoError(err)
+	defer os.Remove(file.Name())
+	file.WriteString("current-context: elsewhere\n")
+	file.Close()
+
+	_, err = newKubeClient(file.Name())
+	suite.EqualError(err, "kubeconfig has no context named 'elsewhere'")
+}
+
+func (suite *KubeClientTestSuite) TestNewKubeClientWithPluginCredentials() {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	kubeconfig := writeTestKubeconfig(suite.T(), server, "b2FrIHRyZWU=")
+	defer os.Remove(kubeconfig)
+	raw, err := ioutil.ReadFile(kubeconfig)
+	suite.Require().NoError(err)
+
+	exec := strings.Replace(string(raw), "token: b2FrIHRyZWU=", "exec:\n      command: aws", 1)
+	suite.Require().NoError(ioutil.WriteFile(kubeconfig, []byte(exec), 0600))
+	_, err = newKubeClient(kubeconfig)
+	suite.EqualError(err, "kubeconfig user 'helm' gets its credentials from a command, which isn't supported")
+
+	provider := strings.Replace(string(raw), "token: b2FrIHRyZWU=", "auth-provider:\n      name: gcp", 1)
+	suite.Require().NoError(ioutil.WriteFile(kubeconfig, []byte(provider), 0600))
+	_, err = newKubeClient(kubeconfig)
+	suite.EqualError(err, "kubeconfig user 'helm' gets its credentials from an auth provider, which isn't supported")
+}
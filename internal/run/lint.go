@@ -3,6 +3,7 @@ package run
 import (
 	"fmt"
 	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
 )
 
 // Lint is an execution step that calls `helm lint` when executed.
@@ -13,6 +14,9 @@ type Lint struct {
 	stringValues string
 	valuesFiles  []string
 	strict       bool
+	resolver     *valuesResolver
+	errs         *errorCapture
+	args         []string
 	cmd          cmd
 }
 
@@ -25,12 +29,19 @@ func NewLint(cfg env.Config) *Lint {
 		stringValues: cfg.StringValues,
 		valuesFiles:  cfg.ValuesFiles,
 		strict:       cfg.LintStrictly,
+		resolver:     newValuesResolver(cfg),
+		errs:         newErrorCapture(),
 	}
 }
 
 // Execute executes the `helm lint` command.
 func (l *Lint) Execute() error {
-	return l.cmd.Run()
+	return l.errs.classify(l.cmd.Run())
+}
+
+// Cleanup removes any decrypted values files created by Prepare.
+func (l *Lint) Cleanup() {
+	l.resolver.cleanup()
 }
 
 // Prepare gets the Lint ready to execute.
@@ -48,7 +59,11 @@ func (l *Lint) Prepare() error {
 	if l.stringValues != "" {
 		args = append(args, "--set-string", l.stringValues)
 	}
-	for _, vFile := range l.valuesFiles {
+	valuesFiles, err := l.resolver.resolve(l.valuesFiles)
+	if err != nil {
+		return err
+	}
+	for _, vFile := range valuesFiles {
 		args = append(args, "--values", vFile)
 	}
 	if l.strict {
@@ -57,13 +72,19 @@ func (l *Lint) Prepare() error {
 
 	args = append(args, l.chart)
 
+	l.args = args
 	l.cmd = command(helmBin, args...)
-	l.cmd.Stdout(l.stdout)
-	l.cmd.Stderr(l.stderr)
+	l.cmd.Stdout(logging.Stream(l.stdout, "stdout"))
+	l.cmd.Stderr(l.errs.output(logging.Stream(l.stderr, "stderr")))
 
 	if l.debug {
-		fmt.Fprintf(l.stderr, "Generated command: '%s'\n", l.cmd.String())
+		logging.Debugf(l.stderr, "Generated command: '%s'", l.cmd.String())
 	}
 
 	return nil
 }
+
+// Command returns the `helm lint` command, with any --set and --set-string values hidden.
+func (l *Lint) Command() string {
+	return redactCommand(l.args)
+}
@@ -73,7 +73,7 @@ func (suite *LintTestSuite) TestPrepareAndExecute() {
 	suite.mockCmd.EXPECT().
 		Stdout(&stdout)
 	suite.mockCmd.EXPECT().
-		Stderr(&stderr)
+		Stderr(writesTo(&stderr))
 	suite.mockCmd.EXPECT().
 		Run().
 		Times(1)
@@ -0,0 +1,43 @@
+package run
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const (
+	maxReleaseNameLength = 53
+	maxNamespaceLength   = 63
+)
+
+var (
+	releaseNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+	namespacePattern   = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+)
+
+// validateReleaseName applies the same rules as helm, so that a bad (possibly templated) name is reported before any
+// helm command runs.
+func validateReleaseName(name string) error {
+	if len(name) > maxReleaseNameLength {
+		return fmt.Errorf("release name '%s' is longer than %d characters", name, maxReleaseNameLength)
+	}
+	if !releaseNamePattern.MatchString(name) {
+		return fmt.Errorf("release name '%s' must consist of lowercase letters, numbers, '-' and '.'", name)
+	}
+	return nil
+}
+
+// validateNamespace checks that a namespace is a valid DNS-1123 label. The empty string is allowed, since it means
+// "use the kubeconfig's namespace."
+func validateNamespace(namespace string) error {
+	if namespace == "" {
+		return nil
+	}
+	if len(namespace) > maxNamespaceLength {
+		return fmt.Errorf("namespace '%s' is longer than %d characters", namespace, maxNamespaceLength)
+	}
+	if !namespacePattern.MatchString(namespace) {
+		return fmt.Errorf("namespace '%s' must consist of lowercase letters, numbers and '-'", namespace)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package run
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type NamesTestSuite struct {
+	suite.Suite
+}
+
+func TestNamesTestSuite(t *testing.T) {
+	suite.Run(t, new(NamesTestSuite))
+}
+
+func (suite *NamesTestSuite) TestValidateReleaseName() {
+	for _, name := range []string{"myapp", "myapp-pr-123", "my.app", "0day", strings.Repeat("a", 53)} {
+		suite.NoError(validateReleaseName(name), name)
+	}
+
+	suite.EqualError(validateReleaseName("my_app"), "release name 'my_app' must consist of lowercase letters, numbers, '-' and '.'")
+	suite.EqualError(validateReleaseName("MyApp"), "release name 'MyApp' must consist of lowercase letters, numbers, '-' and '.'")
+	suite.EqualError(validateReleaseName("myapp-"), "release name 'myapp-' must consist of lowercase letters, numbers, '-' and '.'")
+	suite.EqualError(validateReleaseName(strings.Repeat("a", 54)), "release name '"+strings.Repeat("a", 54)+"' is longer than 53 characters")
+}
+
+func (suite *NamesTestSuite) TestValidateNamespace() {
+	for _, name := range []string{"", "default", "myapp-pr-123", strings.Repeat("a", 63)} {
+		suite.NoError(validateNamespace(name), name)
+	}
+
+	suite.EqualError(validateNamespace("my.app"), "namespace 'my.app' must consist of lowercase letters, numbers and '-'")
+	suite.EqualError(validateNamespace("-myapp"), "namespace '-myapp' must consist of lowercase letters, numbers and '-'")
+	suite.EqualError(validateNamespace(strings.Repeat("a", 64)), "namespace '"+strings.Repeat("a", 64)+"' is longer than 63 characters")
+}
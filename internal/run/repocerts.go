@@ -1,9 +1,12 @@
 package run
 
 import (
+	"crypto/x509"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
 	"io/ioutil"
 )
 
@@ -36,7 +39,7 @@ func (rc *repoCerts) write() error {
 			return fmt.Errorf("failed to base64-decode certificate string: %w", err)
 		}
 		if rc.debug {
-			fmt.Fprintf(rc.stderr, "writing repo certificate to %s\n", rc.certFilename)
+			logging.Debugf(rc.stderr, "writing repo certificate to %s", rc.certFilename)
 		}
 		if _, err := file.Write(rawCert); err != nil {
 			return fmt.Errorf("failed to write certificate file: %w", err)
@@ -55,7 +58,7 @@ func (rc *repoCerts) write() error {
 			return fmt.Errorf("failed to base64-decode CA certificate string: %w", err)
 		}
 		if rc.debug {
-			fmt.Fprintf(rc.stderr, "writing repo ca certificate to %s\n", rc.caCertFilename)
+			logging.Debugf(rc.stderr, "writing repo ca certificate to %s", rc.caCertFilename)
 		}
 		if _, err := file.Write(rawCert); err != nil {
 			return fmt.Errorf("failed to write CA certificate file: %w", err)
@@ -75,3 +78,23 @@ func (rc *repoCerts) flags() []string {
 
 	return flags
 }
+
+// certPool returns the system's trusted certificates, plus the repo CA certificate if one is configured.
+func (rc *repoCerts) certPool() (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if rc.caCert == "" {
+		return pool, nil
+	}
+
+	rawCert, err := base64.StdEncoding.DecodeString(rc.caCert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to base64-decode CA certificate string: %w", err)
+	}
+	if !pool.AppendCertsFromPEM(rawCert) {
+		return nil, errors.New("failed to parse CA certificate")
+	}
+	return pool, nil
+}
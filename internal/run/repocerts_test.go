@@ -78,3 +78,14 @@ func (suite *RepoCertsTestSuite) TestDebug() {
 	suite.Contains(stderr.String(), fmt.Sprintf("writing repo certificate to %s", rc.certFilename))
 	suite.Contains(stderr.String(), fmt.Sprintf("writing repo ca certificate to %s", rc.caCertFilename))
 }
+
+func (suite *RepoCertsTestSuite) TestCertPool() {
+	rc := newRepoCerts(env.Config{})
+	pool, err := rc.certPool()
+	suite.NoError(err)
+	suite.NotNil(pool)
+
+	rc = newRepoCerts(env.Config{RepoCACertificate: "T3JlZ29uIFN0YXRlIExpY2Vuc3VyZSBib2FyZA=="})
+	_, err = rc.certPool()
+	suite.EqualError(err, "failed to parse CA certificate")
+}
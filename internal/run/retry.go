@@ -0,0 +1,153 @@
+package run
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
+)
+
+const defaultRetryBackoff = 5 * time.Second
+
+var sleep = time.Sleep
+
+// defaultRetryableErrors match helm errors that are caused by network or API server trouble, rather than by the
+// chart or the cluster's state.
+var defaultRetryableErrors = []string{
+	`connection refused`,
+	`connection reset by peer`,
+	`i/o timeout`,
+	`TLS handshake timeout`,
+	`unexpected EOF`,
+	`Client\.Timeout exceeded`,
+	`http2: server sent GOAWAY`,
+	`the server is currently unable to handle the request`,
+	`etcdserver: (request timed out|leader changed)`,
+	`(502 Bad Gateway|503 Service Unavailable|504 Gateway Timeout)`,
+}
+
+// retryPolicy re-runs helm commands that fail with errors that are likely to be transient.
+type retryPolicy struct {
+	*config
+	retries   int
+	backoff   string
+	patterns  []string
+	delay     time.Duration
+	retryable []*regexp.Regexp
+	captured  *bytes.Buffer
+	dest      io.Writer
+	errs      *errorCapture
+}
+
+func newRetryPolicy(cfg env.Config) *retryPolicy {
+	return &retryPolicy{
+		config:   newConfig(cfg),
+		retries:  cfg.Retries,
+		backoff:  cfg.RetryBackoff,
+		patterns: cfg.RetryableErrors,
+		captured: &bytes.Buffer{},
+	}
+}
+
+// prepare validates the retry settings.
+func (r *retryPolicy) prepare() error {
+	if r.retries < 0 {
+		return errors.New("retries can't be negative")
+	}
+
+	r.delay = defaultRetryBackoff
+	if r.backoff != "" {
+		delay, err := time.ParseDuration(r.backoff)
+		if err != nil {
+			return fmt.Errorf("invalid retry_backoff '%s': %w", r.backoff, err)
+		}
+		r.delay = delay
+	}
+
+	patterns := r.patterns
+	if len(patterns) == 0 {
+		patterns = defaultRetryableErrors
+	}
+	r.retryable = nil
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid retryable error pattern '%s': %w", pattern, err)
+		}
+		r.retryable = append(r.retryable, re)
+	}
+	return nil
+}
+
+func (r *retryPolicy) enabled() bool {
+	return r.retries > 0
+}
+
+// output returns the writer a command's stderr should be sent to: stderr, with a copy for errs to classify the
+// command's failure by. When retries are enabled, it's captured here as well, so that it can be checked for retryable
+// errors. Commands created for retries send their stderr to the same places.
+func (r *retryPolicy) output(stderr io.Writer, errs *errorCapture) io.Writer {
+	r.dest, r.errs = stderr, errs
+	if !r.enabled() {
+		return errs.output(stderr)
+	}
+	return io.MultiWriter(errs.output(stderr), r.captured)
+}
+
+// run runs the given command, which must have been created with the given args. If it fails with a retryable error,
+// it's recreated and run again, waiting twice as long before each attempt. If safeToRetry is non-nil, it's called
+// before each retry, and any error it returns is the reason the command shouldn't be run again.
+func (r *retryPolicy) run(c cmd, args []string, safeToRetry func() error) error {
+	for attempt := 1; ; attempt++ {
+		// only this attempt's output should be checked, or used to classify its failure
+		r.captured.Reset()
+		r.errs.reset()
+		err := c.Run()
+		if err == nil || !r.enabled() || cancelled() {
+			return err
+		}
+
+		match := r.match()
+		if match == "" {
+			return err
+		}
+		stderr := logging.WithFields(r.stderr, logging.Fields{"attempt": attempt, "attempts": r.retries + 1, "error": match})
+		if attempt > r.retries {
+			logging.Infof(stderr, "attempt %d of %d failed with a retryable error (%s); giving up", attempt, r.retries+1, match)
+			return err
+		}
+		if safeToRetry != nil {
+			if reason := safeToRetry(); reason != nil {
+				logging.Infof(stderr, "attempt %d of %d failed with a retryable error (%s), but it can't be retried: %s",
+					attempt, r.retries+1, match, reason)
+				return err
+			}
+		}
+
+		delay := r.delay << (attempt - 1)
+		logging.Infof(stderr, "attempt %d of %d failed with a retryable error (%s); retrying in %s", attempt, r.retries+1, match, delay)
+		sleep(delay)
+
+		c = command(helmBin, args...)
+		c.Stdout(logging.Stream(r.stdout, "stdout"))
+		c.Stderr(r.output(r.dest, r.errs))
+		if r.debug {
+			logging.Debugf(r.stderr, "Generated command: '%s'", c.String())
+		}
+	}
+}
+
+// match returns the text of the first retryable error in the captured output, or "" if there isn't one.
+func (r *retryPolicy) match() string {
+	for _, re := range r.retryable {
+		if found := re.Find(r.captured.Bytes()); found != nil {
+			return string(found)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,189 @@
+package run
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/stretchr/testify/suite"
+)
+
+// A scriptedRun is the behaviour of one command created through scriptedCommands.
+type scriptedRun struct {
+	stdout string
+	stderr string
+	err    error
+}
+
+// scriptedCommands replaces the command function with one whose commands write output and return errors according to
+// the script, in order. It returns the args of every command created, and a function to restore the original.
+func scriptedCommands(ctrl *gomock.Controller, script ...scriptedRun) (*[][]string, func()) {
+	var calls [][]string
+	original := command
+	command = func(path string, args ...string) cmd {
+		calls = append(calls, args)
+		var step scriptedRun
+		if len(calls) <= len(script) {
+			step = script[len(calls)-1]
+		}
+
+		var stdout, stderr io.Writer
+		mockCmd := NewMockcmd(ctrl)
+		mockCmd.EXPECT().Stdout(gomock.Any()).Do(func(w io.Writer) { stdout = w }).AnyTimes()
+		mockCmd.EXPECT().Stderr(gomock.Any()).Do(func(w io.Writer) { stderr = w }).AnyTimes()
+		mockCmd.EXPECT().String().Return(helmBin + " " + strings.Join(args, " ")).AnyTimes()
+		mockCmd.EXPECT().Run().DoAndReturn(func() error {
+			if stdout != nil {
+				io.WriteString(stdout, step.stdout)
+			}
+			if stderr != nil {
+				io.WriteString(stderr, step.stderr)
+			}
+			return step.err
+		}).AnyTimes()
+		return mockCmd
+	}
+	return &calls, func() { command = original }
+}
+
+type RetryTestSuite struct {
+	suite.Suite
+	ctrl          *gomock.Controller
+	sleeps        []time.Duration
+	originalSleep func(time.Duration)
+}
+
+func (suite *RetryTestSuite) BeforeTest(_, _ string) {
+	suite.ctrl = gomock.NewController(suite.T())
+	suite.sleeps = nil
+	suite.originalSleep = sleep
+	sleep = func(d time.Duration) {
+		suite.sleeps = append(suite.sleeps, d)
+	}
+}
+
+func (suite *RetryTestSuite) AfterTest(_, _ string) {
+	suite.ctrl.Finish()
+	sleep = suite.originalSleep
+}
+
+func TestRetryTestSuite(t *testing.T) {
+	suite.Run(t, new(RetryTestSuite))
+}
+
+func (suite *RetryTestSuite) TestPrepare() {
+	r := newRetryPolicy(env.Config{Retries: 2})
+	suite.Require().NoError(r.prepare())
+	suite.Equal(defaultRetryBackoff, r.delay)
+	suite.Len(r.retryable, len(defaultRetryableErrors))
+
+	r = newRetryPolicy(env.Config{Retries: 2, RetryBackoff: "30s", RetryableErrors: []string{"quota exceeded"}})
+	suite.Require().NoError(r.prepare())
+	suite.Equal(30*time.Second, r.delay)
+	suite.Len(r.retryable, 1)
+
+	suite.EqualError(newRetryPolicy(env.Config{Retries: -1}).prepare(), "retries can't be negative")
+	suite.Error(newRetryPolicy(env.Config{RetryBackoff: "soon"}).prepare())
+	err := newRetryPolicy(env.Config{RetryableErrors: []string{"("}}).prepare()
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "invalid retryable error pattern '('")
+}
+
+func (suite *RetryTestSuite) TestOutput() {
+	stderr, errs := &strings.Builder{}, newErrorCapture()
+	r := newRetryPolicy(env.Config{})
+	io.WriteString(r.output(stderr, errs), "Error: connection refused")
+	suite.Equal("Error: connection refused", stderr.String())
+	suite.Equal("Error: connection refused", errs.buf.String())
+	suite.Empty(r.captured.String(), "stderr shouldn't be captured without retries")
+
+	stderr, errs = &strings.Builder{}, newErrorCapture()
+	r = newRetryPolicy(env.Config{Retries: 1})
+	io.WriteString(r.output(stderr, errs), "Error: connection refused")
+	suite.Equal("Error: connection refused", stderr.String())
+	suite.Equal("Error: connection refused", errs.buf.String())
+	suite.Equal("Error: connection refused", r.captured.String())
+}
+
+func (suite *RetryTestSuite) runScript(cfg env.Config, safeToRetry func() error, script ...scriptedRun) ([][]string, error) {
+	calls, restore := scriptedCommands(suite.ctrl, script...)
+	defer restore()
+
+	r := newRetryPolicy(cfg)
+	suite.Require().NoError(r.prepare())
+	args := []string{"repo", "add", "bree", "https://bree.example.com"}
+	c := command(helmBin, args...)
+	c.Stdout(cfg.Stdout)
+	errs := newErrorCapture()
+	c.Stderr(r.output(cfg.Stderr, errs))
+
+	err := errs.classify(r.run(c, args, safeToRetry))
+	return *calls, err
+}
+
+func (suite *RetryTestSuite) TestRunRetriesTransientErrors() {
+	stderr := &strings.Builder{}
+	cfg := env.Config{Retries: 3, RetryBackoff: "2s", Stdout: &strings.Builder{}, Stderr: stderr}
+	calls, err := suite.runScript(cfg, nil,
+		scriptedRun{stderr: "Error: dial tcp 10.0.0.1:443: connect: connection refused\n", err: errors.New("exit status 1")},
+		scriptedRun{stderr: "Error: net/http: TLS handshake timeout\n", err: errors.New("exit status 1")},
+		scriptedRun{stdout: "\"bree\" has been added to your repositories\n"},
+	)
+	suite.NoError(err)
+	suite.Len(calls, 3)
+	suite.Equal(calls[0], calls[2], "each attempt should run the same command")
+	suite.Equal([]time.Duration{2 * time.Second, 4 * time.Second}, suite.sleeps)
+	suite.Contains(stderr.String(), "attempt 1 of 4 failed with a retryable error (connection refused); retrying in 2s\n")
+	suite.Contains(stderr.String(), "attempt 2 of 4 failed with a retryable error (TLS handshake timeout); retrying in 4s\n")
+}
+
+func (suite *RetryTestSuite) TestRunGivesUp() {
+	stderr := &strings.Builder{}
+	cfg := env.Config{Retries: 1, Stdout: &strings.Builder{}, Stderr: stderr}
+	failure := scriptedRun{stderr: "Error: unexpected EOF\n", err: errors.New("exit status 1")}
+	calls, err := suite.runScript(cfg, nil, failure, failure, failure)
+	suite.EqualError(err, "exit status 1")
+	suite.Len(calls, 2)
+	suite.Contains(stderr.String(), "attempt 2 of 2 failed with a retryable error (unexpected EOF); giving up\n")
+}
+
+func (suite *RetryTestSuite) TestRunDoesNotRetryOtherErrors() {
+	cfg := env.Config{Retries: 3, Stdout: &strings.Builder{}, Stderr: &strings.Builder{}}
+	calls, err := suite.runScript(cfg, nil,
+		scriptedRun{stderr: "Error: looks like \"https://bree.example.com\" is not a valid chart repository\n", err: errors.New("exit status 1")},
+	)
+	suite.EqualError(err, "exit status 1")
+	suite.Len(calls, 1)
+	suite.Empty(suite.sleeps)
+
+	cfg.Retries = 0
+	calls, err = suite.runScript(cfg, nil, scriptedRun{stderr: "Error: connection refused\n", err: errors.New("exit status 1")})
+	suite.Error(err)
+	suite.Len(calls, 1, "nothing should be retried by default")
+}
+
+func (suite *RetryTestSuite) TestRunClassifiesTheLastAttempt() {
+	cfg := env.Config{Retries: 1, Stdout: &strings.Builder{}, Stderr: &strings.Builder{}}
+	_, err := suite.runScript(cfg, nil,
+		scriptedRun{stderr: "Error: context deadline exceeded: i/o timeout\n", err: errors.New("exit status 1")},
+		scriptedRun{stderr: "Error: the eagles are coming\n", err: errors.New("exit status 1")},
+	)
+	var helmErr *HelmError
+	suite.Require().True(errors.As(err, &helmErr))
+	suite.Empty(helmErr.Category, "the first attempt's timeout shouldn't be blamed for the last attempt's failure")
+}
+
+func (suite *RetryTestSuite) TestRunAsksWhetherRetryIsSafe() {
+	stderr := &strings.Builder{}
+	cfg := env.Config{Retries: 3, Stdout: &strings.Builder{}, Stderr: stderr}
+	calls, err := suite.runScript(cfg, func() error { return errors.New("the doors of durin are open") },
+		scriptedRun{stderr: "Error: connection reset by peer\n", err: errors.New("exit status 1")},
+	)
+	suite.EqualError(err, "exit status 1")
+	suite.Len(calls, 1)
+	suite.Contains(stderr.String(), "but it can't be retried: the doors of durin are open\n")
+}
@@ -0,0 +1,236 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
+)
+
+const (
+	defaultSmokeTestTimeout  = 2 * time.Minute
+	defaultSmokeTestInterval = 5 * time.Second
+	maxSmokeTestBody         = 1 << 20
+)
+
+var smokeTestRequestTimeout = 10 * time.Second
+
+// SmokeTest is an execution step that checks that a release's URLs respond as expected, retrying until they do or
+// until the timeout, and optionally rolls the release back if they don't.
+type SmokeTest struct {
+	*config
+	release     string
+	tests       env.SmokeTests
+	timeout     string
+	interval    string
+	rollback    bool
+	wait        bool
+	helmTimeout string
+
+	deadline time.Duration
+	delay    time.Duration
+	bodies   []*regexp.Regexp
+	client   *http.Client
+	errs     *errorCapture
+}
+
+// NewSmokeTest creates a SmokeTest using fields from the given Config. No validation is performed at this time.
+func NewSmokeTest(cfg env.Config) *SmokeTest {
+	return &SmokeTest{
+		config:      newConfig(cfg),
+		release:     cfg.Release,
+		tests:       cfg.SmokeTests,
+		timeout:     cfg.SmokeTestTimeout,
+		interval:    cfg.SmokeTestInterval,
+		rollback:    cfg.SmokeTestRollback,
+		wait:        cfg.Wait,
+		helmTimeout: cfg.Timeout,
+		errs:        newErrorCapture(),
+	}
+}
+
+// Prepare validates the smoke tests' settings.
+func (s *SmokeTest) Prepare() error {
+	if s.release == "" {
+		return fmt.Errorf("release is required")
+	}
+
+	s.deadline = defaultSmokeTestTimeout
+	if s.timeout != "" {
+		timeout, err := time.ParseDuration(s.timeout)
+		if err != nil {
+			return fmt.Errorf("invalid smoke_test_timeout '%s': %w", s.timeout, err)
+		}
+		s.deadline = timeout
+	}
+	s.delay = defaultSmokeTestInterval
+	if s.interval != "" {
+		interval, err := time.ParseDuration(s.interval)
+		if err != nil {
+			return fmt.Errorf("invalid smoke_test_interval '%s': %w", s.interval, err)
+		}
+		s.delay = interval
+	}
+
+	s.bodies = make([]*regexp.Regexp, len(s.tests))
+	for i, test := range s.tests {
+		if test.Body == "" {
+			continue
+		}
+		re, err := regexp.Compile(test.Body)
+		if err != nil {
+			return fmt.Errorf("invalid body pattern '%s' for smoke test of %s: %w", test.Body, test.URL, err)
+		}
+		s.bodies[i] = re
+	}
+
+	s.client = &http.Client{Timeout: smokeTestRequestTimeout}
+	return nil
+}
+
+// Execute checks each of the URLs in turn, and rolls the release back if any of them don't pass in time and
+// rolling back was asked for.
+func (s *SmokeTest) Execute() error {
+	deadline := now().Add(s.deadline)
+	stdout := logging.WithFields(s.stdout, logging.Fields{"release": s.release})
+	for i, test := range s.tests {
+		if err := s.poll(test, s.bodies[i], deadline); err != nil {
+			return s.fail(err)
+		}
+		logging.Infof(stdout, "smoke test of %s passed", test.URL)
+	}
+	return nil
+}
+
+// poll checks a URL until it passes, or until there isn't time for another attempt before the deadline.
+func (s *SmokeTest) poll(test env.SmokeTest, body *regexp.Regexp, deadline time.Time) error {
+	for attempt := 1; ; attempt++ {
+		err := s.check(test, body)
+		if err == nil {
+			return nil
+		}
+		if s.debug {
+			logging.Debugf(s.stderr, "smoke test of %s failed (attempt %d): %s", test.URL, attempt, err)
+		}
+		if !now().Add(s.delay).Before(deadline) {
+			attempts := "attempts"
+			if attempt == 1 {
+				attempts = "attempt"
+			}
+			return fmt.Errorf("smoke test of %s failed after %d %s: %w", test.URL, attempt, attempts, err)
+		}
+		sleep(s.delay)
+	}
+}
+
+func (s *SmokeTest) check(test env.SmokeTest, body *regexp.Regexp) error {
+	resp, err := s.client.Get(test.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != test.Status {
+		return fmt.Errorf("expected status %d, got %s", test.Status, resp.Status)
+	}
+	if body == nil && test.JSONPath == "" {
+		return nil
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSmokeTestBody))
+	if err != nil {
+		return fmt.Errorf("could not read the response: %w", err)
+	}
+	if body != nil && !body.Match(content) {
+		return fmt.Errorf("the response doesn't match '%s'", test.Body)
+	}
+	if test.JSONPath == "" {
+		return nil
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return fmt.Errorf("the response isn't JSON: %w", err)
+	}
+	value, found := lookupJSONPath(doc, test.JSONPath)
+	switch {
+	case !found:
+		return fmt.Errorf("the response has nothing at %s", test.JSONPath)
+	case test.JSONValue != "" && value != test.JSONValue:
+		return fmt.Errorf("expected %s to be '%s', got '%s'", test.JSONPath, test.JSONValue, value)
+	}
+	return nil
+}
+
+// fail rolls the release back to its previous revision, if that was asked for, and explains what happened.
+func (s *SmokeTest) fail(err error) error {
+	if !s.rollback {
+		return err
+	}
+
+	status, statusErr := getReleaseStatus(s.config, s.release)
+	if statusErr != nil {
+		return fmt.Errorf("%w; could not roll back: %s", err, statusErr)
+	}
+	if status.revision() < 2 {
+		return fmt.Errorf("%w; release %s has no earlier revision to roll back to", err, s.release)
+	}
+
+	args := s.globalFlags()
+	args = append(args, "rollback", s.release)
+	if s.wait {
+		args = append(args, "--wait")
+	}
+	if s.helmTimeout != "" {
+		args = append(args, "--timeout", s.helmTimeout)
+	}
+	c := command(helmBin, args...)
+	c.Stdout(logging.Stream(s.stdout, "stdout"))
+	c.Stderr(s.errs.output(logging.Stream(s.stderr, "stderr")))
+	if s.debug {
+		logging.Debugf(s.stderr, "Generated command: '%s'", c.String())
+	}
+	if rollbackErr := s.errs.classify(c.Run()); rollbackErr != nil {
+		return fmt.Errorf("%w; could not roll back: %s", err, rollbackErr)
+	}
+	return fmt.Errorf("%w; release %s was rolled back to revision %d", err, s.release, status.revision()-1)
+}
+
+// lookupJSONPath finds the value at a dot-separated path, such as "checks.0.status", in a JSON document, formatted
+// as a string: strings as they are, and anything else as JSON.
+func lookupJSONPath(doc interface{}, path string) (string, bool) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	value := doc
+	if path != "" {
+		for _, key := range strings.Split(path, ".") {
+			switch node := value.(type) {
+			case map[string]interface{}:
+				var ok bool
+				if value, ok = node[key]; !ok {
+					return "", false
+				}
+			case []interface{}:
+				index, err := strconv.Atoi(key)
+				if err != nil || index < 0 || index >= len(node) {
+					return "", false
+				}
+				value = node[index]
+			default:
+				return "", false
+			}
+		}
+	}
+
+	if s, ok := value.(string); ok {
+		return s, true
+	}
+	encoded, _ := json.Marshal(value)
+	return string(encoded), true
+}
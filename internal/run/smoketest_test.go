@@ -0,0 +1,244 @@
+package run
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+)
+
+type SmokeTestTestSuite struct {
+	suite.Suite
+	ctrl          *gomock.Controller
+	server        *httptest.Server
+	responses     []func(w http.ResponseWriter) // one per request, repeating the last
+	requests      []string
+	clock         time.Time
+	sleeps        []time.Duration
+	originalNow   func() time.Time
+	originalSleep func(time.Duration)
+}
+
+func (suite *SmokeTestTestSuite) BeforeTest(_, _ string) {
+	suite.ctrl = gomock.NewController(suite.T())
+	suite.responses, suite.requests, suite.sleeps = nil, nil, nil
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.requests = append(suite.requests, r.URL.Path)
+		i := len(suite.requests) - 1
+		if i >= len(suite.responses) {
+			i = len(suite.responses) - 1
+		}
+		suite.responses[i](w)
+	}))
+
+	suite.clock = time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	suite.originalNow, suite.originalSleep = now, sleep
+	now = func() time.Time { return suite.clock }
+	sleep = func(d time.Duration) {
+		suite.sleeps = append(suite.sleeps, d)
+		suite.clock = suite.clock.Add(d)
+	}
+}
+
+func (suite *SmokeTestTestSuite) AfterTest(_, _ string) {
+	suite.ctrl.Finish()
+	suite.server.Close()
+	now, sleep = suite.originalNow, suite.originalSleep
+}
+
+func TestSmokeTestTestSuite(t *testing.T) {
+	suite.Run(t, new(SmokeTestTestSuite))
+}
+
+func respond(status int, body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+func (suite *SmokeTestTestSuite) config(tests ...env.SmokeTest) env.Config {
+	return env.Config{
+		Release:    "edoras",
+		Namespace:  "rohan",
+		SmokeTests: tests,
+		Stdout:     &strings.Builder{},
+		Stderr:     &strings.Builder{},
+	}
+}
+
+func (suite *SmokeTestTestSuite) TestPrepare() {
+	s := NewSmokeTest(suite.config())
+	suite.Require().NoError(s.Prepare())
+	suite.Equal(2*time.Minute, s.deadline)
+	suite.Equal(5*time.Second, s.delay)
+
+	cfg := suite.config()
+	cfg.SmokeTestTimeout, cfg.SmokeTestInterval = "30s", "2s"
+	s = NewSmokeTest(cfg)
+	suite.Require().NoError(s.Prepare())
+	suite.Equal(30*time.Second, s.deadline)
+	suite.Equal(2*time.Second, s.delay)
+}
+
+func (suite *SmokeTestTestSuite) TestPrepareErrors() {
+	suite.EqualError(NewSmokeTest(env.Config{}).Prepare(), "release is required")
+
+	cfg := suite.config()
+	cfg.SmokeTestTimeout = "soon"
+	suite.EqualError(NewSmokeTest(cfg).Prepare(), `invalid smoke_test_timeout 'soon': time: invalid duration "soon"`)
+
+	cfg = suite.config()
+	cfg.SmokeTestInterval = "often"
+	suite.EqualError(NewSmokeTest(cfg).Prepare(),
+		`invalid smoke_test_interval 'often': time: invalid duration "often"`)
+
+	cfg = suite.config(env.SmokeTest{URL: "https://edoras.example.com", Status: 200, Body: "(unclosed"})
+	err := NewSmokeTest(cfg).Prepare()
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "invalid body pattern '(unclosed' for smoke test of https://edoras.example.com: ")
+}
+
+func (suite *SmokeTestTestSuite) TestExecute() {
+	suite.responses = []func(http.ResponseWriter){
+		respond(http.StatusOK, "welcome to meduseld"),
+		respond(http.StatusNoContent, ""),
+		respond(http.StatusOK, `{"build": {"commit": "a1b2c3d", "replicas": 3}, "checks": [{"ok": true}]}`),
+	}
+	cfg := suite.config(
+		env.SmokeTest{URL: suite.server.URL + "/", Status: 200, Body: "meduseld"},
+		env.SmokeTest{URL: suite.server.URL + "/healthz", Status: 204},
+		env.SmokeTest{URL: suite.server.URL + "/version", Status: 200, JSONPath: "build.commit", JSONValue: "a1b2c3d"},
+	)
+	s := NewSmokeTest(cfg)
+	suite.Require().NoError(s.Prepare())
+	suite.Require().NoError(s.Execute())
+
+	suite.Equal([]string{"/", "/healthz", "/version"}, suite.requests)
+	suite.Empty(suite.sleeps)
+	suite.Contains(cfg.Stdout.(*strings.Builder).String(), "smoke test of "+suite.server.URL+"/healthz passed\n")
+}
+
+func (suite *SmokeTestTestSuite) TestExecuteRetries() {
+	suite.responses = []func(http.ResponseWriter){
+		respond(http.StatusServiceUnavailable, "no healthy upstream"),
+		respond(http.StatusOK, "starting"),
+		respond(http.StatusOK, "ready"),
+	}
+	cfg := suite.config(env.SmokeTest{URL: suite.server.URL, Status: 200, Body: "^ready$"})
+	cfg.SmokeTestInterval = "3s"
+	s := NewSmokeTest(cfg)
+	suite.Require().NoError(s.Prepare())
+	suite.Require().NoError(s.Execute())
+	suite.Equal([]time.Duration{3 * time.Second, 3 * time.Second}, suite.sleeps)
+}
+
+func (suite *SmokeTestTestSuite) TestExecuteTimeout() {
+	suite.responses = []func(http.ResponseWriter){respond(http.StatusBadGateway, "")}
+	cfg := suite.config(env.SmokeTest{URL: suite.server.URL, Status: 200})
+	cfg.SmokeTestTimeout, cfg.SmokeTestInterval = "10s", "4s"
+	s := NewSmokeTest(cfg)
+	suite.Require().NoError(s.Prepare())
+
+	err := s.Execute()
+	suite.EqualError(err, "smoke test of "+suite.server.URL+" failed after 3 attempts: expected status 200, "+
+		"got 502 Bad Gateway")
+	suite.Equal([]time.Duration{4 * time.Second, 4 * time.Second}, suite.sleeps,
+		"there shouldn't be a wait that ends after the timeout")
+}
+
+func (suite *SmokeTestTestSuite) TestCheckFailures() {
+	s := NewSmokeTest(suite.config())
+	suite.Require().NoError(s.Prepare())
+
+	tests := []struct {
+		response string
+		test     env.SmokeTest
+		err      string
+	}{
+		{"all is well", env.SmokeTest{Body: "^ready"}, "the response doesn't match '^ready'"},
+		{"<html>", env.SmokeTest{JSONPath: "ready"}, "the response isn't JSON: "},
+		{`{"checks": [{"ok": true}]}`, env.SmokeTest{JSONPath: "checks.1.ok"}, "the response has nothing at checks.1.ok"},
+		{`{"checks": [{"ok": false}]}`, env.SmokeTest{JSONPath: "checks.0.ok", JSONValue: "true"},
+			"expected checks.0.ok to be 'true', got 'false'"},
+	}
+	for _, test := range tests {
+		suite.responses = []func(http.ResponseWriter){respond(http.StatusOK, test.response)}
+		test.test.URL, test.test.Status = suite.server.URL, 200
+		s.tests = env.SmokeTests{test.test}
+		suite.Require().NoError(s.Prepare())
+
+		err := s.check(s.tests[0], s.bodies[0])
+		suite.Require().Error(err)
+		suite.Contains(err.Error(), test.err)
+	}
+}
+
+func (suite *SmokeTestTestSuite) TestLookupJSONPath() {
+	doc := map[string]interface{}{
+		"status":  "ok",
+		"version": map[string]interface{}{"major": float64(2), "tags": []interface{}{"stable", "lts"}},
+	}
+	for path, expected := range map[string]string{
+		"status":          "ok",
+		"$.status":        "ok",
+		"version.major":   "2",
+		"version.tags.1":  "lts",
+		"version.tags":    `["stable","lts"]`,
+		"$":               `{"status":"ok","version":{"major":2,"tags":["stable","lts"]}}`,
+		"version.major.x": "",
+		"version.tags.2":  "",
+		"missing":         "",
+	} {
+		value, found := lookupJSONPath(doc, path)
+		suite.Equal(expected != "", found, path)
+		suite.Equal(expected, value, path)
+	}
+}
+
+func (suite *SmokeTestTestSuite) TestRollback() {
+	calls, restore := scriptedCommands(suite.ctrl, scriptedRun{stdout: exportedStatus}, scriptedRun{})
+	defer restore()
+
+	suite.responses = []func(http.ResponseWriter){respond(http.StatusInternalServerError, "")}
+	cfg := suite.config(env.SmokeTest{URL: suite.server.URL, Status: 200})
+	cfg.SmokeTestTimeout, cfg.SmokeTestRollback, cfg.Wait, cfg.Timeout = "1s", true, true, "5m"
+	s := NewSmokeTest(cfg)
+	suite.Require().NoError(s.Prepare())
+
+	err := s.Execute()
+	suite.EqualError(err, "smoke test of "+suite.server.URL+" failed after 1 attempt: expected status 200, "+
+		"got 500 Internal Server Error; release edoras was rolled back to revision 11")
+	suite.Equal([][]string{
+		{"--namespace", "rohan", "status", "edoras", "--output", "json"},
+		{"--namespace", "rohan", "rollback", "edoras", "--wait", "--timeout", "5m"},
+	}, *calls)
+}
+
+func (suite *SmokeTestTestSuite) TestRollbackFailures() {
+	suite.responses = []func(http.ResponseWriter){respond(http.StatusInternalServerError, "")}
+	cfg := suite.config(env.SmokeTest{URL: suite.server.URL, Status: 200})
+	cfg.SmokeTestTimeout, cfg.SmokeTestRollback = "1s", true
+	s := NewSmokeTest(cfg)
+	suite.Require().NoError(s.Prepare())
+
+	_, restore := scriptedCommands(suite.ctrl, scriptedRun{stdout: `{"name": "edoras", "version": 1}`})
+	err := s.Execute()
+	restore()
+	suite.Require().Error(err)
+	suite.True(strings.HasSuffix(err.Error(), "; release edoras has no earlier revision to roll back to"))
+
+	_, restore = scriptedCommands(suite.ctrl, scriptedRun{stdout: exportedStatus},
+		scriptedRun{stderr: "Error: release has no 11 version", err: errors.New("exit status 1")})
+	err = s.Execute()
+	restore()
+	suite.Require().Error(err)
+	suite.True(strings.HasSuffix(err.Error(), "; could not roll back: exit status 1"), err.Error())
+}
@@ -0,0 +1,114 @@
+package run
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+var manifestSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// releaseStatus is the part of the output of `helm status --output json` that drone-helm3 uses.
+type releaseStatus struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Version   int    `json:"version"`
+	Chart     struct {
+		Metadata struct {
+			Name       string `json:"name"`
+			Version    string `json:"version"`
+			AppVersion string `json:"appVersion"`
+		} `json:"metadata"`
+	} `json:"chart"`
+	Info struct {
+		Status        string `json:"status"`
+		Description   string `json:"description"`
+		FirstDeployed string `json:"first_deployed"`
+		LastDeployed  string `json:"last_deployed"`
+	} `json:"info"`
+}
+
+// getReleaseStatus runs `helm status` for the given release. It returns nil, without an error, if the release
+// doesn't exist.
+func getReleaseStatus(cfg *config, release string) (*releaseStatus, error) {
+	args := cfg.globalFlags()
+	args = append(args, "status", release, "--output", "json")
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	c := command(helmBin, args...)
+	c.Stdout(stdout)
+	c.Stderr(stderr)
+	if err := c.Run(); err != nil {
+		if strings.Contains(stderr.String(), "release: not found") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not get status of release %s: %w: %s", release, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var status releaseStatus
+	if err := json.Unmarshal(stdout.Bytes(), &status); err != nil {
+		return nil, fmt.Errorf("could not parse status of release %s: %w", release, err)
+	}
+	return &status, nil
+}
+
+// revision returns the release's current revision, or 0 if the release doesn't exist.
+func (s *releaseStatus) revision() int {
+	if s == nil {
+		return 0
+	}
+	return s.Version
+}
+
+// A manifestResource is one of the resources in a release's manifest.
+type manifestResource struct {
+	id   string // kind/name
+	text string
+}
+
+// getManifest runs `helm get manifest` for the given revision of a release, or its current revision if revision is
+// 0, and splits it into resources.
+func getManifest(cfg *config, release string, revision int) ([]manifestResource, error) {
+	args := cfg.globalFlags()
+	args = append(args, "get", "manifest", release)
+	if revision != 0 {
+		args = append(args, "--revision", strconv.Itoa(revision))
+	}
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	c := command(helmBin, args...)
+	c.Stdout(stdout)
+	c.Stderr(stderr)
+	if err := c.Run(); err != nil {
+		return nil, fmt.Errorf("could not get manifest: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseManifest(stdout.String())
+}
+
+// parseManifest splits a manifest into resources.
+func parseManifest(manifest string) ([]manifestResource, error) {
+	var resources []manifestResource
+	for _, doc := range manifestSeparator.Split(manifest, -1) {
+		var resource struct {
+			Kind     string `yaml:"kind"`
+			Metadata struct {
+				Name string `yaml:"name"`
+			} `yaml:"metadata"`
+		}
+		if err := yaml.Unmarshal([]byte(doc), &resource); err != nil {
+			return resources, fmt.Errorf("could not parse manifest: %w", err)
+		}
+		if resource.Kind != "" {
+			resources = append(resources, manifestResource{
+				id:   resource.Kind + "/" + resource.Metadata.Name,
+				text: strings.TrimSpace(doc),
+			})
+		}
+	}
+	return resources, nil
+}
@@ -0,0 +1,64 @@
+package run
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/stretchr/testify/suite"
+)
+
+type StatusTestSuite struct {
+	suite.Suite
+}
+
+func TestStatusTestSuite(t *testing.T) {
+	suite.Run(t, new(StatusTestSuite))
+}
+
+func (suite *StatusTestSuite) TestGetReleaseStatus() {
+	ctrl := gomock.NewController(suite.T())
+	defer ctrl.Finish()
+	calls, restore := scriptedCommands(ctrl, scriptedRun{
+		stdout: `{"name": "weathertop", "namespace": "eriador", "version": 4, "info": {"status": "deployed", "description": "Upgrade complete"}}`,
+	})
+	defer restore()
+
+	status, err := getReleaseStatus(newConfig(env.Config{Namespace: "eriador"}), "weathertop")
+	suite.Require().NoError(err)
+	suite.Equal([][]string{{"--namespace", "eriador", "status", "weathertop", "--output", "json"}}, *calls)
+	suite.Equal("weathertop", status.Name)
+	suite.Equal(4, status.revision())
+	suite.Equal("deployed", status.Info.Status)
+	suite.Equal("Upgrade complete", status.Info.Description)
+}
+
+func (suite *StatusTestSuite) TestGetReleaseStatusNotFound() {
+	ctrl := gomock.NewController(suite.T())
+	defer ctrl.Finish()
+	_, restore := scriptedCommands(ctrl, scriptedRun{stderr: "Error: release: not found\n", err: errors.New("exit status 1")})
+	defer restore()
+
+	status, err := getReleaseStatus(newConfig(env.Config{}), "amon-sul")
+	suite.NoError(err)
+	suite.Nil(status)
+	suite.Equal(0, status.revision())
+}
+
+func (suite *StatusTestSuite) TestGetReleaseStatusErrors() {
+	ctrl := gomock.NewController(suite.T())
+	defer ctrl.Finish()
+	_, restore := scriptedCommands(ctrl,
+		scriptedRun{stderr: "Error: Kubernetes cluster unreachable\n", err: errors.New("exit status 1")},
+		scriptedRun{stdout: "NAME: weathertop"},
+	)
+	defer restore()
+
+	_, err := getReleaseStatus(newConfig(env.Config{}), "weathertop")
+	suite.EqualError(err, "could not get status of release weathertop: exit status 1: Error: Kubernetes cluster unreachable")
+
+	_, err = getReleaseStatus(newConfig(env.Config{}), "weathertop")
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "could not parse status of release weathertop")
+}
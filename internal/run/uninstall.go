@@ -3,6 +3,7 @@ package run
 import (
 	"fmt"
 	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
 )
 
 // Uninstall is an execution step that calls `helm uninstall` when executed.
@@ -11,6 +12,8 @@ type Uninstall struct {
 	release     string
 	dryRun      bool
 	keepHistory bool
+	errs        *errorCapture
+	args        []string
 	cmd         cmd
 }
 
@@ -21,12 +24,13 @@ func NewUninstall(cfg env.Config) *Uninstall {
 		release:     cfg.Release,
 		dryRun:      cfg.DryRun,
 		keepHistory: cfg.KeepHistory,
+		errs:        newErrorCapture(),
 	}
 }
 
 // Execute executes the `helm uninstall` command.
 func (u *Uninstall) Execute() error {
-	return u.cmd.Run()
+	return u.errs.classify(u.cmd.Run())
 }
 
 // Prepare gets the Uninstall ready to execute.
@@ -34,6 +38,12 @@ func (u *Uninstall) Prepare() error {
 	if u.release == "" {
 		return fmt.Errorf("release is required")
 	}
+	if err := validateReleaseName(u.release); err != nil {
+		return err
+	}
+	if err := validateNamespace(u.namespace); err != nil {
+		return err
+	}
 
 	args := u.globalFlags()
 	args = append(args, "uninstall")
@@ -47,13 +57,19 @@ func (u *Uninstall) Prepare() error {
 
 	args = append(args, u.release)
 
+	u.args = args
 	u.cmd = command(helmBin, args...)
-	u.cmd.Stdout(u.stdout)
-	u.cmd.Stderr(u.stderr)
+	u.cmd.Stdout(logging.Stream(u.stdout, "stdout"))
+	u.cmd.Stderr(u.errs.output(logging.Stream(u.stderr, "stderr")))
 
 	if u.debug {
-		fmt.Fprintf(u.stderr, "Generated command: '%s'\n", u.cmd.String())
+		logging.Debugf(u.stderr, "Generated command: '%s'", u.cmd.String())
 	}
 
 	return nil
 }
+
+// Command returns the `helm uninstall` command.
+func (u *Uninstall) Command() string {
+	return redactCommand(u.args)
+}
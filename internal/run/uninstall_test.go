@@ -37,11 +37,11 @@ func TestUninstallTestSuite(t *testing.T) {
 func (suite *UninstallTestSuite) TestNewUninstall() {
 	cfg := env.Config{
 		DryRun:      true,
-		Release:     "jetta_id_love_to_change_the_world",
+		Release:     "jetta-id-love-to-change-the-world",
 		KeepHistory: true,
 	}
 	u := NewUninstall(cfg)
-	suite.Equal("jetta_id_love_to_change_the_world", u.release)
+	suite.Equal("jetta-id-love-to-change-the-world", u.release)
 	suite.Equal(true, u.dryRun)
 	suite.Equal(true, u.keepHistory)
 	suite.NotNil(u.config)
@@ -51,7 +51,7 @@ func (suite *UninstallTestSuite) TestPrepareAndExecute() {
 	defer suite.ctrl.Finish()
 
 	cfg := env.Config{
-		Release: "zayde_wølf_king",
+		Release: "zayde-wolf-king",
 	}
 	u := NewUninstall(cfg)
 
@@ -72,15 +72,23 @@ func (suite *UninstallTestSuite) TestPrepareAndExecute() {
 		Times(1)
 
 	suite.NoError(u.Prepare())
-	expected := []string{"uninstall", "zayde_wølf_king"}
+	expected := []string{"uninstall", "zayde-wolf-king"}
 	suite.Equal(expected, actual)
 
 	u.Execute()
 }
 
+func (suite *UninstallTestSuite) TestPrepareValidatesNames() {
+	u := NewUninstall(env.Config{Release: "Carpenter Brut"})
+	suite.EqualError(u.Prepare(), "release name 'Carpenter Brut' must consist of lowercase letters, numbers, '-' and '.'")
+
+	u = NewUninstall(env.Config{Release: "carpenter-brut", Namespace: "Trilogy"})
+	suite.EqualError(u.Prepare(), "namespace 'Trilogy' must consist of lowercase letters, numbers and '-'")
+}
+
 func (suite *UninstallTestSuite) TestPrepareDryRunFlag() {
 	cfg := env.Config{
-		Release: "firefox_ak_wildfire",
+		Release: "firefox-ak-wildfire",
 		DryRun:  true,
 	}
 	u := NewUninstall(cfg)
@@ -89,13 +97,13 @@ func (suite *UninstallTestSuite) TestPrepareDryRunFlag() {
 	suite.mockCmd.EXPECT().Stderr(gomock.Any()).AnyTimes()
 
 	suite.NoError(u.Prepare())
-	expected := []string{"uninstall", "--dry-run", "firefox_ak_wildfire"}
+	expected := []string{"uninstall", "--dry-run", "firefox-ak-wildfire"}
 	suite.Equal(expected, suite.actualArgs)
 }
 
 func (suite *UninstallTestSuite) TestPrepareKeepHistoryFlag() {
 	cfg := env.Config{
-		Release:     "perturbator_sentient",
+		Release:     "perturbator-sentient",
 		KeepHistory: true,
 	}
 	u := NewUninstall(cfg)
@@ -104,7 +112,7 @@ func (suite *UninstallTestSuite) TestPrepareKeepHistoryFlag() {
 	suite.mockCmd.EXPECT().Stderr(gomock.Any()).AnyTimes()
 
 	suite.NoError(u.Prepare())
-	expected := []string{"uninstall", "--keep-history", "perturbator_sentient"}
+	expected := []string{"uninstall", "--keep-history", "perturbator-sentient"}
 	suite.Equal(expected, suite.actualArgs)
 }
 
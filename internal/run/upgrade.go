@@ -1,9 +1,11 @@
 package run
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
 )
 
 // Upgrade is an execution step that calls `helm upgrade` when executed.
@@ -25,10 +27,14 @@ type Upgrade struct {
 	cleanupOnFail   bool
 	historyMax      int
 	certs           *repoCerts
+	resolver        *valuesResolver
 	createNamespace bool
 	skipCrds        bool
+	retry           *retryPolicy
+	errs            *errorCapture
 
-	cmd cmd
+	args []string
+	cmd  cmd
 }
 
 // NewUpgrade creates an Upgrade using fields from the given Config. No validation is performed at this time.
@@ -50,14 +56,49 @@ func NewUpgrade(cfg env.Config) *Upgrade {
 		cleanupOnFail:   cfg.CleanupOnFail,
 		historyMax:      cfg.HistoryMax,
 		certs:           newRepoCerts(cfg),
+		resolver:        newValuesResolver(cfg),
 		createNamespace: cfg.CreateNamespace,
 		skipCrds:        cfg.SkipCrds,
+		retry:           newRetryPolicy(cfg),
+		errs:            newErrorCapture(),
 	}
 }
 
 // Execute executes the `helm upgrade` command.
 func (u *Upgrade) Execute() error {
-	return u.cmd.Run()
+	if !u.retry.enabled() {
+		return u.errs.classify(u.cmd.Run())
+	}
+
+	// an upgrade can only be retried safely if helm didn't get as far as creating a new revision. If the revision it
+	// started from can't be found, the upgrade is still run, but a failure can't be retried.
+	before, statusErr := getReleaseStatus(u.config, u.release)
+	if statusErr != nil {
+		logging.Warnf(u.stderr, "the upgrade won't be retried if it fails: %s", statusErr)
+	}
+	return u.errs.classify(u.retry.run(u.cmd, u.args, func() error {
+		if statusErr != nil {
+			return errors.New("the release's revision before the upgrade isn't known")
+		}
+		after, err := getReleaseStatus(u.config, u.release)
+		if err != nil {
+			return err
+		}
+		switch {
+		case after.revision() == before.revision():
+			return nil
+		case after == nil:
+			return errors.New("the release no longer exists")
+		default:
+			return fmt.Errorf("helm had already created revision %d of the release (%s)", after.revision(),
+				after.Info.Status)
+		}
+	}))
+}
+
+// Cleanup removes any decrypted values files created by Prepare.
+func (u *Upgrade) Cleanup() {
+	u.resolver.cleanup()
 }
 
 // Prepare gets the Upgrade ready to execute.
@@ -68,6 +109,15 @@ func (u *Upgrade) Prepare() error {
 	if u.release == "" {
 		return fmt.Errorf("release is required")
 	}
+	if err := validateReleaseName(u.release); err != nil {
+		return err
+	}
+	if err := validateNamespace(u.namespace); err != nil {
+		return err
+	}
+	if err := u.retry.prepare(); err != nil {
+		return err
+	}
 
 	args := u.globalFlags()
 	args = append(args, "upgrade", "--install")
@@ -108,7 +158,11 @@ func (u *Upgrade) Prepare() error {
 	if u.skipCrds {
 		args = append(args, "--skip-crds")
 	}
-	for _, vFile := range u.valuesFiles {
+	valuesFiles, err := u.resolver.resolve(u.valuesFiles)
+	if err != nil {
+		return err
+	}
+	for _, vFile := range valuesFiles {
 		args = append(args, "--values", vFile)
 	}
 	args = append(args, u.certs.flags()...)
@@ -117,13 +171,19 @@ func (u *Upgrade) Prepare() error {
 	args = append(args, fmt.Sprintf("--history-max=%d", u.historyMax))
 
 	args = append(args, u.release, u.chart)
+	u.args = args
 	u.cmd = command(helmBin, args...)
-	u.cmd.Stdout(u.stdout)
-	u.cmd.Stderr(u.stderr)
+	u.cmd.Stdout(logging.Stream(u.stdout, "stdout"))
+	u.cmd.Stderr(u.retry.output(logging.Stream(u.stderr, "stderr"), u.errs))
 
 	if u.debug {
-		fmt.Fprintf(u.stderr, "Generated command: '%s'\n", u.cmd.String())
+		logging.Debugf(u.stderr, "Generated command: '%s'", u.cmd.String())
 	}
 
 	return nil
 }
+
+// Command returns the `helm upgrade` command, with any --set and --set-string values hidden.
+func (u *Upgrade) Command() string {
+	return redactCommand(u.args)
+}
@@ -1,9 +1,11 @@
 package run
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/pelotech/drone-helm3/internal/env"
@@ -44,7 +46,7 @@ func (suite *UpgradeTestSuite) TestNewUpgrade() {
 	cfg.ReuseValues = true
 	cfg.Timeout = "go sit in the corner"
 	cfg.Chart = "billboard_top_100"
-	cfg.Release = "post_malone_circles"
+	cfg.Release = "post-malone-circles"
 	cfg.Force = true
 	cfg.AtomicUpgrade = true
 	cfg.CleanupOnFail = true
@@ -73,13 +75,13 @@ func (suite *UpgradeTestSuite) TestPrepareAndExecute() {
 
 	cfg := env.NewTestConfig(suite.T())
 	cfg.Chart = "at40"
-	cfg.Release = "jonas_brothers_only_human"
+	cfg.Release = "jonas-brothers-only-human"
 
 	u := NewUpgrade(*cfg)
 
 	command = func(path string, args ...string) cmd {
 		suite.Equal(helmBin, path)
-		suite.Equal([]string{"upgrade", "--install", "--history-max=10", "jonas_brothers_only_human", "at40"}, args)
+		suite.Equal([]string{"upgrade", "--install", "--history-max=10", "jonas-brothers-only-human", "at40"}, args)
 
 		return suite.mockCmd
 	}
@@ -97,19 +99,37 @@ func (suite *UpgradeTestSuite) TestPrepareAndExecute() {
 	u.Execute()
 }
 
+func (suite *UpgradeTestSuite) TestCommand() {
+	defer suite.ctrl.Finish()
+	suite.mockCmd.EXPECT().Stdout(gomock.Any())
+	suite.mockCmd.EXPECT().Stderr(gomock.Any())
+
+	cfg := env.NewTestConfig(suite.T())
+	cfg.Chart = "at40"
+	cfg.Release = "jonas-brothers-only-human"
+	cfg.Values = "apiKey=sucker"
+	cfg.StringValues = "password=cool"
+
+	u := NewUpgrade(*cfg)
+	suite.Equal("", u.Command(), "there's no command until the Upgrade is prepared")
+	suite.Require().NoError(u.Prepare())
+	suite.Equal(helmBin+" upgrade --install --set (redacted) --set-string (redacted) --history-max=10 "+
+		"jonas-brothers-only-human at40", u.Command())
+}
+
 func (suite *UpgradeTestSuite) TestPrepareNamespaceFlag() {
 	defer suite.ctrl.Finish()
 
 	cfg := env.NewTestConfig(suite.T())
 	cfg.Namespace = "melt"
 	cfg.Chart = "at40"
-	cfg.Release = "shaed_trampoline"
+	cfg.Release = "shaed-trampoline"
 
 	u := NewUpgrade(*cfg)
 
 	command = func(path string, args ...string) cmd {
 		suite.Equal(helmBin, path)
-		suite.Equal([]string{"--namespace", "melt", "upgrade", "--install", "--history-max=10", "shaed_trampoline", "at40"}, args)
+		suite.Equal([]string{"--namespace", "melt", "upgrade", "--install", "--history-max=10", "shaed-trampoline", "at40"}, args)
 
 		return suite.mockCmd
 	}
@@ -126,7 +146,7 @@ func (suite *UpgradeTestSuite) TestPrepareWithUpgradeFlags() {
 
 	cfg := env.NewTestConfig(suite.T())
 	cfg.Chart = "hot_ac"
-	cfg.Release = "maroon_5_memories"
+	cfg.Release = "maroon-5-memories"
 	cfg.ChartVersion = "radio_edit"
 	cfg.DryRun = true
 	cfg.Wait = true
@@ -160,7 +180,7 @@ func (suite *UpgradeTestSuite) TestPrepareWithUpgradeFlags() {
 			"--values", "/usr/local/grades",
 			"--ca-file", "local_ca.cert",
 			"--history-max=10",
-			"maroon_5_memories", "hot_ac"}, args)
+			"maroon-5-memories", "hot_ac"}, args)
 
 		return suite.mockCmd
 	}
@@ -178,7 +198,7 @@ func (suite *UpgradeTestSuite) TestRequiresChartAndRelease() {
 	suite.mockCmd.EXPECT().Stderr(gomock.Any()).AnyTimes()
 
 	u := NewUpgrade(env.Config{})
-	u.release = "seth_everman_unskippable_cutscene"
+	u.release = "seth-everman-unskippable-cutscene"
 
 	err := u.Prepare()
 	suite.EqualError(err, "chart is required", "Chart should be mandatory")
@@ -190,13 +210,21 @@ func (suite *UpgradeTestSuite) TestRequiresChartAndRelease() {
 	suite.EqualError(err, "release is required", "Release should be mandatory")
 }
 
+func (suite *UpgradeTestSuite) TestPrepareValidatesNames() {
+	u := NewUpgrade(env.Config{Chart: "at40", Release: "Hozier_Take_Me_To_Church"})
+	suite.EqualError(u.Prepare(), "release name 'Hozier_Take_Me_To_Church' must consist of lowercase letters, numbers, '-' and '.'")
+
+	u = NewUpgrade(env.Config{Chart: "at40", Release: "hozier", Namespace: "church_of_hozier"})
+	suite.EqualError(u.Prepare(), "namespace 'church_of_hozier' must consist of lowercase letters, numbers and '-'")
+}
+
 func (suite *UpgradeTestSuite) TestPrepareDebugFlag() {
 	stdout := strings.Builder{}
 	stderr := strings.Builder{}
 
 	cfg := env.NewTestConfig(suite.T())
 	cfg.Chart = "at40"
-	cfg.Release = "lewis_capaldi_someone_you_loved"
+	cfg.Release = "lewis-capaldi-someone-you-loved"
 	cfg.Debug = true
 	cfg.Stdout = &stdout
 	cfg.Stderr = &stderr
@@ -214,12 +242,12 @@ func (suite *UpgradeTestSuite) TestPrepareDebugFlag() {
 	suite.mockCmd.EXPECT().
 		Stdout(&stdout)
 	suite.mockCmd.EXPECT().
-		Stderr(&stderr)
+		Stderr(writesTo(&stderr))
 
 	u.Prepare()
 
 	want := fmt.Sprintf(
-		"Generated command: '%s --debug upgrade --install --history-max=10 lewis_capaldi_someone_you_loved at40'\n",
+		"Generated command: '%s --debug upgrade --install --history-max=10 lewis-capaldi-someone-you-loved at40'\n",
 		helmBin,
 	)
 	suite.Equal(want, stderr.String())
@@ -231,14 +259,14 @@ func (suite *UpgradeTestSuite) TestPrepareSkipCrdsFlag() {
 
 	cfg := env.NewTestConfig(suite.T())
 	cfg.Chart = "at40"
-	cfg.Release = "cabbages_smell_great"
+	cfg.Release = "cabbages-smell-great"
 	cfg.SkipCrds = true
 
 	u := NewUpgrade(*cfg)
 
 	command = func(path string, args ...string) cmd {
 		suite.Equal(helmBin, path)
-		suite.Equal([]string{"upgrade", "--install", "--skip-crds", "--history-max=10", "cabbages_smell_great", "at40"}, args)
+		suite.Equal([]string{"upgrade", "--install", "--skip-crds", "--history-max=10", "cabbages-smell-great", "at40"}, args)
 
 		return suite.mockCmd
 	}
@@ -249,3 +277,117 @@ func (suite *UpgradeTestSuite) TestPrepareSkipCrdsFlag() {
 	err := u.Prepare()
 	suite.Require().Nil(err)
 }
+
+func (suite *UpgradeTestSuite) TestExecuteRetriesWhenNoRevisionWasCreated() {
+	defer suite.ctrl.Finish()
+
+	cfg := env.NewTestConfig(suite.T())
+	cfg.Chart = "at40"
+	cfg.Release = "lizzo-juice"
+	cfg.Retries = 2
+	stderr := &strings.Builder{}
+	cfg.Stderr = stderr
+
+	originalSleep := sleep
+	defer func() { sleep = originalSleep }()
+	sleep = func(time.Duration) {}
+
+	status := `{"name": "lizzo-juice", "version": 3, "info": {"status": "deployed"}}`
+	calls, restore := scriptedCommands(suite.ctrl,
+		scriptedRun{stderr: "Error: dial tcp: i/o timeout\n", err: errors.New("exit status 1")},
+		scriptedRun{stdout: status},
+		scriptedRun{stdout: status},
+		scriptedRun{},
+	)
+	defer restore()
+
+	u := NewUpgrade(*cfg)
+	suite.Require().NoError(u.Prepare())
+	suite.NoError(u.Execute())
+
+	suite.Require().Len(*calls, 4)
+	suite.Equal("status", (*calls)[1][0])
+	suite.Equal((*calls)[0], (*calls)[3], "the upgrade should be run again")
+	suite.Contains(stderr.String(), "retrying in 5s")
+}
+
+func (suite *UpgradeTestSuite) TestExecuteDoesNotRetryWhenARevisionWasCreated() {
+	defer suite.ctrl.Finish()
+
+	cfg := env.NewTestConfig(suite.T())
+	cfg.Chart = "at40"
+	cfg.Release = "lizzo-juice"
+	cfg.Retries = 2
+	stderr := &strings.Builder{}
+	cfg.Stderr = stderr
+
+	calls, restore := scriptedCommands(suite.ctrl,
+		scriptedRun{stderr: "Error: dial tcp: i/o timeout\n", err: errors.New("exit status 1")},
+		scriptedRun{stderr: "Error: release: not found\n", err: errors.New("exit status 1")},
+		scriptedRun{stdout: `{"name": "lizzo-juice", "version": 1, "info": {"status": "pending-install"}}`},
+	)
+	defer restore()
+
+	u := NewUpgrade(*cfg)
+	suite.Require().NoError(u.Prepare())
+	suite.EqualError(u.Execute(), "exit status 1")
+
+	suite.Len(*calls, 3)
+	suite.Contains(stderr.String(), "but it can't be retried: helm had already created revision 1 of the release (pending-install)")
+}
+
+func (suite *UpgradeTestSuite) TestExecuteWhenTheStatusIsUnknown() {
+	defer suite.ctrl.Finish()
+
+	cfg := env.NewTestConfig(suite.T())
+	cfg.Chart = "at40"
+	cfg.Release = "lizzo-juice"
+	cfg.Retries = 2
+	stderr := &strings.Builder{}
+	cfg.Stderr = stderr
+
+	unreachable := scriptedRun{stderr: "Error: Kubernetes cluster unreachable: connection refused\n",
+		err: errors.New("exit status 1")}
+	calls, restore := scriptedCommands(suite.ctrl, scriptedRun{}, unreachable)
+	u := NewUpgrade(*cfg)
+	suite.Require().NoError(u.Prepare())
+	suite.NoError(u.Execute(), "the upgrade should still be run")
+	restore()
+	suite.Len(*calls, 2)
+	suite.Contains(stderr.String(),
+		"the upgrade won't be retried if it fails: could not get status of release lizzo-juice")
+
+	calls, restore = scriptedCommands(suite.ctrl,
+		scriptedRun{stderr: "Error: dial tcp: i/o timeout\n", err: errors.New("exit status 1")},
+		unreachable,
+	)
+	defer restore()
+	u = NewUpgrade(*cfg)
+	suite.Require().NoError(u.Prepare())
+	suite.EqualError(u.Execute(), "exit status 1")
+	suite.Len(*calls, 2)
+	suite.Contains(stderr.String(), "but it can't be retried: the release's revision before the upgrade isn't known")
+}
+
+func (suite *UpgradeTestSuite) TestExecuteDoesNotRetryWhenTheReleaseIsGone() {
+	defer suite.ctrl.Finish()
+
+	cfg := env.NewTestConfig(suite.T())
+	cfg.Chart = "at40"
+	cfg.Release = "lizzo-juice"
+	cfg.Retries = 2
+	stderr := &strings.Builder{}
+	cfg.Stderr = stderr
+
+	_, restore := scriptedCommands(suite.ctrl,
+		scriptedRun{stderr: "Error: dial tcp: i/o timeout\n", err: errors.New("exit status 1")},
+		scriptedRun{stdout: `{"name": "lizzo-juice", "version": 3, "info": {"status": "deployed"}}`},
+		scriptedRun{stderr: "Error: release: not found\n", err: errors.New("exit status 1")},
+	)
+	defer restore()
+
+	u := NewUpgrade(*cfg)
+	suite.Require().NoError(u.Prepare())
+	suite.EqualError(u.Execute(), "exit status 1")
+	suite.Contains(stderr.String(), "but it can't be retried: the release no longer exists")
+}
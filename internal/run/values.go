@@ -0,0 +1,245 @@
+package run
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"gopkg.in/yaml.v2"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/pelotech/drone-helm3/internal/logging"
+	"github.com/pelotech/drone-helm3/internal/sops"
+)
+
+const (
+	secretsPrefix = "secrets://"
+	remotePrefix  = "https://"
+	sha256Pin     = "sha256="
+)
+
+var valuesDownloadTimeout = time.Minute
+
+// valuesResolver turns values_files entries into paths that helm can read. Entries that are sops-encrypted are
+// decrypted, and entries that are https URLs are downloaded, into temporary files which are removed by cleanup.
+type valuesResolver struct {
+	*config
+	ageKey     string
+	ageKeyFile string
+	certs      *repoCerts
+	identities []age.Identity
+	tempFiles  []string
+	secrets    []string // the values in decrypted files
+}
+
+func newValuesResolver(cfg env.Config) *valuesResolver {
+	return &valuesResolver{
+		config:     newConfig(cfg),
+		ageKey:     cfg.AgeKey,
+		ageKeyFile: cfg.AgeKeyFile,
+		certs:      newRepoCerts(cfg),
+	}
+}
+
+// resolve returns the paths to pass to helm's --values flag.
+func (vr *valuesResolver) resolve(files []string) ([]string, error) {
+	resolved := make([]string, 0, len(files))
+	for _, file := range files {
+		path, err := vr.resolveOne(file)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, path)
+	}
+	return resolved, nil
+}
+
+func (vr *valuesResolver) resolveOne(file string) (string, error) {
+	if strings.HasPrefix(file, remotePrefix) {
+		return vr.fetch(file)
+	}
+
+	marked := strings.HasPrefix(file, secretsPrefix)
+	path := strings.TrimPrefix(file, secretsPrefix)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if marked {
+			return "", fmt.Errorf("could not read secret values file: %w", err)
+		}
+		// let helm report on missing or unreadable files, as it always has
+		return path, nil
+	}
+
+	if !marked && !sops.IsEncrypted(data) {
+		return path, nil
+	}
+
+	return vr.decrypt(path, data)
+}
+
+func (vr *valuesResolver) decrypt(source string, data []byte) (string, error) {
+	ids, err := vr.loadIdentities()
+	if err != nil {
+		return "", err
+	}
+	plain, err := sops.Decrypt(data, ids...)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt %s: %w", source, err)
+	}
+	// a file that isn't YAML will be reported by helm; its values just can't be hidden
+	var values interface{}
+	if yaml.Unmarshal(plain, &values) == nil {
+		vr.secrets = appendScalars(vr.secrets, values)
+	}
+	return vr.writeTemp(source, plain)
+}
+
+// appendScalars appends the string form of each of the scalars in a YAML document.
+func appendScalars(scalars []string, node interface{}) []string {
+	switch node := node.(type) {
+	case nil:
+	case map[interface{}]interface{}:
+		for _, value := range node {
+			scalars = appendScalars(scalars, value)
+		}
+	case []interface{}:
+		for _, value := range node {
+			scalars = appendScalars(scalars, value)
+		}
+	default:
+		scalars = append(scalars, fmt.Sprint(node))
+	}
+	return scalars
+}
+
+// fetch downloads a remote values file. If the URL's fragment is of the form `sha256=<hex digest>`, the download must
+// match that digest.
+func (vr *valuesResolver) fetch(rawURL string) (string, error) {
+	location, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("bad values file URL: %w", err)
+	}
+	pin := location.Fragment
+	location.Fragment = ""
+	source := location.Redacted()
+	if pin != "" && !strings.HasPrefix(pin, sha256Pin) {
+		return "", fmt.Errorf("unsupported checksum '%s' for %s: only sha256 is supported", pin, source)
+	}
+
+	client, err := vr.httpClient()
+	if err != nil {
+		return "", err
+	}
+	if vr.debug {
+		logging.Debugf(vr.stderr, "downloading values file from %s", source)
+	}
+	resp, err := client.Get(location.String())
+	if err != nil {
+		return "", fmt.Errorf("could not download values file: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not download values file from %s: %s", source, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("could not download values file from %s: %w", source, err)
+	}
+
+	if pin != "" {
+		digest := sha256.Sum256(data)
+		want := strings.ToLower(strings.TrimPrefix(pin, sha256Pin))
+		if got := hex.EncodeToString(digest[:]); got != want {
+			return "", fmt.Errorf("checksum mismatch for %s: expected sha256 %s, got %s", source, want, got)
+		}
+	}
+
+	if sops.IsEncrypted(data) {
+		return vr.decrypt(source, data)
+	}
+	return vr.writeTemp(source, data)
+}
+
+func (vr *valuesResolver) httpClient() (*http.Client, error) {
+	pool, err := vr.certs.certPool()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Timeout: valuesDownloadTimeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}, nil
+}
+
+func (vr *valuesResolver) loadIdentities() ([]age.Identity, error) {
+	if vr.identities != nil {
+		return vr.identities, nil
+	}
+
+	var keys io.Reader
+	switch {
+	case vr.ageKey != "":
+		keys = strings.NewReader(vr.ageKey)
+	case vr.ageKeyFile != "":
+		file, err := os.Open(vr.ageKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not open age key file: %w", err)
+		}
+		defer file.Close()
+		keys = file
+	default:
+		return nil, errors.New("age_key or age_key_file is required to decrypt secret values files")
+	}
+
+	ids, err := sops.ParseIdentities(keys)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse age key: %w", err)
+	}
+	vr.identities = ids
+	return ids, nil
+}
+
+// writeTemp stores values in a file that only the current user can read.
+func (vr *valuesResolver) writeTemp(source string, contents []byte) (string, error) {
+	file, err := ioutil.TempFile("", "values********.yaml")
+	if err != nil {
+		return "", fmt.Errorf("failed to create values file: %w", err)
+	}
+	defer file.Close()
+	vr.tempFiles = append(vr.tempFiles, file.Name())
+
+	if err := file.Chmod(0600); err != nil {
+		return "", fmt.Errorf("failed to restrict values file permissions: %w", err)
+	}
+	if vr.debug {
+		logging.Debugf(vr.stderr, "writing contents of %s to %s", source, file.Name())
+	}
+	if _, err := file.Write(contents); err != nil {
+		return "", fmt.Errorf("failed to write values file: %w", err)
+	}
+	return file.Name(), nil
+}
+
+// cleanup removes any temporary files created by resolve.
+func (vr *valuesResolver) cleanup() {
+	for _, name := range vr.tempFiles {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			logging.Warnf(vr.stderr, "could not remove %s: %s", name, err)
+		}
+	}
+	vr.tempFiles = nil
+}
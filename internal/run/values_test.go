@@ -0,0 +1,218 @@
+package run
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pelotech/drone-helm3/internal/env"
+	"github.com/stretchr/testify/suite"
+)
+
+type ValuesResolverTestSuite struct {
+	suite.Suite
+	tempDir   string
+	ageKey    string // a key that exists solely for testing
+	document  string // encrypted with ageKey
+	plaintext string // the decrypted form of document
+}
+
+// SetupSuite loads the sops package's test documents, which were made with `age-keygen` and `sops encrypt --age`.
+func (suite *ValuesResolverTestSuite) SetupSuite() {
+	fixture := func(name string) string {
+		contents, err := ioutil.ReadFile("../sops/testdata/" + name)
+		suite.Require().NoError(err)
+		return string(contents)
+	}
+	suite.ageKey = strings.TrimSpace(fixture("keys.txt"))
+	suite.document = fixture("secrets.yaml")
+	suite.plaintext = fixture("plaintext.yaml")
+}
+
+func (suite *ValuesResolverTestSuite) BeforeTest(_, _ string) {
+	var err error
+	suite.tempDir, err = ioutil.TempDir("", "values_resolver_test")
+	suite.Require().NoError(err)
+}
+
+func (suite *ValuesResolverTestSuite) AfterTest(_, _ string) {
+	if suite.tempDir != "" {
+		suite.NoError(os.RemoveAll(suite.tempDir))
+	}
+}
+
+func TestValuesResolverTestSuite(t *testing.T) {
+	suite.Run(t, new(ValuesResolverTestSuite))
+}
+
+func (suite *ValuesResolverTestSuite) writeFile(name, contents string) string {
+	path := suite.tempDir + "/" + name
+	suite.Require().NoError(ioutil.WriteFile(path, []byte(contents), 0644))
+	return path
+}
+
+func (suite *ValuesResolverTestSuite) TestNewValuesResolver() {
+	vr := newValuesResolver(env.Config{AgeKey: "AGE-SECRET-KEY-1", AgeKeyFile: "/etc/age/keys.txt"})
+	suite.Equal("AGE-SECRET-KEY-1", vr.ageKey)
+	suite.Equal("/etc/age/keys.txt", vr.ageKeyFile)
+	suite.NotNil(vr.config)
+}
+
+func (suite *ValuesResolverTestSuite) TestResolvePlainFiles() {
+	plain := suite.writeFile("plain.yml", "replicas: 3\n")
+	vr := newValuesResolver(env.Config{})
+
+	resolved, err := vr.resolve([]string{plain, "/nonexistent/values.yml"})
+	suite.Require().NoError(err)
+	suite.Equal([]string{plain, "/nonexistent/values.yml"}, resolved)
+	suite.Empty(vr.tempFiles)
+}
+
+func (suite *ValuesResolverTestSuite) TestResolveEncryptedFile() {
+	encrypted := suite.writeFile("secrets.yml", suite.document)
+	vr := newValuesResolver(env.Config{AgeKey: suite.ageKey})
+
+	resolved, err := vr.resolve([]string{encrypted})
+	suite.Require().NoError(err)
+	suite.Require().Len(resolved, 1)
+	suite.NotEqual(encrypted, resolved[0])
+
+	info, err := os.Stat(resolved[0])
+	suite.Require().NoError(err)
+	suite.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	contents, err := ioutil.ReadFile(resolved[0])
+	suite.Require().NoError(err)
+	suite.Equal(suite.plaintext, string(contents))
+	suite.ElementsMatch([]string{"1.2.3", "hunter2", "5432", "0.5", "true", "db-a", "db-b", "visible"}, vr.secrets,
+		"the decrypted values should be known, so that they can be hidden")
+
+	vr.cleanup()
+	_, err = os.Stat(resolved[0])
+	suite.True(os.IsNotExist(err), "decrypted file should be removed by cleanup")
+}
+
+func (suite *ValuesResolverTestSuite) TestResolveWithKeyFile() {
+	encrypted := suite.writeFile("secrets.yml", suite.document)
+	keyFile := suite.writeFile("keys.txt", "# created: today\n"+suite.ageKey+"\n")
+	vr := newValuesResolver(env.Config{AgeKeyFile: keyFile})
+	defer vr.cleanup()
+
+	resolved, err := vr.resolve([]string{"secrets://" + encrypted})
+	suite.Require().NoError(err)
+	contents, err := ioutil.ReadFile(resolved[0])
+	suite.Require().NoError(err)
+	suite.Equal(suite.plaintext, string(contents))
+}
+
+func (suite *ValuesResolverTestSuite) TestResolveWithoutKey() {
+	encrypted := suite.writeFile("secrets.yml", suite.document)
+	vr := newValuesResolver(env.Config{})
+
+	_, err := vr.resolve([]string{encrypted})
+	suite.EqualError(err, "age_key or age_key_file is required to decrypt secret values files")
+}
+
+func (suite *ValuesResolverTestSuite) TestResolveMarkedFileMustExist() {
+	vr := newValuesResolver(env.Config{AgeKey: suite.ageKey})
+
+	_, err := vr.resolve([]string{"secrets:///nonexistent/secrets.yml"})
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "could not read secret values file")
+}
+
+func (suite *ValuesResolverTestSuite) TestResolveMarkedFileMustBeEncrypted() {
+	plain := suite.writeFile("plain.yml", "replicas: 3\n")
+	vr := newValuesResolver(env.Config{AgeKey: suite.ageKey})
+
+	_, err := vr.resolve([]string{"secrets://" + plain})
+	suite.EqualError(err, "could not decrypt "+plain+": document has no sops metadata")
+}
+
+func (suite *ValuesResolverTestSuite) serveValues(contents string) (*httptest.Server, env.Config) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/baseline/values.yml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(contents))
+	}))
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	cfg := env.Config{
+		RepoCACertificate: base64.StdEncoding.EncodeToString(caCert),
+		AgeKey:            suite.ageKey,
+	}
+	return server, cfg
+}
+
+func (suite *ValuesResolverTestSuite) TestResolveRemoteFile() {
+	server, cfg := suite.serveValues("replicas: 3\n")
+	defer server.Close()
+	vr := newValuesResolver(cfg)
+
+	resolved, err := vr.resolve([]string{server.URL + "/baseline/values.yml"})
+	suite.Require().NoError(err)
+	suite.Require().Len(resolved, 1)
+
+	contents, err := ioutil.ReadFile(resolved[0])
+	suite.Require().NoError(err)
+	suite.Equal("replicas: 3\n", string(contents))
+
+	vr.cleanup()
+	_, err = os.Stat(resolved[0])
+	suite.True(os.IsNotExist(err), "downloaded file should be removed by cleanup")
+}
+
+func (suite *ValuesResolverTestSuite) TestResolveRemoteFileWithChecksum() {
+	server, cfg := suite.serveValues("replicas: 3\n")
+	defer server.Close()
+	vr := newValuesResolver(cfg)
+	defer vr.cleanup()
+
+	digest := sha256.Sum256([]byte("replicas: 3\n"))
+	pinned := server.URL + "/baseline/values.yml#sha256=" + hex.EncodeToString(digest[:])
+	_, err := vr.resolve([]string{pinned})
+	suite.NoError(err)
+
+	_, err = vr.resolve([]string{server.URL + "/baseline/values.yml#sha256=0123abcd"})
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "checksum mismatch")
+
+	_, err = vr.resolve([]string{server.URL + "/baseline/values.yml#md5=0123abcd"})
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "only sha256 is supported")
+}
+
+func (suite *ValuesResolverTestSuite) TestResolveRemoteEncryptedFile() {
+	server, cfg := suite.serveValues(suite.document)
+	defer server.Close()
+	vr := newValuesResolver(cfg)
+	defer vr.cleanup()
+
+	resolved, err := vr.resolve([]string{server.URL + "/baseline/values.yml"})
+	suite.Require().NoError(err)
+	contents, err := ioutil.ReadFile(resolved[0])
+	suite.Require().NoError(err)
+	suite.Equal(suite.plaintext, string(contents))
+}
+
+func (suite *ValuesResolverTestSuite) TestResolveRemoteFileErrors() {
+	server, cfg := suite.serveValues("replicas: 3\n")
+	defer server.Close()
+
+	vr := newValuesResolver(cfg)
+	_, err := vr.resolve([]string{server.URL + "/nonexistent.yml"})
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "404 Not Found")
+
+	vr = newValuesResolver(env.Config{})
+	_, err = vr.resolve([]string{server.URL + "/baseline/values.yml"})
+	suite.Require().Error(err, "the server's certificate shouldn't be trusted without the repo CA")
+}
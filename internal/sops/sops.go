@@ -0,0 +1,315 @@
+package sops
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"gopkg.in/yaml.v2"
+)
+
+const metadataKey = "sops"
+
+var (
+	encryptedValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.*),tag:(.*),type:(.*)\]$`)
+
+	// sops prefixes the MAC with these bytes when mac_only_encrypted is set, so that the setting can't be toggled
+	// without invalidating the MAC.
+	macOnlyEncryptedInitialization = []byte{0x8a, 0x3f, 0xd2, 0xad, 0x54, 0xce, 0x66, 0x52, 0x7b, 0x10, 0x34, 0xf3, 0xd1, 0x47, 0xbe, 0xb, 0xb, 0x97, 0x5b, 0x3b, 0xf4, 0x4f, 0x72, 0xc6, 0xfd, 0xad, 0xec, 0x81, 0x76, 0xf2, 0x7d, 0x69}
+)
+
+type metadata struct {
+	Age []struct {
+		Recipient string `yaml:"recipient"`
+		Enc       string `yaml:"enc"`
+	} `yaml:"age"`
+	KeyGroups               []interface{} `yaml:"key_groups"`
+	LastModified            string        `yaml:"lastmodified"`
+	MAC                     string        `yaml:"mac"`
+	UnencryptedSuffix       string        `yaml:"unencrypted_suffix"`
+	EncryptedSuffix         string        `yaml:"encrypted_suffix"`
+	UnencryptedRegex        string        `yaml:"unencrypted_regex"`
+	EncryptedRegex          string        `yaml:"encrypted_regex"`
+	UnencryptedCommentRegex string        `yaml:"unencrypted_comment_regex"`
+	EncryptedCommentRegex   string        `yaml:"encrypted_comment_regex"`
+	MACOnlyEncrypted        bool          `yaml:"mac_only_encrypted"`
+}
+
+// IsEncrypted reports whether the given YAML or JSON document carries sops metadata.
+func IsEncrypted(data []byte) bool {
+	var doc map[interface{}]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return false
+	}
+	_, ok := doc[metadataKey]
+	return ok
+}
+
+// ParseIdentities reads age identities in the format produced by `age-keygen`.
+func ParseIdentities(r io.Reader) ([]age.Identity, error) {
+	return age.ParseIdentities(r)
+}
+
+// Decrypt decrypts a sops-encrypted YAML or JSON document using the given age identities. The document's MAC is
+// verified and the plaintext is returned as YAML, without the sops metadata.
+func Decrypt(data []byte, identities ...age.Identity) ([]byte, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("could not parse encrypted document: %w", err)
+	}
+
+	var meta *metadata
+	tree := make(yaml.MapSlice, 0, len(doc))
+	for _, item := range doc {
+		if item.Key != metadataKey {
+			tree = append(tree, item)
+			continue
+		}
+		raw, err := yaml.Marshal(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		meta = &metadata{}
+		if err := yaml.Unmarshal(raw, meta); err != nil {
+			return nil, fmt.Errorf("could not parse sops metadata: %w", err)
+		}
+	}
+	if meta == nil {
+		return nil, errors.New("document has no sops metadata")
+	}
+	if len(meta.KeyGroups) != 0 {
+		return nil, errors.New("sops key groups are not supported")
+	}
+	if meta.UnencryptedCommentRegex != "" || meta.EncryptedCommentRegex != "" {
+		return nil, errors.New("sops comment regexes are not supported")
+	}
+	for _, expr := range []string{meta.UnencryptedRegex, meta.EncryptedRegex} {
+		if _, err := regexp.Compile(expr); err != nil {
+			return nil, fmt.Errorf("invalid regex in sops metadata: %w", err)
+		}
+	}
+
+	key, err := meta.dataKey(identities)
+	if err != nil {
+		return nil, err
+	}
+
+	d := decrypter{meta: meta, key: key, hash: sha512.New()}
+	if meta.MACOnlyEncrypted {
+		d.hash.Write(macOnlyEncryptedInitialization)
+	}
+	plain, err := d.walk(tree, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.verify(); err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(plain)
+}
+
+// dataKey decrypts the document's data key with the first matching age identity.
+func (m *metadata) dataKey(identities []age.Identity) ([]byte, error) {
+	if len(m.Age) == 0 {
+		return nil, errors.New("document was not encrypted with an age key")
+	}
+	if len(identities) == 0 {
+		return nil, errors.New("no age key provided")
+	}
+
+	var err error
+	for _, recipient := range m.Age {
+		var r io.Reader
+		r, err = age.Decrypt(armor.NewReader(strings.NewReader(recipient.Enc)), identities...)
+		if err != nil {
+			continue
+		}
+		return ioutil.ReadAll(r)
+	}
+	return nil, fmt.Errorf("could not decrypt data key: %w", err)
+}
+
+type decrypter struct {
+	meta *metadata
+	key  []byte
+	hash hash.Hash
+}
+
+func (d *decrypter) walk(value interface{}, path []string) (interface{}, error) {
+	switch value := value.(type) {
+	case yaml.MapSlice:
+		out := make(yaml.MapSlice, len(value))
+		for i, item := range value {
+			key, ok := item.Key.(string)
+			if !ok {
+				return nil, fmt.Errorf("non-string key %v", item.Key)
+			}
+			v, err := d.walk(item.Value, append(path[:len(path):len(path)], key))
+			if err != nil {
+				return nil, err
+			}
+			out[i] = yaml.MapItem{Key: key, Value: v}
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(value))
+		for i, item := range value {
+			v, err := d.walk(item, path)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		return out, nil
+	case nil:
+		return nil, nil
+	}
+
+	encrypted := d.meta.shouldBeEncrypted(path)
+	if encrypted {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("value at %s is not encrypted", strings.Join(path, "."))
+		}
+		var err error
+		value, err = decryptValue(s, d.key, strings.Join(path, ":")+":")
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt value at %s: %w", strings.Join(path, "."), err)
+		}
+	}
+	if encrypted || !d.meta.MACOnlyEncrypted {
+		b, err := macBytes(value)
+		if err != nil {
+			return nil, err
+		}
+		d.hash.Write(b)
+	}
+	return value, nil
+}
+
+// verify compares the MAC of the decrypted values with the one stored in the document.
+func (d *decrypter) verify() error {
+	lastModified, err := time.Parse(time.RFC3339, d.meta.LastModified)
+	if err != nil {
+		return fmt.Errorf("could not parse lastmodified: %w", err)
+	}
+	stored, err := decryptValue(d.meta.MAC, d.key, lastModified.Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("could not decrypt MAC: %w", err)
+	}
+
+	computed := fmt.Sprintf("%X", d.hash.Sum(nil))
+	if stored != computed {
+		return errors.New("MAC mismatch; the document may have been tampered with")
+	}
+	return nil
+}
+
+func (m *metadata) shouldBeEncrypted(path []string) bool {
+	encrypted := true
+	if m.UnencryptedSuffix != "" {
+		if anyMatch(path, func(key string) bool { return strings.HasSuffix(key, m.UnencryptedSuffix) }) {
+			encrypted = false
+		}
+	}
+	if m.EncryptedSuffix != "" {
+		encrypted = anyMatch(path, func(key string) bool { return strings.HasSuffix(key, m.EncryptedSuffix) })
+	}
+	if m.UnencryptedRegex != "" {
+		if anyMatch(path, regexp.MustCompile(m.UnencryptedRegex).MatchString) {
+			encrypted = false
+		}
+	}
+	if m.EncryptedRegex != "" {
+		encrypted = anyMatch(path, regexp.MustCompile(m.EncryptedRegex).MatchString)
+	}
+	return encrypted
+}
+
+func anyMatch(path []string, match func(string) bool) bool {
+	for _, key := range path {
+		if match(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func decryptValue(value string, key []byte, additionalData string) (interface{}, error) {
+	if value == "" {
+		return "", nil
+	}
+	matches := encryptedValue.FindStringSubmatch(value)
+	if matches == nil {
+		return nil, errors.New("malformed encrypted value")
+	}
+
+	var parts [3][]byte
+	for i := range parts {
+		decoded, err := base64.StdEncoding.DecodeString(matches[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("could not base64-decode encrypted value: %w", err)
+		}
+		parts[i] = decoded
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return nil, err
+	}
+
+	switch matches[4] {
+	case "str", "bytes":
+		return string(plain), nil
+	case "int":
+		return strconv.Atoi(string(plain))
+	case "float":
+		return strconv.ParseFloat(string(plain), 64)
+	case "bool":
+		return strconv.ParseBool(string(plain))
+	default:
+		return nil, fmt.Errorf("unknown value type '%s'", matches[4])
+	}
+}
+
+// macBytes mirrors the way sops serializes values when computing a document's MAC.
+func macBytes(value interface{}) ([]byte, error) {
+	switch value := value.(type) {
+	case string:
+		return []byte(value), nil
+	case int:
+		return []byte(strconv.Itoa(value)), nil
+	case float64:
+		return []byte(strconv.FormatFloat(value, 'f', -1, 64)), nil
+	case bool:
+		if value {
+			return []byte("True"), nil
+		}
+		return []byte("False"), nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", value)
+	}
+}
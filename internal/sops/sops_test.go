@@ -0,0 +1,76 @@
+package sops
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type SopsTestSuite struct {
+	suite.Suite
+	ageKey    string // a key that exists solely for testing
+	document  string // encrypted with ageKey
+	plaintext string // the decrypted form of document
+}
+
+// SetupSuite loads the test documents, which were made with `age-keygen` and `sops encrypt --age`.
+func (suite *SopsTestSuite) SetupSuite() {
+	fixture := func(name string) string {
+		contents, err := ioutil.ReadFile("testdata/" + name)
+		suite.Require().NoError(err)
+		return string(contents)
+	}
+	suite.ageKey = strings.TrimSpace(fixture("keys.txt"))
+	suite.document = fixture("secrets.yaml")
+	suite.plaintext = fixture("plaintext.yaml")
+}
+
+func TestSopsTestSuite(t *testing.T) {
+	suite.Run(t, new(SopsTestSuite))
+}
+
+func (suite *SopsTestSuite) TestIsEncrypted() {
+	suite.True(IsEncrypted([]byte(suite.document)))
+	suite.False(IsEncrypted([]byte("image:\n  tag: 1.2.3\n")))
+	suite.False(IsEncrypted([]byte("{ not yaml")))
+}
+
+func (suite *SopsTestSuite) TestDecrypt() {
+	ids, err := ParseIdentities(strings.NewReader(suite.ageKey))
+	suite.Require().NoError(err)
+
+	plain, err := Decrypt([]byte(suite.document), ids...)
+	suite.Require().NoError(err)
+
+	suite.Equal(suite.plaintext, string(plain))
+}
+
+func (suite *SopsTestSuite) TestDecryptDetectsTampering() {
+	ids, err := ParseIdentities(strings.NewReader(suite.ageKey))
+	suite.Require().NoError(err)
+
+	tampered := strings.Replace(suite.document, "public_unencrypted: visible", "public_unencrypted: altered", 1)
+	_, err = Decrypt([]byte(tampered), ids...)
+	suite.EqualError(err, "MAC mismatch; the document may have been tampered with")
+}
+
+func (suite *SopsTestSuite) TestDecryptWithWrongKey() {
+	ids, err := ParseIdentities(strings.NewReader("AGE-SECRET-KEY-1GFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPQ4EGAEX"))
+	suite.Require().NoError(err)
+
+	_, err = Decrypt([]byte(suite.document), ids...)
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "could not decrypt data key")
+}
+
+func (suite *SopsTestSuite) TestDecryptWithoutKey() {
+	_, err := Decrypt([]byte(suite.document))
+	suite.EqualError(err, "no age key provided")
+}
+
+func (suite *SopsTestSuite) TestDecryptWithoutMetadata() {
+	_, err := Decrypt([]byte("image:\n  tag: 1.2.3\n"))
+	suite.EqualError(err, "document has no sops metadata")
+}
@@ -0,0 +1,302 @@
+// Package tracing records spans for drone-helm3's work and exports them to an OpenTelemetry collector over OTLP/HTTP.
+// It's configured with the standard OTEL_EXPORTER_OTLP_* environment variables; when no endpoint is set, it does
+// nothing. Spans are held in memory and exported together once the plan is finished.
+package tracing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultServiceName = "drone-helm3"
+
+// OTLP status codes and span kinds.
+const (
+	statusError  = 2
+	kindInternal = 1
+)
+
+var (
+	now           = time.Now
+	exportTimeout = 10 * time.Second
+
+	traceparent = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$`)
+)
+
+// A Tracer collects spans and exports them. A nil *Tracer is valid, and discards everything.
+type Tracer struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	client   *http.Client
+
+	mu    sync.Mutex
+	spans []*Span
+
+	// the trace that the root span joins, if drone-helm3 was given a TRACEPARENT
+	traceID  string
+	parentID string
+}
+
+// A Span is a timed operation. A nil *Span is valid, and ignores everything.
+type Span struct {
+	tracer     *Tracer
+	traceID    string
+	spanID     string
+	parentID   string
+	name       string
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	err        error
+}
+
+// New creates a Tracer from the OTEL_EXPORTER_OTLP_* environment variables. It returns nil if neither
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT nor OTEL_EXPORTER_OTLP_ENDPOINT is set.
+func New() *Tracer {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+	}
+	if endpoint == "" {
+		return nil
+	}
+
+	t := &Tracer{
+		endpoint: endpoint,
+		headers:  parseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")),
+		service:  os.Getenv("OTEL_SERVICE_NAME"),
+		client:   &http.Client{Timeout: exportTimeout},
+	}
+	if t.service == "" {
+		t.service = defaultServiceName
+	}
+	if match := traceparent.FindStringSubmatch(os.Getenv("TRACEPARENT")); match != nil {
+		t.traceID, t.parentID = match[1], match[2]
+	}
+	return t
+}
+
+// parseHeaders reads headers in the OTEL_EXPORTER_OTLP_HEADERS format: comma-separated key=value pairs, URL-encoded.
+// Pairs that can't be decoded are skipped. A "+" is left as it is rather than taken for a space, since it's common in
+// base64-encoded credentials.
+func parseHeaders(value string) map[string]string {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		key, val, found := strings.Cut(pair, "=")
+		if !found {
+			continue
+		}
+		key, keyErr := url.PathUnescape(strings.TrimSpace(key))
+		val, valErr := url.PathUnescape(strings.TrimSpace(val))
+		if keyErr != nil || valErr != nil {
+			continue
+		}
+		headers[key] = val
+	}
+	return headers
+}
+
+// Start begins a span. If parent is nil, the span is the root of the trace.
+func (t *Tracer) Start(name string, parent *Span, attributes map[string]string) *Span {
+	if t == nil {
+		return nil
+	}
+	span := &Span{
+		tracer:     t,
+		spanID:     randomID(8),
+		name:       name,
+		start:      now(),
+		attributes: make(map[string]string),
+	}
+	if parent != nil {
+		span.traceID, span.parentID = parent.traceID, parent.spanID
+	} else if t.traceID != "" {
+		span.traceID, span.parentID = t.traceID, t.parentID
+	} else {
+		span.traceID = randomID(16)
+	}
+	span.SetAttributes(attributes)
+	return span
+}
+
+// SetAttributes adds to the span's attributes. Empty values are left out.
+func (s *Span) SetAttributes(attributes map[string]string) {
+	if s == nil {
+		return
+	}
+	for key, value := range attributes {
+		if value != "" {
+			s.attributes[key] = value
+		}
+	}
+}
+
+// End finishes the span, recording the error if there was one.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.end = now()
+	s.err = err
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.tracer.spans = append(s.tracer.spans, s)
+}
+
+func randomID(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// The OTLP/JSON encoding of an ExportTraceServiceRequest, as much of it as drone-helm3 needs.
+type (
+	exportRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+	resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	resource struct {
+		Attributes []keyValue `json:"attributes"`
+	}
+	scopeSpans struct {
+		Scope scope      `json:"scope"`
+		Spans []spanData `json:"spans"`
+	}
+	scope struct {
+		Name string `json:"name"`
+	}
+	spanData struct {
+		TraceID      string     `json:"traceId"`
+		SpanID       string     `json:"spanId"`
+		ParentSpanID string     `json:"parentSpanId,omitempty"`
+		Name         string     `json:"name"`
+		Kind         int        `json:"kind"`
+		Start        string     `json:"startTimeUnixNano"`
+		End          string     `json:"endTimeUnixNano"`
+		Attributes   []keyValue `json:"attributes,omitempty"`
+		Events       []event    `json:"events,omitempty"`
+		Status       status     `json:"status"`
+	}
+	event struct {
+		Time       string     `json:"timeUnixNano"`
+		Name       string     `json:"name"`
+		Attributes []keyValue `json:"attributes"`
+	}
+	status struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	keyValue struct {
+		Key   string   `json:"key"`
+		Value anyValue `json:"value"`
+	}
+	anyValue struct {
+		StringValue string `json:"stringValue"`
+	}
+)
+
+// Flush exports the spans that have ended. Spans that can't be exported are dropped; the error says why.
+func (t *Tracer) Flush() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(t.request(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not export traces: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not export traces: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("could not export traces: collector responded %s: %s", resp.Status,
+			strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+func (t *Tracer) request(spans []*Span) exportRequest {
+	data := make([]spanData, len(spans))
+	for i, span := range spans {
+		data[i] = spanData{
+			TraceID:      span.traceID,
+			SpanID:       span.spanID,
+			ParentSpanID: span.parentID,
+			Name:         span.name,
+			Kind:         kindInternal,
+			Start:        unixNano(span.start),
+			End:          unixNano(span.end),
+			Attributes:   keyValues(span.attributes),
+		}
+		if span.err != nil {
+			data[i].Status = status{Code: statusError, Message: span.err.Error()}
+			data[i].Events = []event{{
+				Time:       unixNano(span.end),
+				Name:       "exception",
+				Attributes: keyValues(map[string]string{"exception.message": span.err.Error()}),
+			}}
+		}
+	}
+
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: keyValues(map[string]string{"service.name": t.service})},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: defaultServiceName}, Spans: data}},
+	}}}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// keyValues converts attributes to OTLP's format, sorted by key so that the output is stable.
+func keyValues(attributes map[string]string) []keyValue {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]keyValue, len(keys))
+	for i, key := range keys {
+		values[i] = keyValue{Key: key, Value: anyValue{StringValue: attributes[key]}}
+	}
+	return values
+}
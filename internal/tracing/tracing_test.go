@@ -0,0 +1,165 @@
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+var otelVars = []string{"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_HEADERS",
+	"OTEL_SERVICE_NAME", "TRACEPARENT"}
+
+type TracingTestSuite struct {
+	suite.Suite
+	collector *httptest.Server
+	requests  []*http.Request
+	exports   []exportRequest
+	status    int
+	envBackup map[string]*string
+}
+
+func (suite *TracingTestSuite) BeforeTest(_, _ string) {
+	suite.requests, suite.exports, suite.status = nil, nil, http.StatusOK
+	suite.collector = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var export exportRequest
+		suite.Require().NoError(json.NewDecoder(r.Body).Decode(&export))
+		suite.requests = append(suite.requests, r)
+		suite.exports = append(suite.exports, export)
+		w.WriteHeader(suite.status)
+	}))
+
+	suite.envBackup = make(map[string]*string)
+	for _, name := range otelVars {
+		if value, ok := os.LookupEnv(name); ok {
+			suite.envBackup[name] = &value
+		} else {
+			suite.envBackup[name] = nil
+		}
+		os.Unsetenv(name)
+	}
+}
+
+func (suite *TracingTestSuite) AfterTest(_, _ string) {
+	suite.collector.Close()
+	for name, value := range suite.envBackup {
+		if value == nil {
+			os.Unsetenv(name)
+		} else {
+			os.Setenv(name, *value)
+		}
+	}
+}
+
+func TestTracingTestSuite(t *testing.T) {
+	suite.Run(t, new(TracingTestSuite))
+}
+
+func (suite *TracingTestSuite) TestDisabled() {
+	t := New()
+	suite.Nil(t)
+	span := t.Start("the road goes ever on", nil, map[string]string{"helm.release": "bag-end"})
+	suite.Nil(span)
+	span.SetAttributes(map[string]string{"helm.namespace": "shire"})
+	span.End(errors.New("lost the ring"))
+	suite.NoError(t.Flush())
+}
+
+func (suite *TracingTestSuite) TestExport() {
+	os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", suite.collector.URL+"/")
+	os.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "Authorization=Bearer mellon, X-Scope-OrgID=moria")
+	os.Setenv("OTEL_SERVICE_NAME", "deployments")
+
+	originalNow := now
+	defer func() { now = originalNow }()
+	clock := time.Unix(1700000000, 0)
+	now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	t := New()
+	suite.Require().NotNil(t)
+	root := t.Start("drone-helm3", nil, map[string]string{"helm.release": "moria", "helm.chart": ""})
+	child := t.Start("Execute Upgrade", root, nil)
+	child.End(errors.New("the bridge is broken"))
+	root.End(nil)
+	suite.Require().NoError(t.Flush())
+
+	suite.Require().Len(suite.requests, 1)
+	suite.Equal("/v1/traces", suite.requests[0].URL.Path)
+	suite.Equal("application/json", suite.requests[0].Header.Get("Content-Type"))
+	suite.Equal("Bearer mellon", suite.requests[0].Header.Get("Authorization"))
+	suite.Equal("moria", suite.requests[0].Header.Get("X-Scope-OrgID"))
+
+	export := suite.exports[0]
+	suite.Require().Len(export.ResourceSpans, 1)
+	suite.Equal([]keyValue{{Key: "service.name", Value: anyValue{StringValue: "deployments"}}},
+		export.ResourceSpans[0].Resource.Attributes)
+	spans := export.ResourceSpans[0].ScopeSpans[0].Spans
+	suite.Require().Len(spans, 2)
+
+	failed, deployed := spans[0], spans[1]
+	suite.Len(deployed.TraceID, 32)
+	suite.Len(deployed.SpanID, 16)
+	suite.Equal("", deployed.ParentSpanID)
+	suite.Equal("drone-helm3", deployed.Name)
+	suite.Equal("1700000001000000000", deployed.Start)
+	suite.Equal("1700000004000000000", deployed.End)
+	suite.Equal([]keyValue{{Key: "helm.release", Value: anyValue{StringValue: "moria"}}}, deployed.Attributes,
+		"empty attributes should be left out")
+	suite.Equal(status{}, deployed.Status)
+
+	suite.Equal(deployed.TraceID, failed.TraceID)
+	suite.Equal(deployed.SpanID, failed.ParentSpanID)
+	suite.Equal("Execute Upgrade", failed.Name)
+	suite.Equal(status{Code: statusError, Message: "the bridge is broken"}, failed.Status)
+	suite.Equal([]event{{Time: "1700000003000000000", Name: "exception", Attributes: []keyValue{
+		{Key: "exception.message", Value: anyValue{StringValue: "the bridge is broken"}},
+	}}}, failed.Events)
+
+	suite.NoError(t.Flush(), "flushing again should do nothing")
+	suite.Len(suite.requests, 1)
+}
+
+func (suite *TracingTestSuite) TestParseHeaders() {
+	suite.Equal(map[string]string{
+		"Authorization": "Basic dXNlcjpw+YXNz",
+		"X-Scope-OrgID": "moria",
+		"X Fellowship":  "nine walkers",
+	}, parseHeaders("Authorization=Basic%20dXNlcjpw+YXNz, X-Scope-OrgID=moria,X%20Fellowship=nine%20walkers"))
+
+	headers := parseHeaders("Authorization=Basic%zz,X-Scope-OrgID=moria,")
+	suite.Equal(map[string]string{"X-Scope-OrgID": "moria"}, headers, "pairs that can't be decoded should be skipped")
+}
+
+func (suite *TracingTestSuite) TestTracesEndpointAndParent() {
+	os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:1")
+	os.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", suite.collector.URL+"/custom/traces")
+	os.Setenv("TRACEPARENT", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	t := New()
+	t.Start("drone-helm3", nil, nil).End(nil)
+	suite.Require().NoError(t.Flush())
+
+	suite.Equal("/custom/traces", suite.requests[0].URL.Path, "the traces endpoint should be used as it is")
+	span := suite.exports[0].ResourceSpans[0].ScopeSpans[0].Spans[0]
+	suite.Equal("4bf92f3577b34da6a3ce929d0e0e4736", span.TraceID)
+	suite.Equal("00f067aa0ba902b7", span.ParentSpanID)
+}
+
+func (suite *TracingTestSuite) TestExportErrors() {
+	os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", suite.collector.URL)
+	suite.status = http.StatusServiceUnavailable
+
+	t := New()
+	t.Start("drone-helm3", nil, nil).End(nil)
+	err := t.Flush()
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "collector responded 503 Service Unavailable")
+}